@@ -0,0 +1,414 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// ConversionDataAnnotation holds JSON encoded fields of the other API
+	// version, that can't be represented in the version of the annotated
+	// object. It's used to restore those fields on the way back.
+	ConversionDataAnnotation = "terraform.kubeterra.io/conversion-data"
+
+	// mainSourceName is a file name of v1alpha1 configuration in v1beta1 sources
+	mainSourceName = "main.tf"
+)
+
+// ConvertTo converts this TerraformConfiguration to the Hub version (v1beta1)
+func (src *TerraformConfiguration) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.TerraformConfiguration)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	restored := v1beta1.TerraformConfiguration{}
+	hasRestored, err := unmarshalData(&dst.ObjectMeta, &restored)
+	if err != nil {
+		return err
+	}
+
+	dst.Spec = v1beta1.TerraformConfigurationSpec{
+		Mode:        modeFromFlags(src.Spec.Paused, src.Spec.AutoApprove),
+		RepeatEvery: src.Spec.RepeatEvery.DeepCopy(),
+		Sources: []v1beta1.TerraformSource{
+			{Name: mainSourceName, Content: src.Spec.Configuration},
+		},
+		Template: (*v1beta1.TerraformConfigurationTemplate)(src.Spec.Template.DeepCopy()),
+	}
+	if src.Spec.Values != "" {
+		dst.Spec.Variables = &v1beta1.TerraformVariables{File: src.Spec.Values}
+	}
+	dst.Status = v1beta1.TerraformConfigurationStatus{
+		Phase: v1beta1.TerraformPhase(src.Status.Phase),
+	}
+
+	if hasRestored {
+		// v1alpha1 is unable to express following fields, restore them as long
+		// as they are still consistent with v1alpha1 object
+		paused, autoApprove := modeToFlags(restored.Spec.Mode)
+		if paused == src.Spec.Paused && autoApprove == src.Spec.AutoApprove {
+			dst.Spec.Mode = restored.Spec.Mode
+		}
+		if joinSources(restored.Spec.Sources) == src.Spec.Configuration {
+			dst.Spec.Sources = restored.Spec.Sources
+		} else {
+			// configuration is edited, files of modules are kept as is
+			for _, source := range restored.Spec.Sources {
+				if !rootModuleSource(source.Name) {
+					dst.Spec.Sources = append(dst.Spec.Sources, source)
+				}
+			}
+		}
+		if restored.Spec.Variables != nil {
+			dst.Spec.Variables = restored.Spec.Variables
+			dst.Spec.Variables.File = src.Spec.Values
+		}
+		dst.Spec.StateStorage = restored.Spec.StateStorage
+		dst.Status.Conditions = restored.Status.Conditions
+	}
+
+	if src.Spec.Paused && src.Spec.AutoApprove {
+		// v1beta1 Paused mode is unable to remember that auto approval
+		// should happen once configuration is resumed
+		return marshalData(&dst.ObjectMeta, &TerraformConfiguration{Spec: TerraformConfigurationSpec{
+			Paused:      src.Spec.Paused,
+			AutoApprove: src.Spec.AutoApprove,
+		}})
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version
+func (dst *TerraformConfiguration) ConvertFrom(srcRaw conversion.Hub) error { //nolint:stylecheck
+	src := srcRaw.(*v1beta1.TerraformConfiguration)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	restored := TerraformConfiguration{}
+	hasRestored, err := unmarshalData(&dst.ObjectMeta, &restored)
+	if err != nil {
+		return err
+	}
+
+	paused, autoApprove := modeToFlags(src.Spec.Mode)
+	if hasRestored && paused {
+		autoApprove = restored.Spec.AutoApprove
+	}
+
+	dst.Spec = TerraformConfigurationSpec{
+		Paused:        paused,
+		AutoApprove:   autoApprove,
+		RepeatEvery:   src.Spec.RepeatEvery.DeepCopy(),
+		Configuration: joinSources(src.Spec.Sources),
+		Template:      (*TerraformConfigurationTemplate)(src.Spec.Template.DeepCopy()),
+	}
+	if src.Spec.Variables != nil {
+		dst.Spec.Values = src.Spec.Variables.File
+	}
+	dst.Status = TerraformConfigurationStatus{
+		Phase: TerraformPhase(src.Status.Phase),
+	}
+
+	// preserve v1beta1 fields only if they can't be recovered from v1alpha1 alone
+	recovered := v1beta1.TerraformConfiguration{}
+	if err := dst.ConvertTo(&recovered); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(recovered.Spec, src.Spec) && equality.Semantic.DeepEqual(recovered.Status, src.Status) {
+		return nil
+	}
+
+	return marshalData(&dst.ObjectMeta, &v1beta1.TerraformConfiguration{
+		Spec:   *src.Spec.DeepCopy(),
+		Status: *src.Status.DeepCopy(),
+	})
+}
+
+// ConvertTo converts this TerraformPlan to the Hub version (v1beta1)
+func (src *TerraformPlan) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.TerraformPlan)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	restored := v1beta1.TerraformPlan{}
+	hasRestored, err := unmarshalData(&dst.ObjectMeta, &restored)
+	if err != nil {
+		return err
+	}
+
+	dst.Spec = v1beta1.TerraformPlanSpec{
+		Approved:  src.Spec.Approved,
+		NextRunAt: src.Spec.NextRunAt.DeepCopy(),
+	}
+	dst.Status = v1beta1.TerraformPlanStatus{
+		LastRunAt:             src.Status.LastRunAt.DeepCopy(),
+		ConfigurationSpecHash: src.Status.ConfigurationSpecHash,
+		Phase:                 v1beta1.TerraformPhase(src.Status.Phase),
+	}
+
+	if hasRestored {
+		dst.Spec.Review = restored.Spec.Review
+		dst.Status.Conditions = restored.Status.Conditions
+		dst.Status.PlanSummary = restored.Status.PlanSummary
+		dst.Status.PlanOutput = restored.Status.PlanOutput
+		dst.Status.Run = restored.Status.Run
+		dst.Status.AppliedConfigurationSpecHash = restored.Status.AppliedConfigurationSpecHash
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version
+func (dst *TerraformPlan) ConvertFrom(srcRaw conversion.Hub) error { //nolint:stylecheck
+	src := srcRaw.(*v1beta1.TerraformPlan)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	if _, err := unmarshalData(&dst.ObjectMeta, &TerraformPlan{}); err != nil {
+		return err
+	}
+
+	dst.Spec = TerraformPlanSpec{
+		Approved:  src.Spec.Approved,
+		NextRunAt: src.Spec.NextRunAt.DeepCopy(),
+	}
+	dst.Status = TerraformPlanStatus{
+		LastRunAt:             src.Status.LastRunAt.DeepCopy(),
+		ConfigurationSpecHash: src.Status.ConfigurationSpecHash,
+		Phase:                 TerraformPhase(src.Status.Phase),
+	}
+
+	if src.Spec.Review == nil && len(src.Status.Conditions) == 0 && src.Status.PlanSummary == "" && src.Status.PlanOutput == "" && src.Status.Run == 0 &&
+		src.Status.AppliedConfigurationSpecHash == "" {
+		return nil
+	}
+
+	return marshalData(&dst.ObjectMeta, &v1beta1.TerraformPlan{
+		Spec: v1beta1.TerraformPlanSpec{
+			Review: src.Spec.Review.DeepCopy(),
+		},
+		Status: v1beta1.TerraformPlanStatus{
+			Conditions:                   src.Status.DeepCopy().Conditions,
+			PlanSummary:                  src.Status.PlanSummary,
+			PlanOutput:                   src.Status.PlanOutput,
+			Run:                          src.Status.Run,
+			AppliedConfigurationSpecHash: src.Status.AppliedConfigurationSpecHash,
+		},
+	})
+}
+
+// stateConversionData is stored in ConversionDataAnnotation of v1alpha1
+// TerraformState, to restore storage of the state in v1beta1
+type stateConversionData struct {
+	Compressed bool                              `json:"compressed,omitempty"`
+	Chunks     *v1beta1.TerraformStateChunks     `json:"chunks,omitempty"`
+	Encryption *v1beta1.TerraformStateEncryption `json:"encryption,omitempty"`
+	Storage    *v1beta1.TerraformStateStorage    `json:"storage,omitempty"`
+
+	Lock            *v1beta1.TerraformStateLock        `json:"lock,omitempty"`
+	LastForceUnlock *v1beta1.TerraformStateForceUnlock `json:"lastForceUnlock,omitempty"`
+}
+
+// ConvertTo converts this TerraformState to the Hub version (v1beta1)
+func (src *TerraformState) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.TerraformState)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	dst.Spec = v1beta1.TerraformStateSpec{}
+	dst.Status = v1beta1.TerraformStateStatus{
+		LockID:      src.Status.LockID,
+		LockedSince: src.Status.LockedSince.DeepCopy(),
+	}
+
+	data := stateConversionData{}
+	if _, err := unmarshalData(&dst.ObjectMeta, &data); err != nil {
+		return err
+	}
+
+	dst.Spec.Encryption = data.Encryption
+	dst.Spec.Storage = data.Storage
+	dst.Status.LastForceUnlock = data.LastForceUnlock
+	if data.Lock != nil && data.Lock.ID == src.Status.LockID {
+		dst.Status.Lock = data.Lock
+	}
+
+	switch {
+	case src.Spec.State == nil:
+		dst.Spec.Chunks = data.Chunks
+	case data.Compressed:
+		compressed, err := gzipData(src.Spec.State.Raw)
+		if err != nil {
+			return err
+		}
+		dst.Spec.CompressedState = compressed
+	default:
+		dst.Spec.State = src.Spec.State.DeepCopy()
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version. Chunked
+// or encrypted state can't be loaded during conversion, such v1alpha1 objects
+// have no state.
+func (dst *TerraformState) ConvertFrom(srcRaw conversion.Hub) error { //nolint:stylecheck
+	src := srcRaw.(*v1beta1.TerraformState)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	dst.Spec = TerraformStateSpec{}
+	dst.Status = TerraformStateStatus{
+		LockID:      src.Status.LockID,
+		LockedSince: src.Status.LockedSince.DeepCopy(),
+	}
+
+	data := stateConversionData{
+		Encryption:      src.Spec.Encryption.DeepCopy(),
+		Storage:         src.Spec.Storage.DeepCopy(),
+		Lock:            src.Status.Lock.DeepCopy(),
+		LastForceUnlock: src.Status.LastForceUnlock.DeepCopy(),
+	}
+
+	switch {
+	case src.Spec.Chunks != nil:
+		data.Chunks = src.Spec.Chunks.DeepCopy()
+	case len(src.Spec.CompressedState) > 0:
+		raw, err := gunzipData(src.Spec.CompressedState)
+		if err != nil {
+			return err
+		}
+		dst.Spec.State = &runtime.RawExtension{Raw: raw}
+		data.Compressed = true
+	default:
+		dst.Spec.State = src.Spec.State.DeepCopy()
+	}
+
+	if data == (stateConversionData{}) {
+		return nil
+	}
+	return marshalData(&dst.ObjectMeta, data)
+}
+
+func modeFromFlags(paused, autoApprove bool) v1beta1.TerraformMode {
+	switch {
+	case paused:
+		return v1beta1.TerraformModePaused
+	case autoApprove:
+		return v1beta1.TerraformModeAuto
+	default:
+		return v1beta1.TerraformModeManual
+	}
+}
+
+func modeToFlags(mode v1beta1.TerraformMode) (paused, autoApprove bool) {
+	switch mode {
+	case v1beta1.TerraformModePaused:
+		return true, false
+	case v1beta1.TerraformModeAuto:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// joinSources concatenates terraform files of the root module into a single
+// one, terraform treats all *.tf files in the working directory as a single
+// module anyway. Files of local modules and other files are left out, they're
+// only kept in ConversionDataAnnotation.
+func joinSources(sources []v1beta1.TerraformSource) string {
+	contents := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if rootModuleSource(source.Name) {
+			contents = append(contents, source.Content)
+		}
+	}
+	return strings.Join(contents, "\n")
+}
+
+// rootModuleSource tells if the source is HCL file of the root module
+func rootModuleSource(name string) bool {
+	return !strings.Contains(name, "/") && strings.HasSuffix(name, ".tf")
+}
+
+// marshalData stores JSON encoded data in ConversionDataAnnotation
+func marshalData(obj metav1.Object, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ConversionDataAnnotation] = string(buf)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// unmarshalData decodes and removes data stored in ConversionDataAnnotation,
+// it's caller's responsibility to pass in the object of the opposite version.
+func unmarshalData(obj metav1.Object, into interface{}) (bool, error) {
+	annotations := obj.GetAnnotations()
+	data, ok := annotations[ConversionDataAnnotation]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), into); err != nil {
+		return false, err
+	}
+
+	delete(annotations, ConversionDataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+func gzipData(data []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gunzipData(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
@@ -0,0 +1,491 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	"github.com/loodse/kubeterra/api/v1beta1"
+)
+
+var testMeta = metav1.ObjectMeta{
+	Name:        "test",
+	Namespace:   "kubeterra-system",
+	Annotations: map[string]string{"some": "annotation"},
+}
+
+func TestTerraformConfigurationSpokeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  TerraformConfiguration
+	}{
+		{
+			name: "empty",
+			obj:  TerraformConfiguration{},
+		},
+		{
+			name: "manual",
+			obj: TerraformConfiguration{
+				ObjectMeta: testMeta,
+				Spec: TerraformConfigurationSpec{
+					Configuration: `resource "random_id" "rand" {}`,
+					Values:        `length = 4`,
+					RepeatEvery:   &metav1.Duration{Duration: time.Minute},
+				},
+				Status: TerraformConfigurationStatus{Phase: TerraformPhaseWaitingApproval},
+			},
+		},
+		{
+			name: "auto approve",
+			obj: TerraformConfiguration{
+				ObjectMeta: testMeta,
+				Spec: TerraformConfigurationSpec{
+					AutoApprove:   true,
+					Configuration: `resource "random_id" "rand" {}`,
+					Template: &TerraformConfigurationTemplate{
+						Env:                []corev1.EnvVar{{Name: "TF_LOG", Value: "TRACE"}},
+						ServiceAccountName: "terraform",
+					},
+				},
+			},
+		},
+		{
+			name: "paused with auto approve",
+			obj: TerraformConfiguration{
+				ObjectMeta: testMeta,
+				Spec: TerraformConfigurationSpec{
+					Paused:        true,
+					AutoApprove:   true,
+					Configuration: `resource "random_id" "rand" {}`,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := v1beta1.TerraformConfiguration{}
+			if err := tt.obj.DeepCopy().ConvertTo(&hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+
+			got := TerraformConfiguration{}
+			if err := got.ConvertFrom(&hub); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(tt.obj, got) {
+				t.Errorf("round trip mismatch:\n%s", diff.ObjectReflectDiff(tt.obj, got))
+			}
+		})
+	}
+}
+
+func TestTerraformConfigurationHubRoundTrip(t *testing.T) {
+	transition := metav1.NewTime(time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name string
+		obj  v1beta1.TerraformConfiguration
+	}{
+		{
+			name: "empty",
+			obj:  v1beta1.TerraformConfiguration{},
+		},
+		{
+			name: "multiple sources and variables",
+			obj: v1beta1.TerraformConfiguration{
+				ObjectMeta: testMeta,
+				Spec: v1beta1.TerraformConfigurationSpec{
+					Mode: v1beta1.TerraformModeAuto,
+					Sources: []v1beta1.TerraformSource{
+						{Name: "main.tf", Content: `resource "random_id" "rand" {}`},
+						{Name: "outputs.tf", Content: `output "id" { value = random_id.rand.hex }`},
+					},
+					Variables: &v1beta1.TerraformVariables{
+						File: `length = 4`,
+						Values: []v1beta1.TerraformVariable{
+							{Name: "cluster_name", Value: "test"},
+							{Name: "token", ValueFrom: &corev1.EnvVarSource{
+								SecretKeyRef: &corev1.SecretKeySelector{
+									LocalObjectReference: corev1.LocalObjectReference{Name: "secret"},
+									Key:                  "token",
+								},
+							}},
+						},
+					},
+				},
+				Status: v1beta1.TerraformConfigurationStatus{
+					Phase: v1beta1.TerraformPhaseDone,
+					Conditions: []v1beta1.TerraformCondition{
+						{Type: v1beta1.TerraformConditionReady, Status: corev1.ConditionTrue, LastTransitionTime: transition},
+					},
+				},
+			},
+		},
+		{
+			name: "local module",
+			obj: v1beta1.TerraformConfiguration{
+				Spec: v1beta1.TerraformConfigurationSpec{
+					Sources: []v1beta1.TerraformSource{
+						{Name: "main.tf", Content: `module "vpc" { source = "./modules/vpc" }`},
+						{Name: "modules/vpc/main.tf", Content: `resource "aws_vpc" "vpc" {}`},
+						{Name: "user-data.sh", Content: `#!/bin/sh`},
+					},
+				},
+			},
+		},
+		{
+			name: "single source with custom name",
+			obj: v1beta1.TerraformConfiguration{
+				Spec: v1beta1.TerraformConfigurationSpec{
+					Mode:    v1beta1.TerraformModePaused,
+					Sources: []v1beta1.TerraformSource{{Name: "cluster.tf", Content: `locals {}`}},
+				},
+			},
+		},
+		{
+			name: "state storage",
+			obj: v1beta1.TerraformConfiguration{
+				Spec: v1beta1.TerraformConfigurationSpec{
+					Sources: []v1beta1.TerraformSource{{Name: "main.tf", Content: `locals {}`}},
+					StateStorage: &v1beta1.TerraformStateStorage{
+						Secret: &v1beta1.SecretStateStorage{Name: "tfstate"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spoke := TerraformConfiguration{}
+			if err := spoke.ConvertFrom(tt.obj.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+
+			got := v1beta1.TerraformConfiguration{}
+			if err := spoke.ConvertTo(&got); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(tt.obj, got) {
+				t.Errorf("round trip mismatch:\n%s", diff.ObjectReflectDiff(tt.obj, got))
+			}
+		})
+	}
+}
+
+func TestTerraformConfigurationConvertTo(t *testing.T) {
+	src := TerraformConfiguration{
+		Spec: TerraformConfigurationSpec{
+			AutoApprove:   true,
+			Configuration: `resource "random_id" "rand" {}`,
+			Values:        `length = 4`,
+		},
+	}
+
+	got := v1beta1.TerraformConfiguration{}
+	if err := src.ConvertTo(&got); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+
+	want := v1beta1.TerraformConfigurationSpec{
+		Mode:      v1beta1.TerraformModeAuto,
+		Sources:   []v1beta1.TerraformSource{{Name: "main.tf", Content: `resource "random_id" "rand" {}`}},
+		Variables: &v1beta1.TerraformVariables{File: `length = 4`},
+	}
+
+	if !equality.Semantic.DeepEqual(want, got.Spec) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, got.Spec))
+	}
+}
+
+func TestTerraformConfigurationAlphaEditKeepsVariables(t *testing.T) {
+	hub := v1beta1.TerraformConfiguration{
+		Spec: v1beta1.TerraformConfigurationSpec{
+			Sources: []v1beta1.TerraformSource{{Name: "main.tf", Content: "locals {}"}},
+			Variables: &v1beta1.TerraformVariables{
+				Values: []v1beta1.TerraformVariable{{Name: "cluster_name", Value: "test"}},
+			},
+		},
+	}
+
+	spoke := TerraformConfiguration{}
+	if err := spoke.ConvertFrom(&hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+
+	// v1alpha1 client edits the configuration
+	spoke.Spec.Configuration = `resource "random_id" "rand" {}`
+	spoke.Spec.AutoApprove = true
+
+	got := v1beta1.TerraformConfiguration{}
+	if err := spoke.ConvertTo(&got); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+
+	want := v1beta1.TerraformConfigurationSpec{
+		Mode:      v1beta1.TerraformModeAuto,
+		Sources:   []v1beta1.TerraformSource{{Name: "main.tf", Content: `resource "random_id" "rand" {}`}},
+		Variables: hub.Spec.Variables,
+	}
+
+	if !equality.Semantic.DeepEqual(want, got.Spec) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, got.Spec))
+	}
+
+	if _, ok := got.Annotations[ConversionDataAnnotation]; ok {
+		t.Errorf("%s annotation should be removed", ConversionDataAnnotation)
+	}
+}
+
+func TestTerraformPlanRoundTrip(t *testing.T) {
+	now := metav1.NewTime(time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	spokeObj := TerraformPlan{
+		ObjectMeta: testMeta,
+		Spec:       TerraformPlanSpec{Approved: true, NextRunAt: &now},
+		Status: TerraformPlanStatus{
+			LastRunAt:             &now,
+			ConfigurationSpecHash: "abcd",
+			Phase:                 TerraformPhaseDone,
+		},
+	}
+
+	hub := v1beta1.TerraformPlan{}
+	if err := spokeObj.DeepCopy().ConvertTo(&hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	gotSpoke := TerraformPlan{}
+	if err := gotSpoke.ConvertFrom(&hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(spokeObj, gotSpoke) {
+		t.Errorf("spoke round trip mismatch:\n%s", diff.ObjectReflectDiff(spokeObj, gotSpoke))
+	}
+
+	hubObj := hub.DeepCopy()
+	hubObj.Status.Conditions = []v1beta1.TerraformCondition{
+		{Type: v1beta1.TerraformConditionApprovalRequired, Status: corev1.ConditionTrue, LastTransitionTime: now},
+	}
+	hubObj.Status.Run = 3
+	hubObj.Status.AppliedConfigurationSpecHash = "5f5b4d4b7f"
+	hubObj.Status.PlanSummary = "Plan: 1 to add, 0 to change, 0 to destroy."
+	hubObj.Status.PlanOutput = "+ null_resource.test\n\nPlan: 1 to add, 0 to change, 0 to destroy."
+	hubObj.Spec.Review = &v1beta1.TerraformPlanReview{
+		Approved:              true,
+		By:                    "user@host",
+		Reason:                "looks good",
+		Time:                  now,
+		ConfigurationSpecHash: "abcd",
+	}
+
+	spoke := TerraformPlan{}
+	if err := spoke.ConvertFrom(hubObj.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	gotHub := v1beta1.TerraformPlan{}
+	if err := spoke.ConvertTo(&gotHub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(*hubObj, gotHub) {
+		t.Errorf("hub round trip mismatch:\n%s", diff.ObjectReflectDiff(*hubObj, gotHub))
+	}
+}
+
+func TestTerraformStateRoundTrip(t *testing.T) {
+	now := metav1.NewTime(time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC))
+
+	spokeObj := TerraformState{
+		ObjectMeta: testMeta,
+		Spec: TerraformStateSpec{
+			State: &runtime.RawExtension{Raw: []byte(`{"version":4,"serial":1,"lineage":"abcd"}`)},
+		},
+		Status: TerraformStateStatus{LockID: "lock", LockedSince: &now},
+	}
+
+	hub := v1beta1.TerraformState{}
+	if err := spokeObj.DeepCopy().ConvertTo(&hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	got := TerraformState{}
+	if err := got.ConvertFrom(&hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if !equality.Semantic.DeepEqual(spokeObj, got) {
+		t.Errorf("round trip mismatch:\n%s", diff.ObjectReflectDiff(spokeObj, got))
+	}
+}
+
+func TestTerraformStateHubRoundTrip(t *testing.T) {
+	raw := []byte(`{"version":4,"serial":1,"lineage":"abcd"}`)
+	compressed, err := gzipData(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		obj       v1beta1.TerraformState
+		wantSpoke TerraformStateSpec
+	}{
+		{
+			name: "compressed",
+			obj: v1beta1.TerraformState{
+				ObjectMeta: testMeta,
+				Spec:       v1beta1.TerraformStateSpec{CompressedState: compressed},
+			},
+			wantSpoke: TerraformStateSpec{State: &runtime.RawExtension{Raw: raw}},
+		},
+		{
+			name: "chunked",
+			obj: v1beta1.TerraformState{
+				ObjectMeta: testMeta,
+				Spec: v1beta1.TerraformStateSpec{
+					Chunks: &v1beta1.TerraformStateChunks{Secrets: []string{"test-0", "test-1"}, SHA256: "abcd"},
+				},
+			},
+		},
+		{
+			name: "encrypted",
+			obj: v1beta1.TerraformState{
+				ObjectMeta: testMeta,
+				Spec: v1beta1.TerraformStateSpec{
+					Chunks:     &v1beta1.TerraformStateChunks{Secrets: []string{"test-0"}, SHA256: "abcd"},
+					Encryption: &v1beta1.TerraformStateEncryption{KeySecretName: "keys"},
+				},
+			},
+		},
+		{
+			name: "lock information",
+			obj: v1beta1.TerraformState{
+				ObjectMeta: testMeta,
+				Spec:       v1beta1.TerraformStateSpec{CompressedState: compressed},
+				Status: v1beta1.TerraformStateStatus{
+					LockID: "second",
+					Lock:   &v1beta1.TerraformStateLock{ID: "second", Who: "bob@laptop"},
+					LastForceUnlock: &v1beta1.TerraformStateForceUnlock{
+						Lock: v1beta1.TerraformStateLock{ID: "first", Who: "alice@laptop"},
+						By:   "bob@laptop",
+					},
+				},
+			},
+			wantSpoke: TerraformStateSpec{State: &runtime.RawExtension{Raw: raw}},
+		},
+		{
+			name: "external storage",
+			obj: v1beta1.TerraformState{
+				ObjectMeta: testMeta,
+				Spec: v1beta1.TerraformStateSpec{
+					Storage: &v1beta1.TerraformStateStorage{
+						S3: &v1beta1.S3StateStorage{Endpoint: "https://s3.example.com", Bucket: "tfstate", CredentialsSecretName: "s3"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spoke := TerraformState{}
+			if err := spoke.ConvertFrom(tt.obj.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(tt.wantSpoke, spoke.Spec) {
+				t.Errorf("unexpected spoke spec:\n%s", diff.ObjectReflectDiff(tt.wantSpoke, spoke.Spec))
+			}
+
+			got := v1beta1.TerraformState{}
+			if err := spoke.ConvertTo(&got); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+
+			if !equality.Semantic.DeepEqual(tt.obj, got) {
+				t.Errorf("round trip mismatch:\n%s", diff.ObjectReflectDiff(tt.obj, got))
+			}
+		})
+	}
+}
+
+func TestIsConvertible(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, obj := range []runtime.Object{
+		&v1beta1.TerraformConfiguration{},
+		&v1beta1.TerraformPlan{},
+		&v1beta1.TerraformState{},
+	} {
+		ok, err := conversion.IsConvertible(scheme, obj)
+		if err != nil {
+			t.Fatalf("IsConvertible(%T) error = %v", obj, err)
+		}
+		if !ok {
+			t.Errorf("%T is expected to be convertible", obj)
+		}
+	}
+}
+
+func TestTerraformConfigurationConvertFromLocalModule(t *testing.T) {
+	hub := v1beta1.TerraformConfiguration{
+		Spec: v1beta1.TerraformConfigurationSpec{
+			Sources: []v1beta1.TerraformSource{
+				{Name: "main.tf", Content: `module "vpc" { source = "./modules/vpc" }`},
+				{Name: "modules/vpc/main.tf", Content: `resource "aws_vpc" "vpc" {}`},
+			},
+		},
+	}
+
+	spoke := TerraformConfiguration{}
+	if err := spoke.ConvertFrom(&hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if want := `module "vpc" { source = "./modules/vpc" }`; spoke.Spec.Configuration != want {
+		t.Errorf("configuration = %q, want %q", spoke.Spec.Configuration, want)
+	}
+
+	// v1alpha1 client edits the configuration
+	spoke.Spec.Configuration = `module "vpc" { source = "./modules/vpc" }` + "\n" + `output "id" { value = module.vpc.id }`
+
+	got := v1beta1.TerraformConfiguration{}
+	if err := spoke.ConvertTo(&got); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+
+	want := []v1beta1.TerraformSource{
+		{Name: "main.tf", Content: spoke.Spec.Configuration},
+		{Name: "modules/vpc/main.tf", Content: `resource "aws_vpc" "vpc" {}`},
+	}
+	if !equality.Semantic.DeepEqual(want, got.Spec.Sources) {
+		t.Errorf("unexpected sources:\n%s", diff.ObjectReflectDiff(want, got.Spec.Sources))
+	}
+}
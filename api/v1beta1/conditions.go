@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns condition of given type or nil if it's absent
+func GetCondition(conditions []TerraformCondition, condType TerraformConditionType) *TerraformCondition {
+	for i := range conditions {
+		if conditions[i].Type == condType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates condition of the same type. LastTransitionTime
+// is only bumped when the status of the condition has changed.
+func SetCondition(conditions *[]TerraformCondition, cond TerraformCondition) {
+	existing := GetCondition(*conditions, cond.Type)
+	if existing == nil {
+		if cond.LastTransitionTime.IsZero() {
+			cond.LastTransitionTime = metav1.Now().Rfc3339Copy()
+		}
+		*conditions = append(*conditions, cond)
+		return
+	}
+
+	if existing.Status != cond.Status {
+		existing.Status = cond.Status
+		existing.LastTransitionTime = metav1.Now().Rfc3339Copy()
+	}
+	existing.Reason = cond.Reason
+	existing.Message = cond.Message
+}
+
+// IsConditionTrue checks if condition of given type is present and its status is True
+func IsConditionTrue(conditions []TerraformCondition, condType TerraformConditionType) bool {
+	cond := GetCondition(conditions, condType)
+	return cond != nil && cond.Status == corev1.ConditionTrue
+}
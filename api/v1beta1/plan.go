@@ -0,0 +1,39 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import "strings"
+
+// Drifted reports plan waiting for approval of changes to the configuration
+// that has already been applied as is, i.e. the infrastructure has drifted
+// from it
+func (s *TerraformPlanStatus) Drifted() bool {
+	return s.Phase == TerraformPhaseWaitingApproval &&
+		s.AppliedConfigurationSpecHash != "" &&
+		s.AppliedConfigurationSpecHash == s.ConfigurationSpecHash &&
+		s.HasChanges()
+}
+
+// HasChanges reports plan which has found changes to apply
+func (s *TerraformPlanStatus) HasChanges() bool {
+	return strings.HasPrefix(s.PlanSummary, "Plan:")
+}
+
+// Failed reports plan which latest run has failed
+func (s *TerraformPlanStatus) Failed() bool {
+	return s.Phase == TerraformPhasePlanFailed || s.Phase == TerraformPhaseApplyFailed
+}
@@ -0,0 +1,738 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TerraformPhase phase
+// +kubebuilder:validation:Enum=PlanScheduled;PlanRunning;WaitingApproval;ApplyRunning;PlanFailed;ApplyFailed;Done
+type TerraformPhase string
+
+// TerraformPhase ENUM
+const (
+	TerraformPhasePlanScheduled   TerraformPhase = "PlanScheduled"
+	TerraformPhasePlanRunning     TerraformPhase = "PlanRunning"
+	TerraformPhaseWaitingApproval TerraformPhase = "WaitingApproval"
+	TerraformPhaseApplyRunning    TerraformPhase = "ApplyRunning"
+	TerraformPhasePlanFailed      TerraformPhase = "PlanFailed"
+	TerraformPhaseApplyFailed     TerraformPhase = "ApplyFailed"
+	TerraformPhaseDone            TerraformPhase = "Done"
+)
+
+// TerraformMode defines how changes of the configuration get applied
+// +kubebuilder:validation:Enum=Manual;Auto;Paused
+type TerraformMode string
+
+// TerraformMode ENUM
+const (
+	// TerraformModeManual runs terraform plan and waits for TerraformPlan approval before apply
+	TerraformModeManual TerraformMode = "Manual"
+	// TerraformModeAuto runs terraform apply without any further question
+	TerraformModeAuto TerraformMode = "Auto"
+	// TerraformModePaused stops any terraform runs
+	TerraformModePaused TerraformMode = "Paused"
+)
+
+// TerraformConditionType is a valid value for TerraformCondition.Type
+type TerraformConditionType string
+
+// TerraformConditionType ENUM
+const (
+	// TerraformConditionReady indicates that the latest configuration has been successfully applied
+	TerraformConditionReady TerraformConditionType = "Ready"
+	// TerraformConditionApprovalRequired indicates that the plan waits for approval
+	TerraformConditionApprovalRequired TerraformConditionType = "ApprovalRequired"
+	// TerraformConditionFailed indicates that the latest terraform run has failed
+	TerraformConditionFailed TerraformConditionType = "Failed"
+)
+
+// TerraformCondition describes the state of a terraform object at a certain point
+type TerraformCondition struct {
+	// Type of condition
+	Type TerraformConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Last time the condition transitioned from one status to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// The reason for the condition's last transition, in CamelCase
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// A human readable message indicating details about the transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// TerraformSource is a single file of terraform configuration
+type TerraformSource struct {
+	// Name of the file in terraform working directory, e.g. main.tf, files of
+	// local modules are named with their relative path, e.g.
+	// modules/vpc/main.tf
+	Name string `json:"name"`
+
+	// Content of the file
+	Content string `json:"content"`
+}
+
+// TerraformVariable is a single terraform input variable, that will be passed
+// as TF_VAR_<name> environment variable
+type TerraformVariable struct {
+	// Name of the terraform variable
+	Name string `json:"name"`
+
+	// Value of the terraform variable
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Source for the variable's value. Cannot be used if value is not empty.
+	// Standard corev1 kubernetes API
+	// +optional
+	ValueFrom *corev1.EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+// TerraformVariables defines terraform input variables
+type TerraformVariables struct {
+	// Variable values, will be dumped to terraform.tfvars
+	// +optional
+	File string `json:"file,omitempty"`
+
+	// List of individual variables
+	// +optional
+	Values []TerraformVariable `json:"values,omitempty"`
+}
+
+// TerraformConfigurationTemplate defines some aspects of resulting Pod that will run terraform plan / teterraform apply
+type TerraformConfigurationTemplate struct {
+	// List of volumes that can be mounted by containers belonging to the pod.
+	// More info: https://kubernetes.io/docs/concepts/storage/volumes
+	// Standard corev1 kubernetes API
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// List of sources to populate environment variables in the container.
+	// The keys defined within a source must be a C_IDENTIFIER. All invalid keys
+	// will be reported as an event when the container is starting. When a key exists in multiple
+	// sources, the value associated with the last source will take precedence.
+	// Values defined by an Env with a duplicate key will take precedence.
+	// Standard corev1 kubernetes API
+	// +optional
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// List of environment variables to set in the container.
+	// Standard corev1 kubernetes API
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Pod volumes to mount into the container's filesystem.
+	// Standard corev1 kubernetes API
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// ServiceAccountName is the name of the ServiceAccount to use in running terraform pod.
+	// More info: https://kubernetes.io/docs/tasks/configure-pod-container/configure-service-account/
+	// Standard corev1 kubernetes API
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// TerraformConfigurationSpec defines the desired state of TerraformConfiguration
+type TerraformConfigurationSpec struct {
+	// Mode defines how changes get applied.
+	// Is a enum Manual;Auto;Paused, defaults to Manual
+	// +optional
+	Mode TerraformMode `json:"mode,omitempty"`
+
+	// Rerun this configuration periodically
+	// +optional
+	RepeatEvery *metav1.Duration `json:"repeatEvery,omitempty"`
+
+	// Sources holds terraform configuration files
+	Sources []TerraformSource `json:"sources"`
+
+	// Variables holds terraform input variables
+	// +optional
+	Variables *TerraformVariables `json:"variables,omitempty"`
+
+	// Defines some aspects of resulting Pod that will run terraform plan / teterraform apply
+	// +optional
+	Template *TerraformConfigurationTemplate `json:"template,omitempty"`
+
+	// StateStorage selects where terraform state is stored, TerraformState
+	// itself is used by default. It's recorded in TerraformState once it's
+	// created, changing it afterwards doesn't migrate the state.
+	// +optional
+	StateStorage *TerraformStateStorage `json:"stateStorage,omitempty"`
+}
+
+// TerraformConfigurationStatus defines the observed state of TerraformConfiguration
+type TerraformConfigurationStatus struct {
+	// Phase indicates current phase of the terraform action.
+	// Is a enum PlanScheduled;PlanRunning;WaitingApproval;ApplyRunning;PlanFailed;ApplyFailed;Done
+	// +optional
+	Phase TerraformPhase `json:"phase,omitempty"`
+
+	// Conditions of the latest terraform run
+	// +optional
+	Conditions []TerraformCondition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:resource:shortName=tfconfig;tfconfigs
+// +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// TerraformConfiguration is the Schema for the terraformconfigurations API
+type TerraformConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TerraformConfigurationSpec   `json:"spec,omitempty"`
+	Status TerraformConfigurationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TerraformConfigurationList contains a list of TerraformConfiguration
+type TerraformConfigurationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerraformConfiguration `json:"items"`
+}
+
+// TerraformPlanSpec defines the desired state of TerraformPlan
+type TerraformPlanSpec struct {
+	// Indicate if plan approved to apply, the approval is consumed by the
+	// apply run it starts
+	Approved bool `json:"approved"`
+
+	// Scheduled next execution time
+	// +optional
+	NextRunAt *metav1.Time `json:"nextRunAt,omitempty"`
+
+	// Decision made about the plan with "kubeterra plan approve|reject"
+	// +optional
+	Review *TerraformPlanReview `json:"review,omitempty"`
+}
+
+// TerraformPlanReview records who approved or rejected the plan and why
+type TerraformPlanReview struct {
+	// Indicate if plan is approved or rejected
+	Approved bool `json:"approved"`
+
+	// Who reviewed the plan, as authenticated by kubernetes
+	// +optional
+	By string `json:"by,omitempty"`
+
+	// Who the reviewer claims to be, not verified
+	// +optional
+	ClaimedBy string `json:"claimedBy,omitempty"`
+
+	// Reason of the decision
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// When the plan was reviewed
+	Time metav1.Time `json:"time"`
+
+	// Hash of the TerraformConfigurationSpec the reviewed plan was made for,
+	// the decision doesn't apply to plans of other hashes
+	ConfigurationSpecHash string `json:"configurationSpecHash"`
+
+	// Sequence number of the terraform run which made the reviewed plan, the
+	// decision doesn't apply to plans of later runs
+	// +optional
+	Run int64 `json:"run,omitempty"`
+}
+
+// TerraformPlanStatus defines the observed state of TerraformPlan
+type TerraformPlanStatus struct {
+	// Previous execution time
+	// +optional
+	LastRunAt *metav1.Time `json:"lastRunAt,omitempty"`
+
+	// Sequence number of the latest terraform run
+	// +optional
+	Run int64 `json:"run,omitempty"`
+
+	// String encoded 32-bit FNV-1a hash of the TerraformConfigurationSpec.
+	// Encoded with https://godoc.org/k8s.io/apimachinery/pkg/util/rand#SafeEncodeString
+	ConfigurationSpecHash string `json:"configurationSpecHash"`
+
+	// Hash of the TerraformConfigurationSpec last successfully applied,
+	// encoded the same way as ConfigurationSpecHash
+	// +optional
+	AppliedConfigurationSpecHash string `json:"appliedConfigurationSpecHash,omitempty"`
+
+	// Current phase
+	// Is a enum PlanScheduled;PlanRunning;WaitingApproval;ApplyRunning;PlanFailed;ApplyFailed;Done
+	Phase TerraformPhase `json:"phase"`
+
+	// Conditions of the latest terraform run
+	// +optional
+	Conditions []TerraformCondition `json:"conditions,omitempty"`
+
+	// Summary line of the latest terraform plan, e.g. "Plan: 1 to add, 0 to
+	// change, 0 to destroy."
+	// +optional
+	PlanSummary string `json:"planSummary,omitempty"`
+
+	// Tail of the latest terraform plan output
+	// +optional
+	PlanOutput string `json:"planOutput,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:resource:shortName=tfplan;tfplans
+// +kubebuilder:printcolumn:name="Approved",type=string,JSONPath=`.spec.approved`
+// +kubebuilder:printcolumn:name="Spec Hash",type=string,JSONPath=`.status.configurationSpecHash`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Plan",type=string,JSONPath=`.status.planSummary`,priority=1
+
+// TerraformPlan is the Schema for the terraformplans API
+type TerraformPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TerraformPlanSpec   `json:"spec,omitempty"`
+	Status TerraformPlanStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TerraformPlanList contains a list of TerraformPlan
+type TerraformPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerraformPlan `json:"items"`
+}
+
+// ForcePushAnnotation on TerraformState allows the next pushed state to have
+// different lineage or lower serial than the stored one. Annotation is removed
+// once such state is pushed.
+const ForcePushAnnotation = "terraform.kubeterra.io/force-push"
+
+// AllowDeleteAnnotation on TerraformState allows terraform to delete the
+// state with DELETE request, e.g. when the workspace is removed. Annotation is
+// removed once the state is deleted.
+const AllowDeleteAnnotation = "terraform.kubeterra.io/allow-delete"
+
+// RollbackToAnnotation on TerraformState names TerraformStateRevision the
+// state should be rolled back to. Annotation is removed once rollback is done.
+const RollbackToAnnotation = "terraform.kubeterra.io/rollback-to"
+
+// RevisionHistoryLimitAnnotation on TerraformState overrides how many
+// TerraformStateRevisions to keep
+const RevisionHistoryLimitAnnotation = "terraform.kubeterra.io/revision-history-limit"
+
+// LockTTLAnnotation on TerraformState sets duration after which the lock is
+// considered abandoned and is broken by the next lock attempt. Locks never
+// expire by default.
+const LockTTLAnnotation = "terraform.kubeterra.io/lock-ttl"
+
+// LockLeaseDurationAnnotation on TerraformState sets duration of the Lease
+// backing the lock. Lease not renewed for the duration, e.g. because the
+// httpbackend sidecar holding it is gone, can be taken over by the next lock
+// attempt. Defaults to 1 minute.
+const LockLeaseDurationAnnotation = "terraform.kubeterra.io/lock-lease-duration"
+
+// ForceUnlockAnnotation on TerraformState names ID of the lock to break, e.g.
+// one left by a crashed terraform. Annotation is removed once it's processed.
+const ForceUnlockAnnotation = "terraform.kubeterra.io/force-unlock"
+
+// ForceUnlockByAnnotation on TerraformState names who requested force unlock,
+// to be recorded along with the broken lock
+const ForceUnlockByAnnotation = "terraform.kubeterra.io/force-unlock-by"
+
+// TerraformStateSpec defines the desired state of TerraformState. Terraform
+// state is stored in one of the fields.
+type TerraformStateSpec struct {
+	// Terraform State JSON object, uncompressed states are still loaded but
+	// never written anymore
+	// +optional
+	State *runtime.RawExtension `json:"state,omitempty"`
+
+	// Gzip compressed terraform state JSON
+	// +optional
+	CompressedState []byte `json:"compressedState,omitempty"`
+
+	// Chunks of gzip compressed terraform state JSON, when it's too large to
+	// be stored in TerraformState itself or it's encrypted
+	// +optional
+	Chunks *TerraformStateChunks `json:"chunks,omitempty"`
+
+	// Encryption of the state at rest, encrypted state is always stored in
+	// chunks
+	// +optional
+	Encryption *TerraformStateEncryption `json:"encryption,omitempty"`
+
+	// Storage points to where terraform state is stored when it's not kept in
+	// TerraformState itself
+	// +optional
+	Storage *TerraformStateStorage `json:"storage,omitempty"`
+}
+
+// TerraformStateStorage selects one of the external storages of terraform
+// state. State is stored gzip compressed, and encrypted if TerraformState
+// encryption is configured.
+type TerraformStateStorage struct {
+	// Secret stores terraform state in the Secret
+	// +optional
+	Secret *SecretStateStorage `json:"secret,omitempty"`
+
+	// Filesystem stores terraform state on the PersistentVolumeClaim mounted
+	// into httpbackend sidecar
+	// +optional
+	Filesystem *FilesystemStateStorage `json:"filesystem,omitempty"`
+
+	// S3 stores terraform state in the bucket of S3 compatible API
+	// +optional
+	S3 *S3StateStorage `json:"s3,omitempty"`
+}
+
+// SecretStateStorage stores terraform state in the Secret of the same
+// namespace, created if it doesn't exist
+type SecretStateStorage struct {
+	// Name of the Secret
+	Name string `json:"name"`
+}
+
+// FilesystemStateStorage stores terraform state in a file named after
+// TerraformState
+type FilesystemStateStorage struct {
+	// Name of the PersistentVolumeClaim
+	ClaimName string `json:"claimName"`
+
+	// Directory within the volume
+	// +optional
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// S3StateStorage stores terraform state as an object of S3 compatible API,
+// lock is held as a separate object created with conditional write
+type S3StateStorage struct {
+	// Endpoint URL of the API, bucket is addressed path-style
+	Endpoint string `json:"endpoint"`
+
+	// Region to sign requests for, defaults to us-east-1
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Bucket name
+	Bucket string `json:"bucket"`
+
+	// Key of the state object, defaults to <namespace>/<name>.tfstate
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Name of the Secret holding accessKeyID and secretAccessKey
+	CredentialsSecretName string `json:"credentialsSecretName"`
+}
+
+// TerraformStateEncryption configures envelope encryption of terraform state
+type TerraformStateEncryption struct {
+	// Name of the Secret holding AES keys. Key named by the "primary" entry of
+	// the Secret encrypts new writes, the rest are used to decrypt states
+	// written before the rotation.
+	KeySecretName string `json:"keySecretName"`
+}
+
+// TerraformStateChunks references Secrets holding parts of gzip compressed,
+// optionally encrypted, terraform state
+type TerraformStateChunks struct {
+	// Names of the Secrets, in order of the parts they hold
+	Secrets []string `json:"secrets"`
+
+	// Hex encoded SHA256 digest of the whole compressed state
+	SHA256 string `json:"sha256"`
+
+	// Size of the whole compressed state in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+}
+
+// TerraformStateStatus defines the observed state of TerraformState
+type TerraformStateStatus struct {
+	// Lock ID that currently hold locked this state (or lack of such).
+	// +optional
+	LockID string `json:"lockID"`
+
+	// Time since when lock is held
+	// +optional
+	LockedSince *metav1.Time `json:"lockedSince,omitempty"`
+
+	// Lock information provided by the holder
+	// +optional
+	Lock *TerraformStateLock `json:"lock,omitempty"`
+
+	// LastForceUnlock records the last broken lock
+	// +optional
+	LastForceUnlock *TerraformStateForceUnlock `json:"lastForceUnlock,omitempty"`
+}
+
+// TerraformStateLock is lock information terraform provides
+type TerraformStateLock struct {
+	// Unique ID of the lock
+	ID string `json:"id"`
+
+	// Terraform operation holding the lock, e.g. OperationTypeApply
+	// +optional
+	Operation string `json:"operation,omitempty"`
+
+	// Extra information to store with the lock
+	// +optional
+	Info string `json:"info,omitempty"`
+
+	// User and host holding the lock
+	// +optional
+	Who string `json:"who,omitempty"`
+
+	// Terraform version of the holder
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Time the lock was taken
+	// +optional
+	Created *metav1.Time `json:"created,omitempty"`
+
+	// Path of the state being locked
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// TerraformStateForceUnlock records broken lock
+type TerraformStateForceUnlock struct {
+	// Lock that was broken
+	Lock TerraformStateLock `json:"lock"`
+
+	// Who requested to break the lock
+	// +optional
+	By string `json:"by,omitempty"`
+
+	// Time the lock was broken
+	Time metav1.Time `json:"time"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:resource:shortName=tfstate;tfstates
+
+// TerraformState is the Schema for the terraformstates API
+type TerraformState struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TerraformStateSpec   `json:"spec,omitempty"`
+	Status TerraformStateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TerraformStateList contains a list of TerraformState
+type TerraformStateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerraformState `json:"items"`
+}
+
+// TerraformStateRevisionSpec is an immutable copy of the terraform state
+type TerraformStateRevisionSpec struct {
+	// Name of the TerraformState this revision belongs to
+	StateName string `json:"stateName"`
+
+	// Serial of the terraform state
+	Serial int64 `json:"serial"`
+
+	// Lineage of the terraform state
+	Lineage string `json:"lineage"`
+
+	// ID of the terraform run that pushed the state
+	// +optional
+	RunID string `json:"runID,omitempty"`
+
+	// Gzip compressed terraform state JSON, encrypted the same way as the
+	// TerraformState it belongs to
+	Data []byte `json:"data"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=tfstaterev;tfstaterevs
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.spec.stateName`
+// +kubebuilder:printcolumn:name="Serial",type=integer,JSONPath=`.spec.serial`
+// +kubebuilder:printcolumn:name="Run",type=string,JSONPath=`.spec.runID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TerraformStateRevision is the Schema for the terraformstaterevisions API
+type TerraformStateRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TerraformStateRevisionSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TerraformStateRevisionList contains a list of TerraformStateRevision
+type TerraformStateRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerraformStateRevision `json:"items"`
+}
+
+// TerraformNotificationEvent is a terraform run outcome notifications are
+// sent about
+// +kubebuilder:validation:Enum=WaitingApproval;Failed;Drifted;Applied
+type TerraformNotificationEvent string
+
+// TerraformNotificationEvent ENUM
+const (
+	// TerraformNotificationWaitingApproval is sent once plan waits for approval
+	TerraformNotificationWaitingApproval TerraformNotificationEvent = "WaitingApproval"
+	// TerraformNotificationFailed is sent once plan or apply has failed
+	TerraformNotificationFailed TerraformNotificationEvent = "Failed"
+	// TerraformNotificationDrifted is sent once plan of already applied
+	// configuration has found changes
+	TerraformNotificationDrifted TerraformNotificationEvent = "Drifted"
+	// TerraformNotificationApplied is sent once apply has succeeded
+	TerraformNotificationApplied TerraformNotificationEvent = "Applied"
+)
+
+// TerraformNotificationFormat is a payload format of the webhook
+// +kubebuilder:validation:Enum=JSON;CloudEvents;Slack
+type TerraformNotificationFormat string
+
+// TerraformNotificationFormat ENUM
+const (
+	// TerraformNotificationJSON posts the notification as JSON object
+	TerraformNotificationJSON TerraformNotificationFormat = "JSON"
+	// TerraformNotificationCloudEvents posts the notification as JSON object
+	// wrapped into structured mode CloudEvent
+	TerraformNotificationCloudEvents TerraformNotificationFormat = "CloudEvents"
+	// TerraformNotificationSlack posts the notification as Slack compatible
+	// message, e.g. to an incoming webhook
+	TerraformNotificationSlack TerraformNotificationFormat = "Slack"
+)
+
+// TerraformNotificationSpec defines what to notify about and where to
+type TerraformNotificationSpec struct {
+	// Events to notify about, all of them when empty
+	// +optional
+	Events []TerraformNotificationEvent `json:"events,omitempty"`
+
+	// Selector of TerraformConfigurations in the namespace to notify about,
+	// all of them when empty
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Webhook notifications are posted to
+	Webhook TerraformNotificationWebhook `json:"webhook"`
+}
+
+// TerraformNotificationWebhook is HTTP endpoint notifications are posted to
+type TerraformNotificationWebhook struct {
+	// URL of the webhook
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// URLFrom takes URL of the webhook from the Secret of the same namespace,
+	// e.g. when it holds the token
+	// +optional
+	URLFrom *corev1.SecretKeySelector `json:"urlFrom,omitempty"`
+
+	// Format of the payload, defaults to JSON
+	// +optional
+	Format TerraformNotificationFormat `json:"format,omitempty"`
+}
+
+// TerraformNotificationStatus defines the observed state of
+// TerraformNotification
+type TerraformNotificationStatus struct {
+	// LastNotification is the latest notification sent, or given up on
+	// +optional
+	LastNotification *TerraformNotificationDelivery `json:"lastNotification,omitempty"`
+}
+
+// TerraformNotificationDelivery records delivery of the notification
+type TerraformNotificationDelivery struct {
+	// Event notified about
+	Event TerraformNotificationEvent `json:"event"`
+
+	// Name of the TerraformConfiguration notified about
+	Configuration string `json:"configuration"`
+
+	// Time of the last delivery attempt
+	Time metav1.Time `json:"time"`
+
+	// Number of delivery attempts made
+	Attempts int32 `json:"attempts"`
+
+	// Error of the last attempt, empty once delivered
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=tfnotification;tfnotifications
+// +kubebuilder:printcolumn:name="Format",type=string,JSONPath=`.spec.webhook.format`
+// +kubebuilder:printcolumn:name="Last Event",type=string,JSONPath=`.status.lastNotification.event`
+// +kubebuilder:printcolumn:name="Error",type=string,JSONPath=`.status.lastNotification.error`,priority=1
+
+// TerraformNotification is the Schema for the terraformnotifications API
+type TerraformNotification struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TerraformNotificationSpec   `json:"spec,omitempty"`
+	Status TerraformNotificationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TerraformNotificationList contains a list of TerraformNotification
+type TerraformNotificationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TerraformNotification `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(
+		&TerraformConfiguration{},
+		&TerraformConfigurationList{},
+		&TerraformPlan{},
+		&TerraformPlanList{},
+		&TerraformState{},
+		&TerraformStateList{},
+		&TerraformStateRevision{},
+		&TerraformStateRevisionList{},
+		&TerraformNotification{},
+		&TerraformNotificationList{},
+	)
+}
@@ -0,0 +1,882 @@
+// +build !ignore_autogenerated
+
+/*
+Copyright  The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FilesystemStateStorage) DeepCopyInto(out *FilesystemStateStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FilesystemStateStorage.
+func (in *FilesystemStateStorage) DeepCopy() *FilesystemStateStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(FilesystemStateStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3StateStorage) DeepCopyInto(out *S3StateStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3StateStorage.
+func (in *S3StateStorage) DeepCopy() *S3StateStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(S3StateStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretStateStorage) DeepCopyInto(out *SecretStateStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretStateStorage.
+func (in *SecretStateStorage) DeepCopy() *SecretStateStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretStateStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformCondition) DeepCopyInto(out *TerraformCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformCondition.
+func (in *TerraformCondition) DeepCopy() *TerraformCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformConfiguration) DeepCopyInto(out *TerraformConfiguration) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformConfiguration.
+func (in *TerraformConfiguration) DeepCopy() *TerraformConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformConfiguration) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformConfigurationList) DeepCopyInto(out *TerraformConfigurationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TerraformConfiguration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformConfigurationList.
+func (in *TerraformConfigurationList) DeepCopy() *TerraformConfigurationList {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformConfigurationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformConfigurationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformConfigurationSpec) DeepCopyInto(out *TerraformConfigurationSpec) {
+	*out = *in
+	if in.RepeatEvery != nil {
+		in, out := &in.RepeatEvery, &out.RepeatEvery
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]TerraformSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Variables != nil {
+		in, out := &in.Variables, &out.Variables
+		*out = new(TerraformVariables)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(TerraformConfigurationTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StateStorage != nil {
+		in, out := &in.StateStorage, &out.StateStorage
+		*out = new(TerraformStateStorage)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformConfigurationSpec.
+func (in *TerraformConfigurationSpec) DeepCopy() *TerraformConfigurationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformConfigurationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformConfigurationStatus) DeepCopyInto(out *TerraformConfigurationStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]TerraformCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformConfigurationStatus.
+func (in *TerraformConfigurationStatus) DeepCopy() *TerraformConfigurationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformConfigurationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformConfigurationTemplate) DeepCopyInto(out *TerraformConfigurationTemplate) {
+	*out = *in
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]v1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]v1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]v1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.VolumeMounts != nil {
+		in, out := &in.VolumeMounts, &out.VolumeMounts
+		*out = make([]v1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformConfigurationTemplate.
+func (in *TerraformConfigurationTemplate) DeepCopy() *TerraformConfigurationTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformConfigurationTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotification) DeepCopyInto(out *TerraformNotification) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotification.
+func (in *TerraformNotification) DeepCopy() *TerraformNotification {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformNotification) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotificationDelivery) DeepCopyInto(out *TerraformNotificationDelivery) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotificationDelivery.
+func (in *TerraformNotificationDelivery) DeepCopy() *TerraformNotificationDelivery {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotificationDelivery)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotificationList) DeepCopyInto(out *TerraformNotificationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TerraformNotification, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotificationList.
+func (in *TerraformNotificationList) DeepCopy() *TerraformNotificationList {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotificationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformNotificationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotificationSpec) DeepCopyInto(out *TerraformNotificationSpec) {
+	*out = *in
+	if in.Events != nil {
+		in, out := &in.Events, &out.Events
+		*out = make([]TerraformNotificationEvent, len(*in))
+		copy(*out, *in)
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Webhook.DeepCopyInto(&out.Webhook)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotificationSpec.
+func (in *TerraformNotificationSpec) DeepCopy() *TerraformNotificationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotificationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotificationStatus) DeepCopyInto(out *TerraformNotificationStatus) {
+	*out = *in
+	if in.LastNotification != nil {
+		in, out := &in.LastNotification, &out.LastNotification
+		*out = new(TerraformNotificationDelivery)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotificationStatus.
+func (in *TerraformNotificationStatus) DeepCopy() *TerraformNotificationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotificationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformNotificationWebhook) DeepCopyInto(out *TerraformNotificationWebhook) {
+	*out = *in
+	if in.URLFrom != nil {
+		in, out := &in.URLFrom, &out.URLFrom
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformNotificationWebhook.
+func (in *TerraformNotificationWebhook) DeepCopy() *TerraformNotificationWebhook {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformNotificationWebhook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformPlan) DeepCopyInto(out *TerraformPlan) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformPlan.
+func (in *TerraformPlan) DeepCopy() *TerraformPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformPlan) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformPlanList) DeepCopyInto(out *TerraformPlanList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TerraformPlan, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformPlanList.
+func (in *TerraformPlanList) DeepCopy() *TerraformPlanList {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformPlanList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformPlanList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformPlanReview) DeepCopyInto(out *TerraformPlanReview) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformPlanReview.
+func (in *TerraformPlanReview) DeepCopy() *TerraformPlanReview {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformPlanReview)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformPlanSpec) DeepCopyInto(out *TerraformPlanSpec) {
+	*out = *in
+	if in.NextRunAt != nil {
+		in, out := &in.NextRunAt, &out.NextRunAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Review != nil {
+		in, out := &in.Review, &out.Review
+		*out = new(TerraformPlanReview)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformPlanSpec.
+func (in *TerraformPlanSpec) DeepCopy() *TerraformPlanSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformPlanSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformPlanStatus) DeepCopyInto(out *TerraformPlanStatus) {
+	*out = *in
+	if in.LastRunAt != nil {
+		in, out := &in.LastRunAt, &out.LastRunAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]TerraformCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformPlanStatus.
+func (in *TerraformPlanStatus) DeepCopy() *TerraformPlanStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformPlanStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformSource) DeepCopyInto(out *TerraformSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformSource.
+func (in *TerraformSource) DeepCopy() *TerraformSource {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformState) DeepCopyInto(out *TerraformState) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformState.
+func (in *TerraformState) DeepCopy() *TerraformState {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformState) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateChunks) DeepCopyInto(out *TerraformStateChunks) {
+	*out = *in
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateChunks.
+func (in *TerraformStateChunks) DeepCopy() *TerraformStateChunks {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateChunks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateEncryption) DeepCopyInto(out *TerraformStateEncryption) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateEncryption.
+func (in *TerraformStateEncryption) DeepCopy() *TerraformStateEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateForceUnlock) DeepCopyInto(out *TerraformStateForceUnlock) {
+	*out = *in
+	in.Lock.DeepCopyInto(&out.Lock)
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateForceUnlock.
+func (in *TerraformStateForceUnlock) DeepCopy() *TerraformStateForceUnlock {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateForceUnlock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateList) DeepCopyInto(out *TerraformStateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TerraformState, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateList.
+func (in *TerraformStateList) DeepCopy() *TerraformStateList {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformStateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateLock) DeepCopyInto(out *TerraformStateLock) {
+	*out = *in
+	if in.Created != nil {
+		in, out := &in.Created, &out.Created
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateLock.
+func (in *TerraformStateLock) DeepCopy() *TerraformStateLock {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateLock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateRevision) DeepCopyInto(out *TerraformStateRevision) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateRevision.
+func (in *TerraformStateRevision) DeepCopy() *TerraformStateRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformStateRevision) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateRevisionList) DeepCopyInto(out *TerraformStateRevisionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TerraformStateRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateRevisionList.
+func (in *TerraformStateRevisionList) DeepCopy() *TerraformStateRevisionList {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateRevisionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TerraformStateRevisionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateRevisionSpec) DeepCopyInto(out *TerraformStateRevisionSpec) {
+	*out = *in
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateRevisionSpec.
+func (in *TerraformStateRevisionSpec) DeepCopy() *TerraformStateRevisionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateRevisionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateSpec) DeepCopyInto(out *TerraformStateSpec) {
+	*out = *in
+	if in.State != nil {
+		in, out := &in.State, &out.State
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CompressedState != nil {
+		in, out := &in.CompressedState, &out.CompressedState
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Chunks != nil {
+		in, out := &in.Chunks, &out.Chunks
+		*out = new(TerraformStateChunks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(TerraformStateEncryption)
+		**out = **in
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(TerraformStateStorage)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateSpec.
+func (in *TerraformStateSpec) DeepCopy() *TerraformStateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateStatus) DeepCopyInto(out *TerraformStateStatus) {
+	*out = *in
+	if in.LockedSince != nil {
+		in, out := &in.LockedSince, &out.LockedSince
+		*out = (*in).DeepCopy()
+	}
+	if in.Lock != nil {
+		in, out := &in.Lock, &out.Lock
+		*out = new(TerraformStateLock)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastForceUnlock != nil {
+		in, out := &in.LastForceUnlock, &out.LastForceUnlock
+		*out = new(TerraformStateForceUnlock)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateStatus.
+func (in *TerraformStateStatus) DeepCopy() *TerraformStateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformStateStorage) DeepCopyInto(out *TerraformStateStorage) {
+	*out = *in
+	if in.Secret != nil {
+		in, out := &in.Secret, &out.Secret
+		*out = new(SecretStateStorage)
+		**out = **in
+	}
+	if in.Filesystem != nil {
+		in, out := &in.Filesystem, &out.Filesystem
+		*out = new(FilesystemStateStorage)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3StateStorage)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformStateStorage.
+func (in *TerraformStateStorage) DeepCopy() *TerraformStateStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformStateStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformVariable) DeepCopyInto(out *TerraformVariable) {
+	*out = *in
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(v1.EnvVarSource)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformVariable.
+func (in *TerraformVariable) DeepCopy() *TerraformVariable {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformVariable)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TerraformVariables) DeepCopyInto(out *TerraformVariables) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]TerraformVariable, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TerraformVariables.
+func (in *TerraformVariables) DeepCopy() *TerraformVariables {
+	if in == nil {
+		return nil
+	}
+	out := new(TerraformVariables)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -17,16 +17,32 @@ limitations under the License.
 package command
 
 import (
+	"errors"
+	"os"
+	"time"
+
 	"github.com/spf13/cobra"
 
 	"github.com/loodse/kubeterra/httpbackend"
+	"github.com/loodse/kubeterra/resources"
 )
 
 type backendOptions struct {
 	*globalOptions
-	Name      string
-	Namespace string
-	Listen    string
+	Name        string
+	Namespace   string
+	Central     bool
+	Listen      string
+	MetricsAddr string
+	IdleTimeout time.Duration
+	RunID       string
+	StateDir    string
+	TLS         httpbackend.TLSOptions
+	LogLevel    string
+	LogFormat   string
+
+	LockMethod   string
+	UnlockMethod string
 }
 
 func backendCmd(gopts *globalOptions) *cobra.Command {
@@ -40,14 +56,51 @@ func backendCmd(gopts *globalOptions) *cobra.Command {
 		Short: "launch terraform HTTP backend",
 		Long: `
 This process is used as side-car to running terraform http backend. It will
-proxy terraform state to TerraformState object.
+proxy terraform state to TerraformState object, or to the storage it points to.
+
+Terraform is required to authenticate with HTTP basic auth credentials taken
+from KUBETERRA_BACKEND_USERNAME and KUBETERRA_BACKEND_PASSWORD environment
+variables. Authentication is disabled if both are empty.
+
+With --central every TerraformState is served at /states/{namespace}/{name},
+e.g. by a single Deployment behind a Service. Callers authenticate with their
+Kubernetes bearer token, passed as http backend password, and need get (pull)
+or update (push, lock, unlock) permission on the terraformstate. Locks are
+renewed until terraform unlocks the state, set lock-ttl annotation to expire
+locks of crashed terraform.
+
+Terraform deletes the state with DELETE, e.g. when the workspace is removed.
+It's only allowed once TerraformState is annotated with
+terraform.kubeterra.io/allow-delete: "true".
+
+HTTPS is served once --tls-cert-file and --tls-key-file are given, rotated
+certificates are picked up without restart. Alternatively --tls-self-signed
+generates certificate on start and optionally writes its CA to
+--tls-self-signed-ca-file. Client certificates are verified against
+--tls-client-ca-file if it's set.
 		`,
 		RunE: func(_ *cobra.Command, _ []string) error {
+			if !opts.Central && (opts.Name == "" || opts.Namespace == "") {
+				return errors.New("--name and --namespace are required unless --central is set")
+			}
+
 			return httpbackend.ListenAndServe(httpbackend.Options{
 				TerraformStateName:      opts.Name,
 				TerraformStateNamespace: opts.Namespace,
+				Central:                 opts.Central,
+				IdleTimeout:             opts.IdleTimeout,
 				Listen:                  opts.Listen,
+				MetricsAddr:             opts.MetricsAddr,
 				Development:             opts.Debug,
+				RunID:                   opts.RunID,
+				StateDir:                opts.StateDir,
+				LockMethod:              opts.LockMethod,
+				UnlockMethod:            opts.UnlockMethod,
+				Username:                os.Getenv(resources.BackendUsernameEnv),
+				Password:                os.Getenv(resources.BackendPasswordEnv),
+				TLS:                     opts.TLS,
+				LogLevel:                opts.logLevel(opts.LogLevel),
+				LogFormat:               opts.LogFormat,
 			})
 		},
 	}
@@ -57,9 +110,21 @@ proxy terraform state to TerraformState object.
 	// flags declared here should be cosistent with backendOpts structure
 	flags.StringVarP(&opts.Name, "name", "n", "", "name of the terraform state object to use")
 	flags.StringVarP(&opts.Namespace, "namespace", "s", "", "name of the namespace where terraform state object is located")
-	flags.StringVarP(&opts.Listen, "listen", "l", "localhost:8081", "listen port")
-	_ = cmd.MarkFlagRequired("name")
-	_ = cmd.MarkFlagRequired("namespace")
-
+	flags.BoolVar(&opts.Central, "central", false, "serve every terraform state at /states/{namespace}/{name}")
+	flags.DurationVar(&opts.IdleTimeout, "idle-timeout", httpbackend.DefaultCentralIdleTimeout, "how long central backend keeps handlers of states served without a lock")
+	flags.StringVarP(&opts.Listen, "listen", "l", resources.HTTPBackendListen, "listen port")
+	flags.StringVar(&opts.MetricsAddr, "metrics-addr", "", "the address the metric endpoint binds to, disabled if empty")
+	flags.StringVar(&opts.RunID, "run-id", "", "ID of the terraform run to label state revisions with")
+	flags.StringVar(&opts.LockMethod, "lock-method", httpbackend.DefaultLockMethod, "HTTP method terraform locks state with, as lock_method")
+	flags.StringVar(&opts.UnlockMethod, "unlock-method", httpbackend.DefaultUnlockMethod, "HTTP method terraform unlocks state with, as unlock_method")
+	flags.StringVar(&opts.StateDir, "state-dir", resources.BackendStateDir, "directory of the filesystem state storage")
+	flags.StringVar(&opts.TLS.CertFile, "tls-cert-file", "", "PEM encoded certificate file to serve HTTPS")
+	flags.StringVar(&opts.TLS.KeyFile, "tls-key-file", "", "PEM encoded private key file to serve HTTPS")
+	flags.StringVar(&opts.TLS.ClientCAFile, "tls-client-ca-file", "", "PEM encoded CA bundle to verify client certificates")
+	flags.BoolVar(&opts.TLS.SelfSigned, "tls-self-signed", false, "serve HTTPS with generated self-signed certificate")
+	flags.StringSliceVar(&opts.TLS.SelfSignedHosts, "tls-self-signed-hosts", []string{"localhost", "127.0.0.1"}, "DNS names and IP addresses of self-signed certificate")
+	flags.StringVar(&opts.TLS.SelfSignedCAFile, "tls-self-signed-ca-file", "", "file to write CA of self-signed certificate to")
+	flags.StringVar(&opts.LogLevel, "log-level", "", "error, info, debug or verbosity number to log up to, info by default or debug with --debug")
+	flags.StringVar(&opts.LogFormat, "log-format", "", "json or console, json by default or console with --debug")
 	return cmd
 }
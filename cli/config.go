@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// configEnvPrefix prefixes environment variables of options, e.g.
+	// KUBETERRA_METRICS_ADDR for --metrics-addr
+	configEnvPrefix = "KUBETERRA_"
+
+	// configFileEnv is an environment variable holding path to config file
+	configFileEnv = configEnvPrefix + "CONFIG"
+)
+
+// configurableCommands take their options from environment and config file
+// section of the same name, other commands only take global options
+var configurableCommands = []string{"manager", "backend"}
+
+// configFile holds options of config file, global options at the top level
+// and options of commands in sections named after them, keyed by flag names
+type configFile map[string]interface{}
+
+func configCmd(gopts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect configuration",
+		Args:  cobra.NoArgs,
+		Long: `
+Options of manager and backend are taken from, in order of precedence:
+* command line flags;
+* KUBETERRA_<FLAG> environment variables, e.g. KUBETERRA_METRICS_ADDR for
+  --metrics-addr;
+* YAML config file given with --config or KUBETERRA_CONFIG, holding global
+  options at the top level and options of commands in "manager" and "backend"
+  sections, keyed by flag names.
+		`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "view",
+		Short: "print effective configuration",
+		Args:  cobra.NoArgs,
+		Long: `
+Print configuration manager and backend would run with, in the config file
+format
+		`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			config, err := effectiveConfig(gopts.ConfigFile)
+			if err != nil {
+				return err
+			}
+
+			out, err := yaml.Marshal(config)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(out)
+			return err
+		},
+	})
+
+	return cmd
+}
+
+// applyConfig sets flags of the command not given on the command line from
+// environment and config file
+func applyConfig(cmd *cobra.Command, filename string) error {
+	config, err := loadConfigFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// global options
+	if err := setFlags(cmd.Root().PersistentFlags(), config); err != nil {
+		return err
+	}
+
+	if !isConfigurable(cmd) {
+		return nil
+	}
+
+	section, err := config.section(cmd.Name())
+	if err != nil {
+		return err
+	}
+	return setFlags(cmd.LocalFlags(), section)
+}
+
+// effectiveConfig returns options of configurable commands as they would be
+// applied
+func effectiveConfig(filename string) (configFile, error) {
+	config, err := loadConfigFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// fresh commands, so flags given to "config view" don't leak in
+	fresh := newRoot()
+	if err := setFlags(fresh.PersistentFlags(), config); err != nil {
+		return nil, err
+	}
+
+	effective := flagValues(fresh.PersistentFlags())
+	delete(effective, "config")
+
+	for _, cmd := range fresh.Commands() {
+		if !isConfigurable(cmd) {
+			continue
+		}
+
+		section, err := config.section(cmd.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := setFlags(cmd.LocalFlags(), section); err != nil {
+			return nil, err
+		}
+		effective[cmd.Name()] = flagValues(cmd.LocalFlags())
+	}
+
+	return effective, nil
+}
+
+// setFlags sets flags not given on the command line from environment, or
+// config file if the environment variable is not set either
+func setFlags(flags *pflag.FlagSet, config configFile) error {
+	var err error
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" {
+			return
+		}
+
+		if value, ok := os.LookupEnv(flagEnv(flag.Name)); ok {
+			if setErr := flags.Set(flag.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", flagEnv(flag.Name), setErr)
+			}
+			return
+		}
+
+		value, ok := config[flag.Name]
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(flag.Name, configValue(value)); setErr != nil {
+			err = fmt.Errorf("config option %s: %w", flag.Name, setErr)
+		}
+	})
+
+	return err
+}
+
+// flagValues returns typed values of flags, keyed by flag names
+func flagValues(flags *pflag.FlagSet) configFile {
+	values := configFile{}
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" {
+			return
+		}
+
+		var value interface{}
+		switch flag.Value.Type() {
+		case "bool":
+			value, _ = flags.GetBool(flag.Name)
+		case "int":
+			value, _ = flags.GetInt(flag.Name)
+		case "stringSlice":
+			value, _ = flags.GetStringSlice(flag.Name)
+		default:
+			value = flag.Value.String()
+		}
+		values[flag.Name] = value
+	})
+
+	return values
+}
+
+// flagEnv returns name of environment variable of the flag
+func flagEnv(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configValue formats config file value as flag value, lists are joined with
+// commas
+func configValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	}
+	// YAML numbers are decoded as float64, large ones would be formatted in
+	// exponent notation
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func loadConfigFile(filename string) (configFile, error) {
+	if filename == "" {
+		filename = os.Getenv(configFileEnv)
+	}
+	if filename == "" {
+		return configFile{}, nil
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := configFile{}
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", filename, err)
+	}
+	return config, nil
+}
+
+// section returns options of the command
+func (c configFile) section(name string) (configFile, error) {
+	value, ok := c[name]
+	if !ok || value == nil {
+		return configFile{}, nil
+	}
+
+	section, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config section %s is expected to be a map", name)
+	}
+	return section, nil
+}
+
+func isConfigurable(cmd *cobra.Command) bool {
+	if cmd.Parent() != cmd.Root() {
+		return false
+	}
+	for _, name := range configurableCommands {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
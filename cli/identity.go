@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// kubeUser returns the name kubernetes authenticates the user of cfg as.
+// Client certificates and basic auth name the user themselves, bearer tokens
+// are resolved with TokenReview, which needs "create" on tokenreviews.
+func kubeUser(ctx context.Context, c client.Client, cfg *rest.Config) (string, error) {
+	switch {
+	case len(cfg.CertData) > 0 || cfg.CertFile != "":
+		return certUser(cfg)
+	case cfg.BearerToken != "" || cfg.BearerTokenFile != "":
+		return tokenUser(ctx, c, cfg)
+	case cfg.Username != "":
+		return cfg.Username, nil
+	}
+	return "", errors.New("unable to identify kubernetes user, client certificate, token or basic auth is required")
+}
+
+// certUser returns common name of the client certificate, which kubernetes
+// takes as the user name
+func certUser(cfg *rest.Config) (string, error) {
+	data := cfg.CertData
+	if len(data) == 0 {
+		var err error
+		if data, err = ioutil.ReadFile(cfg.CertFile); err != nil {
+			return "", err
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("client certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if cert.Subject.CommonName == "" {
+		return "", errors.New("client certificate has no common name")
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// tokenUser reviews the bearer token to find out whom it belongs to
+func tokenUser(ctx context.Context, c client.Client, cfg *rest.Config) (string, error) {
+	token := cfg.BearerToken
+	if token == "" {
+		data, err := ioutil.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return "", err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return "", fmt.Errorf("unable to review token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("token is not authenticated: %s", review.Status.Error)
+	}
+	return review.Status.User.Username, nil
+}
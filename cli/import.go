@@ -0,0 +1,273 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/controllers"
+)
+
+type importOptions struct {
+	*globalOptions
+	Name        string
+	Namespace   string
+	Mode        string
+	UploadState bool
+}
+
+func importDirCmd(gopts *globalOptions) *cobra.Command {
+	opts := importOptions{
+		globalOptions: gopts,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "import-dir DIR",
+		Short: "convert terraform directory into TerraformConfiguration",
+		Args:  cobra.ExactArgs(1),
+		Long: `
+Print TerraformConfiguration manifest with *.tf, *.tf.json, terraform.tfvars and
+*.auto.tfvars files of DIR, along with files of local modules it uses, e.g.
+"./modules/vpc", kept in their subdirectories. Backend blocks are removed, as
+the state is managed by kubeterra, *.tf.json files declaring a backend are
+refused.
+
+With --upload-state, DIR/terraform.tfstate is uploaded into TerraformState of
+the same name with its lineage preserved, so the next terraform run continues
+with the existing state. TerraformState holding other lineage or newer serial
+is left untouched.
+		`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if opts.Name == "" {
+				return errors.New("--name is required")
+			}
+			return opts.run(context.Background(), args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.Name, "name", "", "name of the terraform configuration object")
+	flags.StringVarP(&opts.Namespace, "namespace", "n", "default", "namespace of the terraform configuration object")
+	flags.StringVar(&opts.Mode, "mode", "", "mode of the configuration, one of Manual, Auto or Paused")
+	flags.BoolVar(&opts.UploadState, "upload-state", false, "upload DIR/terraform.tfstate into TerraformState")
+
+	return cmd
+}
+
+func (opts *importOptions) run(ctx context.Context, dir string) error {
+	im := &dirImporter{
+		root:    dir,
+		visited: map[string]bool{},
+	}
+	if err := im.importDir(""); err != nil {
+		return err
+	}
+	if len(im.sources) == 0 {
+		return fmt.Errorf("no terraform files found in %s", dir)
+	}
+
+	tfconfig := &terraformv1beta1.TerraformConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: terraformv1beta1.GroupVersion.String(),
+			Kind:       "TerraformConfiguration",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.Name,
+			Namespace: opts.Namespace,
+		},
+		Spec: terraformv1beta1.TerraformConfigurationSpec{
+			Mode:    terraformv1beta1.TerraformMode(opts.Mode),
+			Sources: im.sources,
+		},
+	}
+	if im.variablesFile != "" {
+		tfconfig.Spec.Variables = &terraformv1beta1.TerraformVariables{File: im.variablesFile}
+	}
+
+	// validate configuration the same way controller does
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
+	if _, err := controllers.Render(tfconfig, scheme); err != nil {
+		return err
+	}
+
+	if opts.UploadState {
+		if err := opts.uploadState(ctx, filepath.Join(dir, "terraform.tfstate")); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := yaml.Marshal(tfconfig)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(manifest)
+	return err
+}
+
+// uploadState pushes terraform state into TerraformState, that is created
+// unless exists. Created TerraformState is adopted by TerraformConfiguration of
+// the same name once it's applied.
+func (opts *importOptions) uploadState(ctx context.Context, filename string) error {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	state := &terraformv1beta1.TerraformState{}
+	err = c.Get(ctx, client.ObjectKey{Namespace: opts.Namespace, Name: opts.Name}, state)
+	if apierrors.IsNotFound(err) {
+		state = &terraformv1beta1.TerraformState{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      opts.Name,
+				Namespace: opts.Namespace,
+			},
+		}
+		err = c.Create(ctx, state)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := pushState(ctx, c, state, raw, "kubeterra import-dir", false); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "state %s is uploaded into %s/%s\n", filename, state.Namespace, state.Name)
+	return nil
+}
+
+// dirImporter collects terraform files of the root module and local modules
+// it uses
+type dirImporter struct {
+	root          string
+	sources       []terraformv1beta1.TerraformSource
+	variablesFile string
+	visited       map[string]bool
+}
+
+// importDir collects files of the module in rel directory relative to the
+// root, variables are only taken from the root module
+func (im *dirImporter) importDir(rel string) error {
+	im.visited[rel] = true
+	rootModule := rel == ""
+
+	entries, err := ioutil.ReadDir(filepath.Join(im.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+
+	var modules []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		sourceName := path.Join(rel, name)
+
+		switch {
+		case rootModule && name == "terraform.tfvars":
+			content, err := im.readFile(sourceName)
+			if err != nil {
+				return err
+			}
+			im.variablesFile = content
+
+		case strings.HasSuffix(name, ".tf"), strings.HasSuffix(name, ".tf.json"):
+			content, err := im.readFile(sourceName)
+			if err != nil {
+				return err
+			}
+
+			content, backendTypes, err := controllers.RemoveBackendBlocks(sourceName, content)
+			if err != nil {
+				return err
+			}
+			for _, backendType := range backendTypes {
+				fmt.Fprintf(os.Stderr, "backend %q is removed from %s\n", backendType, sourceName)
+			}
+
+			localModules, err := controllers.LocalModuleSources(sourceName, content)
+			if err != nil {
+				return err
+			}
+			modules = append(modules, localModules...)
+
+			im.addSource(sourceName, content)
+
+		case rootModule && (name == "terraform.tfvars.json" || strings.HasSuffix(name, ".auto.tfvars") || strings.HasSuffix(name, ".auto.tfvars.json")):
+			content, err := im.readFile(sourceName)
+			if err != nil {
+				return err
+			}
+			im.addSource(sourceName, content)
+
+		case rootModule && (strings.HasSuffix(name, ".tfvars") || strings.HasSuffix(name, ".tfvars.json")):
+			fmt.Fprintf(os.Stderr, "%s is skipped, terraform doesn't load it automatically\n", sourceName)
+		}
+	}
+
+	for _, module := range modules {
+		moduleDir := path.Join(rel, module)
+		if moduleDir == ".." || strings.HasPrefix(moduleDir, "../") {
+			return fmt.Errorf("module %q used in %q is outside of %s", module, path.Join(".", rel), im.root)
+		}
+		if im.visited[moduleDir] {
+			continue
+		}
+		if err := im.importDir(moduleDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im *dirImporter) addSource(name, content string) {
+	im.sources = append(im.sources, terraformv1beta1.TerraformSource{
+		Name:    name,
+		Content: content,
+	})
+}
+
+func (im *dirImporter) readFile(name string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(im.root, filepath.FromSlash(name)))
+	return string(content), err
+}
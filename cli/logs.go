@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/controllers"
+	"github.com/loodse/kubeterra/resources"
+)
+
+type logsOptions struct {
+	*globalOptions
+	Namespace string
+	Follow    bool
+	Run       int64
+}
+
+func logsCmd(gopts *globalOptions) *cobra.Command {
+	opts := logsOptions{
+		globalOptions: gopts,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "logs NAME",
+		Short: "print terraform output of the configuration",
+		Args:  cobra.ExactArgs(1),
+		Long: `
+Print logs of terraform container running for TerraformConfiguration, by
+default of its latest run. Logs of finished runs are read from ConfigMaps the
+controller saves them to, logs of the last 10 runs are kept.
+		`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return opts.run(context.Background(), args[0], os.Stdout)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.Namespace, "namespace", "n", "default", "namespace of the terraform configuration object")
+	flags.BoolVarP(&opts.Follow, "follow", "f", false, "stream logs of the running terraform")
+	flags.Int64Var(&opts.Run, "run", 0, "sequence number of the run, the latest one by default")
+
+	return cmd
+}
+
+func (opts *logsOptions) run(ctx context.Context, name string, out io.Writer) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	podClient, err := corev1typed.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	tfplan := &terraformv1beta1.TerraformPlan{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opts.Namespace, Name: name}, tfplan); err != nil {
+		return err
+	}
+
+	run := opts.Run
+	if run == 0 {
+		run = tfplan.Status.Run
+	}
+
+	pod, err := runPod(ctx, c, tfplan, run)
+	if err != nil {
+		return err
+	}
+
+	if pod != nil {
+		return opts.streamLogs(podClient, pod, out)
+	}
+
+	logCM := &corev1.ConfigMap{}
+	err = c.Get(ctx, client.ObjectKey{Namespace: opts.Namespace, Name: controllers.TerraformLogName(name, run)}, logCM)
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("logs of run %d of %s/%s are not found", run, opts.Namespace, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(out, logCM.Data[resources.TerraformLogKey])
+	return err
+}
+
+// runPod returns terraform pod of the run, if it still exists. Pods are
+// selected by their plan and run labels, and checked to be controlled by the
+// plan, as labels can be set by anyone.
+func runPod(ctx context.Context, c client.Client, tfplan *terraformv1beta1.TerraformPlan, run int64) (*corev1.Pod, error) {
+	var podList corev1.PodList
+	err := c.List(ctx, &podList,
+		client.InNamespace(tfplan.Namespace),
+		client.MatchingLabels{
+			resources.PlanLabel: tfplan.Name,
+			resources.RunLabel:  strconv.FormatInt(run, 10),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range podList.Items {
+		pod := p
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.UID == tfplan.UID {
+			return &pod, nil
+		}
+	}
+	return nil, nil
+}
+
+// streamLogs copies logs of terraform container to out, with --follow it
+// waits for the container to start
+func (opts *logsOptions) streamLogs(podClient corev1typed.PodsGetter, pod *corev1.Pod, out io.Writer) error {
+	if opts.Follow {
+		err := wait.PollImmediateInfinite(2*time.Second, func() (bool, error) {
+			current, err := podClient.Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return terraformStarted(current), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	logs, err := podClient.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: "terraform",
+		Follow:    opts.Follow,
+	}).Stream()
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	_, err = io.Copy(out, logs)
+	return err
+}
+
+// terraformStarted tells if terraform container is running or already
+// finished
+func terraformStarted(pod *corev1.Pod) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "terraform" {
+			return status.State.Running != nil || status.State.Terminated != nil
+		}
+	}
+	return false
+}
@@ -26,7 +26,12 @@ type managerOptions struct {
 	*globalOptions
 	Namespace            string
 	MetricsAddr          string
+	HealthProbeAddr      string
 	EnableLeaderElection bool
+	EnableWebhooks       bool
+	WebhookPort          int
+	LogLevel             string
+	LogFormat            string
 }
 
 func managerCmd(gopts *globalOptions) *cobra.Command {
@@ -46,18 +51,28 @@ Launch kubernetes controller manager that will watch and act over CRDs:
 		`,
 		RunE: func(_ *cobra.Command, _ []string) error {
 			return manager.Launch(manager.Options{
-				MetricsAddr:    opts.MetricsAddr,
-				LeaderElection: opts.EnableLeaderElection,
-				Development:    opts.Debug,
-				Namespace:      opts.Namespace,
+				MetricsAddr:     opts.MetricsAddr,
+				HealthProbeAddr: opts.HealthProbeAddr,
+				LeaderElection:  opts.EnableLeaderElection,
+				Development:     opts.Debug,
+				Namespace:       opts.Namespace,
+				EnableWebhooks:  opts.EnableWebhooks,
+				WebhookPort:     opts.WebhookPort,
+				LogLevel:        opts.logLevel(opts.LogLevel),
+				LogFormat:       opts.LogFormat,
 			})
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.StringVar(&opts.MetricsAddr, "metrics-addr", ":8080", "the address the metric endpoint binds to.")
+	flags.StringVar(&opts.HealthProbeAddr, "health-probe-addr", ":8081", "the address /healthz and /readyz probes bind to, empty to disable.")
 	flags.BoolVarP(&opts.EnableLeaderElection, "enable-leader-election", "l", false, "enable leader election for controller manager.")
 	flags.StringVar(&opts.Namespace, "namespace", "kubeterra-system", "namespace to watch over")
+	flags.BoolVar(&opts.EnableWebhooks, "enable-webhooks", true, "serve CRD conversion webhook.")
+	flags.IntVar(&opts.WebhookPort, "webhook-port", 9443, "the port the webhook server binds to.")
+	flags.StringVar(&opts.LogLevel, "log-level", "", "error, info, debug or verbosity number to log up to, info by default or debug with --debug.")
+	flags.StringVar(&opts.LogFormat, "log-format", "", "json or console, json by default or console with --debug.")
 
 	return cmd
 }
@@ -0,0 +1,245 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/controllers"
+)
+
+type planOptions struct {
+	*globalOptions
+	Namespace string
+}
+
+func planCmd(gopts *globalOptions) *cobra.Command {
+	opts := planOptions{
+		globalOptions: gopts,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "review terraform plans",
+		Args:  cobra.NoArgs,
+		Long: `
+Review TerraformPlans waiting for approval
+		`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Usage()
+		},
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVarP(&opts.Namespace, "namespace", "n", "default", "namespace of the terraform plan object")
+
+	cmd.AddCommand(
+		planShowCmd(&opts),
+		planReviewCmd(&opts, true),
+		planReviewCmd(&opts, false),
+	)
+
+	return cmd
+}
+
+func planShowCmd(opts *planOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show NAME",
+		Short: "show summary and output of terraform plan",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			tfplan, specHash, err := opts.getPlan(ctx, c, args[0])
+			if err != nil {
+				return err
+			}
+
+			return printPlan(os.Stdout, tfplan, specHash)
+		},
+	}
+}
+
+func planReviewCmd(opts *planOptions, approve bool) *cobra.Command {
+	var (
+		who      string
+		reason   string
+		specHash string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "approve NAME",
+		Short: "approve terraform plan to be applied",
+		Args:  cobra.ExactArgs(1),
+		Long: `
+Approve TerraformPlan waiting for approval, so controller applies it once,
+plans of later runs wait for approval again. Plans superseded by changes of
+TerraformConfiguration are refused, --spec-hash additionally makes sure the
+plan is the one shown by "kubeterra plan show". The approver, as authenticated
+by kubernetes, and --reason are recorded in the plan, --who is only recorded
+next to it as a claim.
+		`,
+	}
+	if !approve {
+		cmd.Use = "reject NAME"
+		cmd.Short = "reject terraform plan"
+		cmd.Long = `
+Reject TerraformPlan waiting for approval, it's not applied until
+TerraformConfiguration is changed and planned again. The reviewer, as
+authenticated by kubernetes, and --reason are recorded in the plan, --who is
+only recorded next to it as a claim.
+		`
+	}
+
+	cmd.RunE = func(_ *cobra.Command, args []string) error {
+		ctx := context.Background()
+		name := args[0]
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		reviewer, err := kubeUser(ctx, c, cfg)
+		if err != nil {
+			return err
+		}
+
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			tfplan, currentHash, err := opts.getPlan(ctx, c, name)
+			if err != nil {
+				return err
+			}
+
+			if tfplan.Status.Phase != terraformv1beta1.TerraformPhaseWaitingApproval {
+				return fmt.Errorf("plan %s/%s is in %s phase, not %s", opts.Namespace, name, tfplan.Status.Phase, terraformv1beta1.TerraformPhaseWaitingApproval)
+			}
+			if tfplan.Status.ConfigurationSpecHash != currentHash {
+				return fmt.Errorf("plan %s/%s is superseded by spec hash %s", opts.Namespace, name, currentHash)
+			}
+			if specHash != "" && tfplan.Status.ConfigurationSpecHash != specHash {
+				return fmt.Errorf("plan %s/%s is made for spec hash %s, not %s", opts.Namespace, name, tfplan.Status.ConfigurationSpecHash, specHash)
+			}
+
+			tfplan.Spec.Approved = approve
+			tfplan.Spec.Review = &terraformv1beta1.TerraformPlanReview{
+				Approved:              approve,
+				By:                    reviewer,
+				ClaimedBy:             who,
+				Reason:                reason,
+				Time:                  metav1.Now().Rfc3339Copy(),
+				ConfigurationSpecHash: tfplan.Status.ConfigurationSpecHash,
+				Run:                   tfplan.Status.Run,
+			}
+			return c.Update(ctx, tfplan)
+		})
+		if err != nil {
+			return err
+		}
+
+		verdict := "approved"
+		if !approve {
+			verdict = "rejected"
+		}
+		fmt.Printf("plan %s/%s is %s\n", opts.Namespace, name, verdict)
+		return nil
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&who, "who", "", "who claims to review the plan, recorded in the plan next to the authenticated reviewer")
+	flags.StringVar(&reason, "reason", "", "reason of the decision, recorded in the plan")
+	flags.StringVar(&specHash, "spec-hash", "", "only review the plan made for this spec hash")
+
+	return cmd
+}
+
+// getPlan returns the named TerraformPlan along with the current hash of its
+// TerraformConfiguration spec
+func (opts *planOptions) getPlan(ctx context.Context, c client.Client, name string) (*terraformv1beta1.TerraformPlan, string, error) {
+	key := client.ObjectKey{Namespace: opts.Namespace, Name: name}
+
+	tfplan := &terraformv1beta1.TerraformPlan{}
+	if err := c.Get(ctx, key, tfplan); err != nil {
+		return nil, "", err
+	}
+
+	tfconfig := &terraformv1beta1.TerraformConfiguration{}
+	if err := c.Get(ctx, key, tfconfig); err != nil {
+		return nil, "", err
+	}
+
+	return tfplan, controllers.ConfigurationSpecHash(tfconfig.Spec), nil
+}
+
+func printPlan(out io.Writer, tfplan *terraformv1beta1.TerraformPlan, specHash string) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Name:\t%s/%s\n", tfplan.Namespace, tfplan.Name)
+	fmt.Fprintf(w, "Phase:\t%s\n", tfplan.Status.Phase)
+	fmt.Fprintf(w, "Spec hash:\t%s\n", tfplan.Status.ConfigurationSpecHash)
+	if tfplan.Status.ConfigurationSpecHash != specHash {
+		fmt.Fprintf(w, "Superseded by:\t%s\n", specHash)
+	}
+	if tfplan.Status.LastRunAt != nil {
+		fmt.Fprintf(w, "Last run:\t%s\n", tfplan.Status.LastRunAt.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	if review := tfplan.Spec.Review; review != nil {
+		verdict := "rejected"
+		if review.Approved {
+			verdict = "approved"
+		}
+		fmt.Fprintf(w, "Review:\t%s by %s at %s (spec hash %s)\n", verdict, review.By, review.Time.UTC().Format("2006-01-02T15:04:05Z"), review.ConfigurationSpecHash)
+		if review.ClaimedBy != "" {
+			fmt.Fprintf(w, "Claimed by:\t%s\n", review.ClaimedBy)
+		}
+		if review.Reason != "" {
+			fmt.Fprintf(w, "Reason:\t%s\n", review.Reason)
+		}
+	}
+	if tfplan.Status.PlanSummary != "" {
+		fmt.Fprintf(w, "Summary:\t%s\n", tfplan.Status.PlanSummary)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if tfplan.Status.PlanOutput != "" {
+		fmt.Fprintf(out, "\nOutput:\n%s\n", tfplan.Status.PlanOutput)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	terraformv1alpha1 "github.com/loodse/kubeterra/api/v1alpha1"
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/controllers"
+)
+
+func renderCmd(gopts *globalOptions) *cobra.Command {
+	var filename string
+
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "render objects created for terraform configurations",
+		Args:  cobra.NoArgs,
+		Long: `
+Render TerraformState, TerraformPlan, terraform ConfigMap and Pod the
+controllers create for the first run of every TerraformConfiguration in the
+file, without a cluster. Names, hashes and validation are the same as in the
+cluster, per-run Secret with random credentials and the initial state are not
+rendered.
+		`,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			in := io.Reader(os.Stdin)
+			if filename != "-" {
+				f, err := os.Open(filename)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+
+			return render(in, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&filename, "filename", "f", "-", "file with TerraformConfiguration objects, - reads stdin")
+
+	return cmd
+}
+
+func render(in io.Reader, out io.Writer) error {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terraformv1alpha1.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
+
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		tfconfig, err := toTerraformConfiguration(obj)
+		if err != nil {
+			return err
+		}
+
+		objs, err := controllers.Render(tfconfig, scheme)
+		if err != nil {
+			return fmt.Errorf("terraformconfiguration %s: %w", tfconfig.Name, err)
+		}
+		if len(objs) == 0 {
+			fmt.Fprintf(os.Stderr, "terraformconfiguration %s is paused\n", tfconfig.Name)
+		}
+
+		for _, obj := range objs {
+			manifest, err := yaml.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(out, "---\n%s", manifest); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toTerraformConfiguration returns v1beta1 TerraformConfiguration of decoded
+// object, v1alpha1 objects are converted
+func toTerraformConfiguration(obj runtime.Object) (*terraformv1beta1.TerraformConfiguration, error) {
+	switch tfconfig := obj.(type) {
+	case *terraformv1beta1.TerraformConfiguration:
+		return tfconfig, nil
+	case *terraformv1alpha1.TerraformConfiguration:
+		hub := &terraformv1beta1.TerraformConfiguration{}
+		if err := tfconfig.ConvertTo(hub); err != nil {
+			return nil, err
+		}
+		return hub, nil
+	}
+
+	kind := obj.GetObjectKind().GroupVersionKind()
+	return nil, fmt.Errorf("%s is not a TerraformConfiguration", strings.TrimPrefix(kind.String(), "/, "))
+}
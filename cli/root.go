@@ -23,11 +23,20 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// globalOptions is a struct to embedd to other "opts" structures for
-// viper.Unmarshal
+// globalOptions is a struct to embedd to other "opts" structures
 type globalOptions struct {
-	Verbose bool
-	Debug   bool
+	Verbose    bool
+	Debug      bool
+	ConfigFile string
+}
+
+// logLevel returns level to log at, --verbose logs debug messages unless
+// --log-level is set
+func (gopts *globalOptions) logLevel(level string) string {
+	if level == "" && gopts.Verbose {
+		return "debug"
+	}
+	return level
 }
 
 // Execute is the root command entry function
@@ -53,15 +62,25 @@ Terraform controllers manager
 	}
 
 	gopts := globalOptions{}
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		return applyConfig(cmd, gopts.ConfigFile)
+	}
 	flags := cmd.PersistentFlags()
 
 	// flags declared here should be cosistent with rootOpts structure
-	flags.BoolVarP(&gopts.Verbose, "verbose", "v", false, "verbose output")
+	flags.BoolVarP(&gopts.Verbose, "verbose", "v", false, "log debug messages, same as --log-level=debug")
 	flags.BoolVarP(&gopts.Debug, "debug", "d", false, "development mode")
+	flags.StringVar(&gopts.ConfigFile, "config", "", "YAML config file, see \"kubeterra config\" (env KUBETERRA_CONFIG)")
 
 	cmd.AddCommand(
 		managerCmd(&gopts),
 		backendCmd(&gopts),
+		stateCmd(&gopts),
+		planCmd(&gopts),
+		logsCmd(&gopts),
+		renderCmd(&gopts),
+		importDirCmd(&gopts),
+		configCmd(&gopts),
 	)
 
 	return cmd
@@ -0,0 +1,493 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/revision"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+type stateOptions struct {
+	*globalOptions
+	Namespace string
+}
+
+func stateCmd(gopts *globalOptions) *cobra.Command {
+	opts := stateOptions{
+		globalOptions: gopts,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "manage terraform states",
+		Args:  cobra.NoArgs,
+		Long: `
+Inspect and manage terraform states held by TerraformState objects
+		`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Usage()
+		},
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVarP(&opts.Namespace, "namespace", "n", "default", "namespace of the terraform state object")
+
+	cmd.AddCommand(
+		stateListCmd(&opts),
+		statePullCmd(&opts),
+		statePushCmd(&opts),
+		stateShowCmd(&opts),
+		stateForceUnlockCmd(&opts),
+	)
+
+	return cmd
+}
+
+func stateForceUnlockCmd(opts *stateOptions) *cobra.Command {
+	var who string
+
+	cmd := &cobra.Command{
+		Use:     "force-unlock NAME LOCK_ID",
+		Aliases: []string{"rm-lock"},
+		Short:   "break the lock of terraform state",
+		Args:    cobra.ExactArgs(2),
+		Long: `
+Request to break the lock of TerraformState, e.g. one left by a crashed
+terraform. Controller breaks the lock only if it's still held with LOCK_ID, and
+records the broken lock along with --who in TerraformState status.
+		`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx := context.Background()
+			name, lockID := args[0], args[1]
+
+			c, state, err := opts.getState(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			if state.Status.LockID != "" && state.Status.LockID != lockID {
+				return fmt.Errorf("state is locked with other lock ID %s", state.Status.LockID)
+			}
+
+			if state.Annotations == nil {
+				state.Annotations = map[string]string{}
+			}
+			state.Annotations[terraformv1beta1.ForceUnlockAnnotation] = lockID
+			state.Annotations[terraformv1beta1.ForceUnlockByAnnotation] = who
+			if err := c.Update(ctx, state); err != nil {
+				return err
+			}
+
+			fmt.Printf("force unlock of %s/%s is requested\n", opts.Namespace, name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&who, "who", defaultWho(), "who breaks the lock, recorded in the state")
+
+	return cmd
+}
+
+func stateListCmd(opts *stateOptions) *cobra.Command {
+	var allNamespaces bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list terraform states",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+
+			namespace := opts.Namespace
+			if allNamespaces {
+				namespace = ""
+			}
+
+			states := &terraformv1beta1.TerraformStateList{}
+			if err := c.List(ctx, states, client.InNamespace(namespace)); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			header := "NAME\tSTORAGE\tSERIAL\tLINEAGE\tLOCKED BY\tAGE"
+			if allNamespaces {
+				header = "NAMESPACE\t" + header
+			}
+			fmt.Fprintln(w, header)
+
+			for i := range states.Items {
+				state := &states.Items[i]
+				serial, lineage, lockedBy := stateSummary(ctx, c, state)
+
+				row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s",
+					state.Name,
+					storageName(state.Spec.Storage),
+					serial,
+					lineage,
+					lockedBy,
+					duration.HumanDuration(time.Since(state.CreationTimestamp.Time)),
+				)
+				if allNamespaces {
+					row = state.Namespace + "\t" + row
+				}
+				fmt.Fprintln(w, row)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list terraform states of all namespaces")
+
+	return cmd
+}
+
+func statePullCmd(opts *stateOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pull NAME",
+		Short: "print terraform state JSON",
+		Args:  cobra.ExactArgs(1),
+		Long: `
+Print terraform state JSON held by TerraformState, or by the storage it points
+to, the same way "terraform state pull" does
+		`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			_, store, err := opts.stateStore(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			raw, err := store.Get(ctx)
+			if err != nil {
+				return err
+			}
+
+			_, err = os.Stdout.Write(raw)
+			return err
+		},
+	}
+}
+
+func statePushCmd(opts *stateOptions) *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "push NAME FILE",
+		Short: "replace terraform state",
+		Args:  cobra.ExactArgs(2),
+		Long: `
+Replace terraform state of TerraformState with the one read from FILE, or from
+stdin if FILE is "-". E.g. to import state of an existing backend:
+
+    terraform state pull | kubeterra state push NAME -
+
+Like terraform, the state is rejected if it belongs to other lineage or its
+serial is lower than the stored one, unless --force is given. The state is
+locked during the push, and both replaced and pushed states are recorded as
+revisions.
+		`,
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			raw, err := readStateFile(args[1])
+			if err != nil {
+				return err
+			}
+
+			c, state, err := opts.getState(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			result, err := pushState(ctx, c, state, raw, "kubeterra state push", force)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("state %s/%s is replaced with serial %d\n", state.Namespace, state.Name, result.Serial)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "push the state even if it belongs to other lineage or is older")
+
+	return cmd
+}
+
+func stateShowCmd(opts *stateOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show NAME",
+		Short: "show resources and outputs of terraform state",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			_, store, err := opts.stateStore(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			raw, err := store.Get(ctx)
+			if err != nil {
+				return err
+			}
+
+			tfstate := terraformState{}
+			if err := json.Unmarshal(raw, &tfstate); err != nil {
+				return fmt.Errorf("invalid state: %w", err)
+			}
+
+			return printState(os.Stdout, &tfstate)
+		},
+	}
+}
+
+// terraformState is the part of terraform state JSON shown to users
+type terraformState struct {
+	TerraformVersion string `json:"terraform_version"`
+	Serial           int64  `json:"serial"`
+	Lineage          string `json:"lineage"`
+	Outputs          map[string]struct {
+		Value     json.RawMessage `json:"value"`
+		Sensitive bool            `json:"sensitive"`
+	} `json:"outputs"`
+	Resources []struct {
+		Module    string            `json:"module"`
+		Mode      string            `json:"mode"`
+		Type      string            `json:"type"`
+		Name      string            `json:"name"`
+		Provider  string            `json:"provider"`
+		Instances []json.RawMessage `json:"instances"`
+	} `json:"resources"`
+}
+
+func printState(out io.Writer, tfstate *terraformState) error {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintf(w, "Serial:\t%d\n", tfstate.Serial)
+	fmt.Fprintf(w, "Lineage:\t%s\n", tfstate.Lineage)
+	fmt.Fprintf(w, "Terraform version:\t%s\n", tfstate.TerraformVersion)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "RESOURCE\tPROVIDER\tINSTANCES")
+	for _, res := range tfstate.Resources {
+		address := res.Type + "." + res.Name
+		if res.Mode == "data" {
+			address = "data." + address
+		}
+		if res.Module != "" {
+			address = res.Module + "." + address
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", address, res.Provider, len(res.Instances))
+	}
+
+	names := make([]string, 0, len(tfstate.Outputs))
+	for name := range tfstate.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "OUTPUT\tVALUE")
+	for _, name := range names {
+		output := tfstate.Outputs[name]
+		value := string(output.Value)
+		if output.Sensitive {
+			value = "<sensitive>"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, value)
+	}
+
+	return w.Flush()
+}
+
+// stateSummary returns serial, lineage and lock holder of the state for
+// listing, unknown values are shown as "-"
+func stateSummary(ctx context.Context, c client.Client, state *terraformv1beta1.TerraformState) (serial, lineage, lockedBy string) {
+	serial, lineage, lockedBy = "-", "-", "-"
+
+	store, err := statestore.New(ctx, c, state, "")
+	if err != nil {
+		return
+	}
+
+	if holder, err := store.Holder(ctx); err == nil && holder != nil {
+		lockedBy = holder.Who
+		if lockedBy == "" {
+			lockedBy = holder.ID
+		}
+	}
+
+	raw, err := store.Get(ctx)
+	if err != nil {
+		return
+	}
+	if info, err := statestore.ParseStateInfo(raw); err == nil {
+		serial = strconv.FormatInt(info.Serial, 10)
+		lineage = info.Lineage
+	}
+	return
+}
+
+func storageName(storage *terraformv1beta1.TerraformStateStorage) string {
+	switch {
+	case storage == nil:
+		return "TerraformState"
+	case storage.Secret != nil:
+		return "Secret/" + storage.Secret.Name
+	case storage.Filesystem != nil:
+		return "PersistentVolumeClaim/" + storage.Filesystem.ClaimName
+	case storage.S3 != nil:
+		return "S3/" + storage.S3.Bucket
+	}
+	return "-"
+}
+
+// pushState replaces terraform state of TerraformState under the lock, see
+// revision.Push
+func pushState(ctx context.Context, c client.Client, state *terraformv1beta1.TerraformState, raw []byte, info string, force bool) (*revision.PushResult, error) {
+	store, err := statestore.New(ctx, c, state, "")
+	if err != nil {
+		return nil, err
+	}
+
+	lockID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	lock := &statestore.LockInfo{
+		ID:        lockID,
+		Operation: "push",
+		Info:      info,
+		Who:       defaultWho(),
+	}
+	if err := store.Lock(ctx, lock); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := store.Unlock(ctx, lockID); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to unlock the state, lock ID %s: %v\n", lockID, err)
+		}
+	}()
+
+	result, err := revision.Push(ctx, c, state, store, lockID, raw, revision.PushOptions{Force: force})
+	if errors.Is(err, statestore.ErrIntegrity) {
+		return nil, fmt.Errorf("%w, use --force to push it anyway", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.HistoryErr != nil {
+		fmt.Fprintf(os.Stderr, "state revisions are not recorded: %v\n", result.HistoryErr)
+	}
+	return result, nil
+}
+
+func readStateFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// getState returns client along with the named TerraformState
+func (opts *stateOptions) getState(ctx context.Context, name string) (client.Client, *terraformv1beta1.TerraformState, error) {
+	c, err := newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := &terraformv1beta1.TerraformState{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opts.Namespace, Name: name}, state); err != nil {
+		return nil, nil, err
+	}
+	return c, state, nil
+}
+
+// stateStore returns TerraformState along with the store of its terraform
+// state, filesystem storages are not reachable from the CLI
+func (opts *stateOptions) stateStore(ctx context.Context, name string) (*terraformv1beta1.TerraformState, statestore.StateStore, error) {
+	c, state, err := opts.getState(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := statestore.New(ctx, c, state, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, store, nil
+}
+
+// newClient returns client of the cluster configured by kubeconfig
+func newClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// defaultWho returns user@hostname, the same way terraform identifies lock
+// holders
+func defaultWho() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return username
+	}
+	return username + "@" + hostname
+}
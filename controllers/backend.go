@@ -0,0 +1,229 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	hcljson "github.com/hashicorp/hcl/v2/json"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var (
+	terraformSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	}
+	backendSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "backend", LabelNames: []string{"type"}}},
+	}
+	moduleSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "module", LabelNames: []string{"name"}}},
+	}
+	moduleSourceSchema = &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "source"}},
+	}
+)
+
+// stripBackendBlocks removes `backend "http"` blocks from terraform blocks of
+// HCL source, since kubeterra generates its own backend configuration. Any
+// other backend type is rejected, as it would bypass TerraformState. Backends
+// of JSON sources can't be removed in place and are rejected as well.
+func stripBackendBlocks(filename, src string) (string, error) {
+	if jsonSource(filename) {
+		if err := rejectJSONBackends(filename, src); err != nil {
+			return "", err
+		}
+		return src, nil
+	}
+
+	blocks, err := backendBlocks(filename, src)
+	if err != nil {
+		return "", err
+	}
+
+	for _, block := range blocks {
+		if backendType := block.Labels[0]; backendType != "http" {
+			return "", fmt.Errorf("%s: backend %q is not supported, terraform state is managed by kubeterra", block.DefRange(), backendType)
+		}
+	}
+
+	return removeBlocks(src, blocks), nil
+}
+
+// RemoveBackendBlocks removes backend blocks of any type from terraform blocks
+// of HCL source, and returns types of the removed backends. The rest of the
+// source is kept as is. JSON sources declaring a backend are rejected.
+func RemoveBackendBlocks(filename, src string) (string, []string, error) {
+	if jsonSource(filename) {
+		if err := rejectJSONBackends(filename, src); err != nil {
+			return "", nil, err
+		}
+		return src, nil, nil
+	}
+
+	blocks, err := backendBlocks(filename, src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	backendTypes := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		backendTypes = append(backendTypes, block.Labels[0])
+	}
+
+	return removeBlocks(src, blocks), backendTypes, nil
+}
+
+// backendBlocks returns backend blocks nested directly into top-level
+// terraform blocks, in order of their appearance
+func backendBlocks(filename, src string) ([]*hclsyntax.Block, error) {
+	body, err := parseHCL(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []*hclsyntax.Block
+	for _, block := range body.Blocks {
+		if block.Type != "terraform" {
+			continue
+		}
+		for _, nested := range block.Body.Blocks {
+			if nested.Type == "backend" && len(nested.Labels) == 1 {
+				blocks = append(blocks, nested)
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+// removeBlocks cuts source ranges of the blocks out of src, blocks have to be
+// ordered by their position
+func removeBlocks(src string, blocks []*hclsyntax.Block) string {
+	var (
+		result strings.Builder
+		last   int
+	)
+	for _, block := range blocks {
+		rng := block.Range()
+		result.WriteString(src[last:rng.Start.Byte])
+		last = rng.End.Byte
+	}
+	result.WriteString(src[last:])
+
+	return result.String()
+}
+
+// rejectJSONBackends fails if JSON source declares a backend in any of its
+// terraform blocks
+func rejectJSONBackends(filename, src string) error {
+	body, err := parseConfig(filename, src)
+	if err != nil {
+		return err
+	}
+
+	content, _, diags := body.PartialContent(terraformSchema)
+	if diags.HasErrors() {
+		return diags
+	}
+	for _, block := range content.Blocks {
+		nested, _, diags := block.Body.PartialContent(backendSchema)
+		if diags.HasErrors() {
+			return diags
+		}
+		if len(nested.Blocks) > 0 {
+			backend := nested.Blocks[0]
+			return fmt.Errorf("%s: backend %q in JSON source is not supported, terraform state is managed by kubeterra", backend.DefRange, backend.Labels[0])
+		}
+	}
+	return nil
+}
+
+// LocalModuleSources returns sources of module blocks of HCL or JSON source,
+// that refer to local directories, e.g. "./modules/vpc"
+func LocalModuleSources(filename, src string) ([]string, error) {
+	body, err := parseConfig(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	content, _, diags := body.PartialContent(moduleSchema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var sources []string
+	for _, block := range content.Blocks {
+		attrs, _, diags := block.Body.PartialContent(moduleSourceSchema)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		attr, ok := attrs.Attributes["source"]
+		if !ok {
+			continue
+		}
+
+		// terraform requires module source to be a literal string
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || !value.IsKnown() || value.IsNull() || !value.Type().Equals(cty.String) {
+			return nil, fmt.Errorf("%s: module %q source must be a literal string", attr.Range, strings.Join(block.Labels, " "))
+		}
+
+		source := value.AsString()
+		if strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources, nil
+}
+
+// jsonSource tells if terraform configuration file is in JSON syntax
+func jsonSource(filename string) bool {
+	return strings.HasSuffix(filename, ".json")
+}
+
+// parseConfig parses terraform configuration file in either HCL native or
+// JSON syntax
+func parseConfig(filename, src string) (hcl.Body, error) {
+	if jsonSource(filename) {
+		file, diags := hcljson.Parse([]byte(src), filename)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		return file.Body, nil
+	}
+
+	body, err := parseHCL(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// parseHCL parses HCL native syntax of terraform configuration file
+func parseHCL(filename, src string) (*hclsyntax.Body, error) {
+	file, diags := hclsyntax.ParseConfig([]byte(src), filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return file.Body.(*hclsyntax.Body), nil
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripBackendBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		src      string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "no terraform block",
+			src:  `resource "random_id" "rand" { byte_length = 4 }`,
+			want: `resource "random_id" "rand" { byte_length = 4 }`,
+		},
+		{
+			name: "http backend is stripped",
+			src: `terraform {
+  required_version = ">= 0.12"
+  backend "http" {
+    address = "http://localhost:8081/"
+  }
+}
+resource "random_id" "rand" {}
+`,
+			want: `terraform {
+  required_version = ">= 0.12"
+  
+}
+resource "random_id" "rand" {}
+`,
+		},
+		{
+			name: "other backends are rejected",
+			src: `terraform {
+  backend "s3" { bucket = "state" }
+}
+`,
+			wantErr: true,
+		},
+		{
+			name: "backend outside of terraform block is kept",
+			src: `module "app" {
+  source = "./app"
+  backend "http" { a = 1 }
+}
+`,
+			want: `module "app" {
+  source = "./app"
+  backend "http" { a = 1 }
+}
+`,
+		},
+		{
+			name: "strings, comments and heredocs are ignored",
+			src: `# terraform { backend "s3" {} }
+/* terraform {
+  backend "s3" {}
+} */
+locals {
+  a = "terraform { backend \"s3\" {} } ${join("}", ["{"])}"
+  b = <<-EOT
+    terraform { backend "s3" {} }
+    EOT
+}
+`,
+			want: `# terraform { backend "s3" {} }
+/* terraform {
+  backend "s3" {}
+} */
+locals {
+  a = "terraform { backend \"s3\" {} } ${join("}", ["{"])}"
+  b = <<-EOT
+    terraform { backend "s3" {} }
+    EOT
+}
+`,
+		},
+		{
+			name: "backend of other terraform block is stripped",
+			src: `terraform {
+  required_version = ">= 0.12"
+}
+terraform {
+  backend "http" {}
+  required_providers {
+    random = "~> 2.2"
+  }
+}
+`,
+			want: `terraform {
+  required_version = ">= 0.12"
+}
+terraform {
+  
+  required_providers {
+    random = "~> 2.2"
+  }
+}
+`,
+		},
+		{
+			name:    "unbalanced braces",
+			src:     `terraform {`,
+			wantErr: true,
+		},
+		{
+			name:     "JSON without backend",
+			filename: "main.tf.json",
+			src:      `{"terraform": {"required_version": ">= 0.12"}, "resource": {"random_id": {"rand": {}}}}`,
+			want:     `{"terraform": {"required_version": ">= 0.12"}, "resource": {"random_id": {"rand": {}}}}`,
+		},
+		{
+			name:     "JSON backend is rejected",
+			filename: "main.tf.json",
+			src:      `{"terraform": [{"backend": {"http": {}}}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid JSON",
+			filename: "main.tf.json",
+			src:      `{"terraform": `,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if filename == "" {
+				filename = "main.tf"
+			}
+			got, err := stripBackendBlocks(filename, tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stripBackendBlocks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("stripBackendBlocks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveBackendBlocks(t *testing.T) {
+	src := `terraform {
+  backend "s3" {
+    bucket = "state"
+  }
+}
+`
+	got, backendTypes, err := RemoveBackendBlocks("main.tf", src)
+	if err != nil {
+		t.Fatalf("RemoveBackendBlocks() error = %v", err)
+	}
+	if want := "terraform {\n  \n}\n"; got != want {
+		t.Errorf("RemoveBackendBlocks() = %q, want %q", got, want)
+	}
+	if len(backendTypes) != 1 || backendTypes[0] != "s3" {
+		t.Errorf("RemoveBackendBlocks() types = %v, want [s3]", backendTypes)
+	}
+
+	if _, _, err := RemoveBackendBlocks("main.tf.json", `{"terraform": {"backend": {"s3": {}}}}`); err == nil {
+		t.Error("RemoveBackendBlocks() is expected to refuse backend of JSON source")
+	}
+}
+
+func TestLocalModuleSources(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		src      string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "local and remote modules",
+			src: `module "vpc" {
+  source = "./modules/vpc"
+  cidr   = "10.0.0.0/16"
+}
+module "shared" {
+  source = "../shared"
+}
+module "consul" {
+  source  = "hashicorp/consul/aws"
+  version = "0.1.0"
+}
+`,
+			want: []string{"./modules/vpc", "../shared"},
+		},
+		{
+			name: "nested source attributes are ignored",
+			src: `module "app" {
+  source = "git::https://example.com/app.git"
+  settings = {
+    source = "./not-a-module"
+  }
+}
+`,
+		},
+		{
+			name:    "source is not a literal string",
+			src:     `module "app" { source = var.app_source }`,
+			wantErr: true,
+		},
+		{
+			name:    "unclosed module",
+			src:     `module "app" { source = "./app"`,
+			wantErr: true,
+		},
+		{
+			name:     "JSON modules",
+			filename: "main.tf.json",
+			src:      `{"module": {"vpc": {"source": "./modules/vpc"}, "consul": {"source": "hashicorp/consul/aws"}}}`,
+			want:     []string{"./modules/vpc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if filename == "" {
+				filename = "main.tf"
+			}
+			got, err := LocalModuleSources(filename, tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LocalModuleSources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("LocalModuleSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
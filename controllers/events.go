@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+// Reasons of Events recorded on TerraformConfiguration. Messages never carry
+// run numbers or times, so the recorder aggregates repeated runs with the same
+// outcome, e.g. periodic plans finding no changes, into a single Event with a
+// count.
+const (
+	EventPlanStarted      = "PlanStarted"
+	EventPlanFinished     = "PlanFinished"
+	EventApprovalRequired = "ApprovalRequired"
+	EventPlanRejected     = "PlanRejected"
+	EventApplyStarted     = "ApplyStarted"
+	EventApplyFinished    = "ApplyFinished"
+	EventPlanFailed       = "PlanFailed"
+	EventApplyFailed      = "ApplyFailed"
+	EventInvalidConfig    = "InvalidConfiguration"
+	EventFinalized        = "Finalized"
+)
+
+// Reasons of Events recorded on TerraformState, locks and pushes of the state
+// are recorded by httpbackend
+const (
+	EventForceUnlocked = "ForceUnlocked"
+	EventRolledBack    = "RolledBack"
+)
+
+// recordRunStarted records start of terraform run for the spec hash
+func recordRunStarted(recorder record.EventRecorder, tfconfig runtime.Object, apply bool, specHash string) {
+	if apply {
+		recorder.Eventf(tfconfig, corev1.EventTypeNormal, EventApplyStarted, "terraform apply started for configuration %s", specHash)
+		return
+	}
+	recorder.Eventf(tfconfig, corev1.EventTypeNormal, EventPlanStarted, "terraform plan started for configuration %s", specHash)
+}
+
+// recordRunResult records outcome of the finished terraform pod, status is
+// already updated with it
+func recordRunResult(recorder record.EventRecorder, tfconfig runtime.Object, status *terapi.TerraformPlanStatus, pod corev1.Pod) {
+	terminated := terraformTerminated(pod)
+	if terminated == nil {
+		return
+	}
+	summary := outputSummary(strings.TrimSpace(terminated.Message))
+
+	switch status.Phase {
+	case terapi.TerraformPhasePlanFailed:
+		recorder.Event(tfconfig, corev1.EventTypeWarning, EventPlanFailed, failureMessage(terminated))
+	case terapi.TerraformPhaseApplyFailed:
+		recorder.Event(tfconfig, corev1.EventTypeWarning, EventApplyFailed, failureMessage(terminated))
+	case terapi.TerraformPhaseWaitingApproval:
+		recorder.Event(tfconfig, corev1.EventTypeNormal, EventPlanFinished, eventSummary("terraform plan finished", summary))
+		recorder.Event(tfconfig, corev1.EventTypeNormal, EventApprovalRequired, eventSummary("plan waits for approval", summary))
+	case terapi.TerraformPhaseDone:
+		recorder.Event(tfconfig, corev1.EventTypeNormal, EventApplyFinished, eventSummary("terraform apply finished", summary))
+	}
+}
+
+func rejectionMessage(review *terapi.TerraformPlanReview) string {
+	msg := "plan rejected"
+	if review == nil {
+		return msg
+	}
+	if review.By != "" {
+		msg += " by " + review.By
+	}
+	if review.ClaimedBy != "" {
+		msg += fmt.Sprintf(" (claimed %s)", review.ClaimedBy)
+	}
+	return eventSummary(msg, review.Reason)
+}
+
+// failureMessage explains why terraform failed with the first error it has
+// reported
+func failureMessage(terminated *corev1.ContainerStateTerminated) string {
+	msg := fmt.Sprintf("terraform exited with code %d", terminated.ExitCode)
+	for _, line := range strings.Split(terminated.Message, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "Error:") {
+			return msg + ": " + line
+		}
+	}
+	return msg
+}
+
+func eventSummary(msg, summary string) string {
+	if summary == "" {
+		return msg
+	}
+	return msg + ": " + summary
+}
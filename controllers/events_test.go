@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/resources"
+)
+
+func TestRecordRunResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		exitCode   int32
+		message    string
+		wantEvents []string
+	}{
+		{
+			name:    "plan with changes",
+			command: "plan",
+			message: "+ null_resource.test\n\nPlan: 1 to add, 0 to change, 0 to destroy.",
+			wantEvents: []string{
+				"Normal PlanFinished terraform plan finished: Plan: 1 to add, 0 to change, 0 to destroy.",
+				"Normal ApprovalRequired plan waits for approval: Plan: 1 to add, 0 to change, 0 to destroy.",
+			},
+		},
+		{
+			name:     "plan failed",
+			command:  "plan",
+			exitCode: 1,
+			message:  "Initializing...\n\nError: Invalid resource type\n\nmore details",
+			wantEvents: []string{
+				"Warning PlanFailed terraform exited with code 1: Error: Invalid resource type",
+			},
+		},
+		{
+			name:    "apply succeeded",
+			command: "apply",
+			message: "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.",
+			wantEvents: []string{
+				"Normal ApplyFinished terraform apply finished: Apply complete! Resources: 1 added, 0 changed, 0 destroyed.",
+			},
+		},
+		{
+			name:     "apply failed without error output",
+			command:  "apply",
+			exitCode: 2,
+			wantEvents: []string{
+				"Warning ApplyFailed terraform exited with code 2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{resources.TerraformCommandAnnotation: tt.command},
+				},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{
+						{Name: "terraform", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: tt.exitCode,
+							Message:  tt.message,
+						}}},
+					},
+				},
+			}
+
+			status := terapi.TerraformPlanStatus{}
+			setRunResult(&status, pod)
+
+			recorder := record.NewFakeRecorder(10)
+			recordRunResult(recorder, &terapi.TerraformConfiguration{}, &status, pod)
+			close(recorder.Events)
+
+			events := []string{}
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("recorded events = %q, want %q", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestRunNotifications(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		exitCode    int32
+		message     string
+		appliedHash string
+		wantEvents  []terapi.TerraformNotificationEvent
+	}{
+		{
+			name:       "plan with changes",
+			command:    "plan",
+			message:    "Plan: 1 to add, 0 to change, 0 to destroy.",
+			wantEvents: []terapi.TerraformNotificationEvent{terapi.TerraformNotificationWaitingApproval},
+		},
+		{
+			name:        "plan of applied configuration with changes",
+			command:     "plan",
+			message:     "Plan: 0 to add, 1 to change, 0 to destroy.",
+			appliedHash: "abcd",
+			wantEvents:  []terapi.TerraformNotificationEvent{terapi.TerraformNotificationWaitingApproval, terapi.TerraformNotificationDrifted},
+		},
+		{
+			name:    "plan without changes",
+			command: "plan",
+			message: "No changes. Infrastructure is up-to-date.",
+		},
+		{
+			name:       "apply failed",
+			command:    "apply",
+			exitCode:   1,
+			message:    "Error: Provider produced inconsistent result",
+			wantEvents: []terapi.TerraformNotificationEvent{terapi.TerraformNotificationFailed},
+		},
+		{
+			name:       "apply succeeded",
+			command:    "apply",
+			message:    "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.",
+			wantEvents: []terapi.TerraformNotificationEvent{terapi.TerraformNotificationApplied},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{resources.TerraformCommandAnnotation: tt.command},
+				},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{
+						{Name: "terraform", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: tt.exitCode,
+							Message:  tt.message,
+						}}},
+					},
+				},
+			}
+
+			status := terapi.TerraformPlanStatus{
+				ConfigurationSpecHash:        "abcd",
+				AppliedConfigurationSpecHash: tt.appliedHash,
+			}
+			setRunResult(&status, pod)
+
+			tfconfig := &terapi.TerraformConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Labels: map[string]string{"team": "infra"}},
+			}
+			notifications := runNotifications(tfconfig, &status, pod)
+
+			events := []terapi.TerraformNotificationEvent{}
+			for _, n := range notifications {
+				events = append(events, n.Event)
+				if n.Configuration != "test" || n.Namespace != "default" || n.Labels["team"] != "infra" {
+					t.Errorf("notification %+v is not about the configuration", n)
+				}
+				if n.Summary == "" && n.Message == "" {
+					t.Errorf("notification %+v has neither summary nor message", n)
+				}
+			}
+			if len(tt.wantEvents) == 0 {
+				tt.wantEvents = []terapi.TerraformNotificationEvent{}
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("notified events = %v, want %v", events, tt.wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/notifier"
+)
+
+// runNotifications returns notifications about outcome of the finished
+// terraform pod, status is already updated with it
+func runNotifications(tfconfig *terapi.TerraformConfiguration, status *terapi.TerraformPlanStatus, pod corev1.Pod) []notifier.Notification {
+	terminated := terraformTerminated(pod)
+	if terminated == nil {
+		return nil
+	}
+
+	base := newNotification(tfconfig, status)
+	base.Summary = outputSummary(strings.TrimSpace(terminated.Message))
+
+	var events []terapi.TerraformNotificationEvent
+	switch status.Phase {
+	case terapi.TerraformPhasePlanFailed, terapi.TerraformPhaseApplyFailed:
+		base.Summary = ""
+		base.Message = failureMessage(terminated)
+		events = append(events, terapi.TerraformNotificationFailed)
+	case terapi.TerraformPhaseWaitingApproval:
+		// plans without changes wait for approval too, nobody has to be
+		// bothered with them
+		if !status.HasChanges() {
+			break
+		}
+		events = append(events, terapi.TerraformNotificationWaitingApproval)
+		if status.Drifted() {
+			events = append(events, terapi.TerraformNotificationDrifted)
+		}
+	case terapi.TerraformPhaseDone:
+		events = append(events, terapi.TerraformNotificationApplied)
+	}
+
+	notifications := make([]notifier.Notification, 0, len(events))
+	for _, event := range events {
+		n := base
+		n.Event = event
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+// failedNotification returns notification about terraform run failed before
+// it has started
+func failedNotification(tfconfig *terapi.TerraformConfiguration, status *terapi.TerraformPlanStatus, message string) notifier.Notification {
+	n := newNotification(tfconfig, status)
+	n.Event = terapi.TerraformNotificationFailed
+	n.Message = message
+	return n
+}
+
+func newNotification(tfconfig *terapi.TerraformConfiguration, status *terapi.TerraformPlanStatus) notifier.Notification {
+	return notifier.Notification{
+		Namespace:     tfconfig.Namespace,
+		Configuration: tfconfig.Name,
+		Phase:         status.Phase,
+		Run:           status.Run,
+		Time:          metav1.Now().Rfc3339Copy(),
+		Labels:        tfconfig.Labels,
+	}
+}
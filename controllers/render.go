@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+// Render returns objects controllers create for the first run of
+// TerraformConfiguration: TerraformState, TerraformPlan, ConfigMap with
+// terraform configuration and terraform Pod. Nothing is created for paused
+// configurations. Output doesn't depend on the time or randomness, so the
+// per-run Secret with credentials, the initial state with random lineage and
+// the next scheduled run are not rendered.
+func Render(tfconfig *terapi.TerraformConfiguration, scheme *runtime.Scheme) ([]runtime.Object, error) {
+	tfconfig = tfconfig.DeepCopy()
+	if tfconfig.Namespace == "" {
+		tfconfig.Namespace = "default"
+	}
+
+	if tfconfig.Spec.Mode == terapi.TerraformModePaused {
+		return nil, nil
+	}
+
+	tfstate := &terapi.TerraformState{}
+	tfstate.Name, tfstate.Namespace = tfconfig.Name, tfconfig.Namespace
+	if tfconfig.Spec.StateStorage != nil {
+		tfstate.Spec.Storage = tfconfig.Spec.StateStorage.DeepCopy()
+	}
+	if err := ctrl.SetControllerReference(tfconfig, tfstate, scheme); err != nil {
+		return nil, err
+	}
+
+	tfplan := &terapi.TerraformPlan{}
+	tfplan.Name, tfplan.Namespace = tfconfig.Name, tfconfig.Namespace
+	if err := generateTerraformPlan(tfconfig, tfplan, scheme); err != nil {
+		return nil, err
+	}
+	tfplan.Spec.NextRunAt = nil
+
+	specHash := ConfigurationSpecHash(tfconfig.Spec)
+	tfplan.Status.ConfigurationSpecHash = specHash
+	tfplan.Status.Run = 1
+	tfplan.Spec.Approved = planApproved(tfconfig, tfplan, specHash)
+	setRunStarted(&tfplan.Status, tfplan.Spec.Approved)
+
+	if tfconfig.Spec.Template == nil {
+		tfconfig.Spec.Template = &terapi.TerraformConfigurationTemplate{}
+	}
+
+	cm, err := generateConfigMap(tfconfig, tfplan)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := generatePod(tfconfig, tfplan, tfstate)
+
+	if err := ctrl.SetControllerReference(tfplan, cm, scheme); err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(tfplan, pod, scheme); err != nil {
+		return nil, err
+	}
+
+	objs := []runtime.Object{tfstate, tfplan, cm, pod}
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+	return objs, nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/resources"
+)
+
+func TestRender(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terapi.AddToScheme(scheme)
+
+	newConfig := func(mode terapi.TerraformMode, source string) *terapi.TerraformConfiguration {
+		return &terapi.TerraformConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "test"},
+			Spec: terapi.TerraformConfigurationSpec{
+				Mode:    mode,
+				Sources: []terapi.TerraformSource{{Name: "main.tf", Content: source}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		tfconfig    *terapi.TerraformConfiguration
+		wantObjects int
+		wantCommand string
+		wantErr     bool
+	}{
+		{
+			name:        "manual configuration is planned",
+			tfconfig:    newConfig(terapi.TerraformModeManual, `resource "random_id" "rand" {}`),
+			wantObjects: 4,
+			wantCommand: "plan",
+		},
+		{
+			name:        "auto configuration is applied",
+			tfconfig:    newConfig(terapi.TerraformModeAuto, `resource "random_id" "rand" {}`),
+			wantObjects: 4,
+			wantCommand: "apply",
+		},
+		{
+			name:     "paused configuration renders nothing",
+			tfconfig: newConfig(terapi.TerraformModePaused, `resource "random_id" "rand" {}`),
+		},
+		{
+			name: "local modules are kept in subdirectories",
+			tfconfig: &terapi.TerraformConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: terapi.TerraformConfigurationSpec{
+					Sources: []terapi.TerraformSource{
+						{Name: "main.tf", Content: `module "vpc" { source = "./modules/vpc" }`},
+						{Name: "modules/vpc/main.tf", Content: `resource "random_id" "rand" {}`},
+					},
+				},
+			},
+			wantObjects: 4,
+			wantCommand: "plan",
+		},
+		{
+			name: "sources outside of working directory are rejected",
+			tfconfig: &terapi.TerraformConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "test"},
+				Spec: terapi.TerraformConfigurationSpec{
+					Sources: []terapi.TerraformSource{{Name: "../main.tf"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "other backends are rejected",
+			tfconfig: newConfig(terapi.TerraformModeManual, `terraform { backend "s3" {} }`),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := Render(tt.tfconfig, scheme)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(objs) != tt.wantObjects {
+				t.Fatalf("Render() returned %d objects, want %d", len(objs), tt.wantObjects)
+			}
+			if tt.wantObjects == 0 {
+				return
+			}
+
+			again, err := Render(tt.tfconfig, scheme)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(objs, again) {
+				t.Error("Render() output is expected to be stable")
+			}
+
+			tfplan := objs[1].(*terapi.TerraformPlan)
+			pod := objs[3].(*corev1.Pod)
+			if pod.Namespace != "default" {
+				t.Errorf("pod namespace = %q, want default", pod.Namespace)
+			}
+			if pod.Name != hashedName(tfplan) {
+				t.Errorf("pod name = %q, want %q", pod.Name, hashedName(tfplan))
+			}
+			if tfplan.Status.ConfigurationSpecHash != ConfigurationSpecHash(tt.tfconfig.Spec) {
+				t.Errorf("plan spec hash = %q, want %q", tfplan.Status.ConfigurationSpecHash, ConfigurationSpecHash(tt.tfconfig.Spec))
+			}
+			if got := pod.Annotations[resources.TerraformCommandAnnotation]; got != tt.wantCommand {
+				t.Errorf("terraform command = %q, want %q", got, tt.wantCommand)
+			}
+			cm := objs[2].(*corev1.ConfigMap)
+			for _, source := range tt.tfconfig.Spec.Sources {
+				if _, ok := cm.Data[sourceKey(source.Name)]; !ok {
+					t.Errorf("source %s is missing in configmap", source.Name)
+				}
+			}
+
+			if pod.Kind != "Pod" || tfplan.Kind != "TerraformPlan" {
+				t.Error("rendered objects are expected to have their kind set")
+			}
+		})
+	}
+}
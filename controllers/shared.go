@@ -25,6 +25,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/rand"
 	khash "k8s.io/kubernetes/pkg/util/hash"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -71,7 +72,10 @@ type stateInfo struct {
 	Serial  int    `json:"serial"`
 }
 
-func indexerFunc(ownerKind, ownerAPIGV string) func(runtime.Object) []string {
+// indexerFunc indexes objects by the name of their controller of given kind.
+// Only API group is compared, so objects owned by any served API version
+// are indexed.
+func indexerFunc(ownerKind, ownerGroup string) func(runtime.Object) []string {
 	return func(obj runtime.Object) []string {
 		metaObj, ok := obj.(metav1.Object)
 		if !ok {
@@ -83,7 +87,12 @@ func indexerFunc(ownerKind, ownerAPIGV string) func(runtime.Object) []string {
 			return nil
 		}
 
-		if owner.APIVersion != ownerAPIGV || owner.Kind != ownerKind {
+		ownerGV, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			return nil
+		}
+
+		if ownerGV.Group != ownerGroup || owner.Kind != ownerKind {
 			return nil
 		}
 
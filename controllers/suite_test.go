@@ -33,6 +33,7 @@ import (
 	// +kubebuilder:scaffold:imports
 
 	terraformv1alpha1 "github.com/loodse/kubeterra/api/v1alpha1"
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -66,6 +67,9 @@ var _ = BeforeSuite(func(done Done) {
 	err = terraformv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = terraformv1beta1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
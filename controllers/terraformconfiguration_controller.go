@@ -23,13 +23,18 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/hashicorp/go-uuid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	terapi "github.com/loodse/kubeterra/api/v1alpha1"
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/statestore"
 )
 
 const (
@@ -39,8 +44,9 @@ const (
 // TerraformConfigurationReconciler reconciles a TerraformConfiguration object
 type TerraformConfigurationReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformconfigurations,verbs=*
@@ -49,11 +55,12 @@ type TerraformConfigurationReconciler struct {
 // +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformplans/status,verbs=*
 // +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformstates,verbs=*
 // +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformstates/status,verbs=*
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // SetupWithManager dependency inject controller
 func (r *TerraformConfigurationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	mgrIndexer := mgr.GetFieldIndexer()
-	indexer := indexerFunc("TerraformConfiguration", terapi.GroupVersion.String())
+	indexer := indexerFunc("TerraformConfiguration", terapi.GroupVersion.Group)
 
 	if err := mgrIndexer.IndexField(&terapi.TerraformPlan{}, indexOwnerKey, indexer); err != nil {
 		return err
@@ -71,13 +78,13 @@ func (r *TerraformConfigurationReconciler) SetupWithManager(mgr ctrl.Manager) er
 // Reconcile state
 func (r *TerraformConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
-	log := r.Log.WithValues("terraformconfiguration", req.NamespacedName)
+	log := r.Log.WithValues(logging.ConfigurationKey, req.NamespacedName)
 	errLogMsg := logError(log)
-	defer log.Info("done")
+	defer log.V(1).Info("done")
 
 	var tfconfig terapi.TerraformConfiguration
 
-	log.Info("find TerraformConfiguration")
+	log.V(1).Info("find TerraformConfiguration")
 	if err := r.Get(ctx, req.NamespacedName, &tfconfig); err != nil {
 		if client.IgnoreNotFound(err) == nil {
 			log.Info("TerraformConfiguration not found")
@@ -86,12 +93,12 @@ func (r *TerraformConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Res
 		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformConfiguration")
 	}
 
-	log.Info("handle finalizers on TerraformConfiguration")
+	log.V(1).Info("handle finalizers on TerraformConfiguration")
 	if ok, err := r.handleFinalizers(ctx, &tfconfig, r.deleteExternalResources); !ok {
 		return ctrl.Result{}, errLogMsg(err, "finalizer handling failed")
 	}
 
-	if tfconfig.Spec.Paused {
+	if tfconfig.Spec.Mode == terapi.TerraformModePaused {
 		log.Info("TerraformConfiguration is paused")
 		return ctrl.Result{}, nil
 	}
@@ -118,16 +125,30 @@ func (r *TerraformConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Res
 		newPlan  = func() error { return generateTerraformPlan(&tfconfig, &tfplan, r.Scheme) }
 	)
 
-	log.Info("get TerraformState")
+	log.V(1).Info("get TerraformState")
 	created, err := findOrCreate(ctx, r.Client, &tfstate, newState)
 	if err != nil {
 		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformState")
 	}
 	if created {
 		log.Info("TerraformState created")
+	} else if !equality.Semantic.DeepEqual(tfconfig.Spec.StateStorage, tfstate.Spec.Storage) {
+		log.Info("state storage change is ignored, state is not migrated")
 	}
 
-	log.Info("get TerraformPlan")
+	// state uploaded ahead of the configuration, e.g. by import-dir, is
+	// adopted to be deleted along with it
+	if metav1.GetControllerOf(&tfstate) == nil {
+		if err := ctrl.SetControllerReference(&tfconfig, &tfstate, r.Scheme); err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to set TerraformState owner")
+		}
+		if err := r.Update(ctx, &tfstate); err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to adopt TerraformState")
+		}
+		log.Info("TerraformState adopted")
+	}
+
+	log.V(1).Info("get TerraformPlan")
 	created, err = findOrCreate(ctx, r.Client, &tfplan, newPlan)
 	if err != nil {
 		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformPlan")
@@ -137,8 +158,10 @@ func (r *TerraformConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Res
 		log.Info("TerraformPlan created")
 	}
 
-	if tfconfig.Status.Phase != tfplan.Status.Phase {
-		log.Info("TerraformConfiguration.Status update")
+	if tfconfig.Status.Phase != tfplan.Status.Phase || !equality.Semantic.DeepEqual(tfconfig.Status.Conditions, tfplan.Status.Conditions) {
+		log.V(1).Info("TerraformConfiguration.Status update")
+		tfconfig.Status.Phase = tfplan.Status.Phase
+		tfconfig.Status.Conditions = tfplan.Status.Conditions
 		if statusErr := r.Status().Update(ctx, &tfconfig); statusErr != nil {
 			return ctrl.Result{}, errLogMsg(statusErr, "unable to update TerraformConfiguration.Status")
 		}
@@ -159,13 +182,19 @@ func (r *TerraformConfigurationReconciler) Reconcile(req ctrl.Request) (ctrl.Res
 			return ctrl.Result{}, errLogMsg(retryErr, "unable to update TerraformPlan.Spec.NextRunAt")
 		}
 		result.RequeueAfter = requeueAfter
-		log.Info("RequeueAfter", "RequeueAfter", result.RequeueAfter)
+		log.V(1).Info("RequeueAfter", "RequeueAfter", result.RequeueAfter)
 	}
 
 	return result, nil
 }
 
 func (r *TerraformConfigurationReconciler) generateTerraformState(config *terapi.TerraformConfiguration, state *terapi.TerraformState) error {
+	if config.Spec.StateStorage != nil {
+		// terraform initializes external storage on the first push
+		state.Spec.Storage = config.Spec.StateStorage.DeepCopy()
+		return ctrl.SetControllerReference(config, state, r.Scheme)
+	}
+
 	lineage, err := uuid.GenerateUUID()
 	if err != nil {
 		return err
@@ -181,8 +210,9 @@ func (r *TerraformConfigurationReconciler) generateTerraformState(config *terapi
 		return err
 	}
 
-	state.Spec.State = &runtime.RawExtension{
-		Raw: initialStateMarshaled,
+	state.Spec.CompressedState, err = statestore.Compress(initialStateMarshaled)
+	if err != nil {
+		return err
 	}
 
 	return ctrl.SetControllerReference(config, state, r.Scheme)
@@ -210,7 +240,7 @@ func planScheduleNextRunAt(tfconfig *terapi.TerraformConfiguration, tfplan *tera
 }
 
 func generateTerraformPlan(tfconf *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan, scheme *runtime.Scheme) error {
-	tfplan.Spec.Approved = tfconf.Spec.AutoApprove
+	tfplan.Spec.Approved = tfconf.Spec.Mode == terapi.TerraformModeAuto
 	planScheduleNextRunAt(tfconf, tfplan)
 	return ctrl.SetControllerReference(tfconf, tfplan, scheme)
 }
@@ -241,6 +271,7 @@ func (r *TerraformConfigurationReconciler) handleFinalizers(ctx context.Context,
 		if err := cleanup(); err != nil {
 			return false, err
 		}
+		r.Recorder.Event(tfconf, corev1.EventTypeNormal, EventFinalized, "finalizer removed, resources managed by terraform are kept as destroy is not supported")
 
 		tfconf.ObjectMeta.Finalizers = removeString(tfconf.ObjectMeta.Finalizers, configurationFinalizerName)
 		if err := r.Update(ctx, tfconf); err != nil {
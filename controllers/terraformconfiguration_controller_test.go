@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+func TestReconcileAdoptsState(t *testing.T) {
+	tests := []struct {
+		name      string
+		owners    []metav1.OwnerReference
+		wantOwner types.UID
+	}{
+		{
+			name:      "uploaded state",
+			wantOwner: "config-uid",
+		},
+		{
+			name: "state of other controller",
+			owners: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       "other",
+				UID:        "other-uid",
+				Controller: func(b bool) *bool { return &b }(true),
+			}},
+			wantOwner: "other-uid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			_ = terapi.AddToScheme(scheme)
+
+			key := client.ObjectKey{Namespace: "default", Name: "test"}
+			c := fake.NewFakeClientWithScheme(scheme,
+				&terapi.TerraformConfiguration{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       key.Name,
+						Namespace:  key.Namespace,
+						UID:        "config-uid",
+						Finalizers: []string{configurationFinalizerName},
+					},
+					Spec: terapi.TerraformConfigurationSpec{
+						Sources: []terapi.TerraformSource{{Name: "main.tf", Content: `resource "random_id" "rand" {}`}},
+					},
+					Status: terapi.TerraformConfigurationStatus{Phase: terapi.TerraformPhasePlanScheduled},
+				},
+				&terapi.TerraformState{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            key.Name,
+						Namespace:       key.Namespace,
+						OwnerReferences: tt.owners,
+					},
+				},
+			)
+
+			r := &TerraformConfigurationReconciler{
+				Client:   c,
+				Log:      log.NullLogger{},
+				Scheme:   scheme,
+				Recorder: record.NewFakeRecorder(100),
+			}
+			if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+
+			tfstate := terapi.TerraformState{}
+			if err := c.Get(context.Background(), key, &tfstate); err != nil {
+				t.Fatal(err)
+			}
+			owner := metav1.GetControllerOf(&tfstate)
+			if owner == nil || owner.UID != tt.wantOwner {
+				t.Errorf("TerraformState controller = %+v, want %s", owner, tt.wantOwner)
+			}
+		})
+	}
+}
@@ -17,9 +17,17 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -27,27 +35,48 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	terapi "github.com/loodse/kubeterra/api/v1alpha1"
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/httpbackend"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/metrics"
+	"github.com/loodse/kubeterra/notifier"
 	"github.com/loodse/kubeterra/resources"
 )
 
+const (
+	// backendCertificateValidity is for how long httpbackend sidecar
+	// certificate is valid, it's generated per run so it only has to outlive
+	// the pod
+	backendCertificateValidity = 30 * 24 * time.Hour
+
+	// terraformLogHistoryLimit is how many runs of TerraformPlan have their
+	// logs kept
+	terraformLogHistoryLimit = 10
+
+	// maxTerraformLogSize limits saved terraform logs to fit a ConfigMap
+	maxTerraformLogSize = 900 * 1024
+)
+
 // TerraformPlanReconciler reconciles a TerraformPlan object
 type TerraformPlanReconciler struct {
 	client.Client
 	Log       logr.Logger
 	Scheme    *runtime.Scheme
 	PodClient corev1typed.PodsGetter
+	Recorder  record.EventRecorder
+	Notifier  *notifier.Notifier
 }
 
 // SetupWithManager dependency inject controller
 func (r *TerraformPlanReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	mgrIndexer := mgr.GetFieldIndexer()
-	indexer := indexerFunc("TerraformPlan", terapi.GroupVersion.String())
+	indexer := indexerFunc("TerraformPlan", terapi.GroupVersion.Group)
 
 	if err := mgrIndexer.IndexField(&corev1.Pod{}, indexOwnerKey, indexer); err != nil {
 		return err
@@ -69,15 +98,19 @@ func (r *TerraformPlanReconciler) SetupWithManager(mgr ctrl.Manager) error {
 // +kubebuilder:rbac:groups=core,resources=pods/status,verbs=*
 // +kubebuilder:rbac:groups=core,resources=pods/log,verbs=*
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=*
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=*
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformnotifications,verbs=get;list;watch
+// +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformnotifications/status,verbs=get;update;patch
 
 // Reconcile state
 func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) { //nolint:gocyclo
 	ctx := context.Background()
-	log := r.Log.WithValues("terraformplan", req.NamespacedName)
+	log := r.Log.WithValues(logging.ConfigurationKey, req.NamespacedName, logging.PlanKey, req.NamespacedName)
 	errLogMsg := logError(log)
-	defer log.Info("done")
+	defer log.V(1).Info("done")
 
-	log.Info("get TerraformPlan")
+	log.V(1).Info("get TerraformPlan")
 	var tfplan terapi.TerraformPlan
 	if err := r.Get(ctx, req.NamespacedName, &tfplan); err != nil {
 		if client.IgnoreNotFound(err) == nil {
@@ -88,7 +121,7 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 	}
 
 	if tfplan.Status.Phase == "" {
-		log.Info("phase is empty")
+		log.V(1).Info("phase is empty")
 		tfplan.Status.Phase = terapi.TerraformPhasePlanScheduled
 		return ctrl.Result{}, errLogMsg(r.Status().Update(ctx, &tfplan), "failed to update TerraformPlan.Status")
 	}
@@ -99,7 +132,7 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		return ctrl.Result{}, nil
 	}
 
-	log.Info("get TerraformConfiguration")
+	log.V(1).Info("get TerraformConfiguration")
 	var tfconfig terapi.TerraformConfiguration
 	if err := r.Get(ctx, req.NamespacedName, &tfconfig); err != nil {
 		if client.IgnoreNotFound(err) == nil {
@@ -109,13 +142,13 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		return ctrl.Result{}, err
 	}
 
-	if tfconfig.Spec.Paused {
+	if tfconfig.Spec.Mode == terapi.TerraformModePaused {
 		log.Info("TerraformConfiguration is paused")
 		return ctrl.Result{}, nil
 	}
 
 	now := metav1.Now().Rfc3339Copy()
-	currentSpecHash := deepHashObject(tfconfig.Spec)
+	currentSpecHash := ConfigurationSpecHash(tfconfig.Spec)
 	tfconfSpecChanged := tfplan.Status.ConfigurationSpecHash != currentSpecHash
 	scheduleTrigger := false
 	tfplan.Status.ConfigurationSpecHash = currentSpecHash
@@ -135,14 +168,19 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		}
 	}
 
-	newRunRequested := tfconfSpecChanged || scheduleTrigger
+	// approval is only honored for the plan it was given to
+	approvalRequested := tfplan.Spec.Approved
+	tfplan.Spec.Approved = planApproved(&tfconfig, &tfplan, currentSpecHash)
+	approvedRun := !tfconfSpecChanged && tfplan.Spec.Approved && tfplan.Status.Phase == terapi.TerraformPhaseWaitingApproval
+
+	newRunRequested := tfconfSpecChanged || scheduleTrigger || approvedRun
 
 	if tfconfig.Spec.Template == nil {
 		// work around NPE
 		tfconfig.Spec.Template = &terapi.TerraformConfigurationTemplate{}
 	}
 
-	log.Info("params", "tfconfSpecChanged", tfconfSpecChanged, "runScheduled", scheduleTrigger)
+	log.V(1).Info("params", "tfconfSpecChanged", tfconfSpecChanged, "runScheduled", scheduleTrigger, "approved", tfplan.Spec.Approved)
 
 	if newRunRequested {
 		if tfconfSpecChanged {
@@ -151,12 +189,41 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		if scheduleTrigger {
 			log.Info("TerraformPlan.Spec.NextRunAt triggered")
 		}
+		if approvedRun {
+			log.Info("TerraformPlan approved")
+		}
 
-		log.Info("generate terraform pod")
-		pod := generatePod(&tfconfig, &tfplan)
+		run := tfplan.Status.Run + 1
+		tfplan.Status.Run = run
 
-		log.Info("generate terraform configMap")
-		cm := generateConfigMap(&tfconfig, &tfplan)
+		log.V(1).Info("generate terraform configMap")
+		cm, err := generateConfigMap(&tfconfig, &tfplan)
+		if err != nil {
+			log.Info("invalid terraform configuration", "reason", err.Error())
+			r.Recorder.Eventf(&tfconfig, corev1.EventTypeWarning, EventInvalidConfig, "invalid terraform configuration: %v", err)
+			if failErr := r.failPlan(ctx, &tfplan, currentSpecHash, EventInvalidConfig, err); failErr != nil {
+				return ctrl.Result{}, errLogMsg(failErr, "can't update TerraformPlan.Status")
+			}
+			r.Notifier.Notify(failedNotification(&tfconfig, &tfplan.Status, "invalid terraform configuration: "+err.Error()))
+			return ctrl.Result{}, nil
+		}
+
+		log.V(1).Info("generate httpbackend secret")
+		secret, err := generateBackendSecret(&tfplan)
+		if err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to generate httpbackend secret")
+		}
+
+		log.V(1).Info("get TerraformState")
+		var tfstate terapi.TerraformState
+		if err := r.Get(ctx, req.NamespacedName, &tfstate); err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to get TerraformState")
+		}
+
+		log.V(1).Info("generate terraform pod")
+		pod := generatePod(&tfconfig, &tfplan, &tfstate)
+		log = log.WithValues(logging.RunIDKey, pod.Name)
+		errLogMsg = logError(log)
 
 		if err := ctrl.SetControllerReference(&tfplan, pod, r.Scheme); err != nil {
 			return ctrl.Result{}, errLogMsg(err, "unable to set pod controller reference", "pod", pod.Name)
@@ -166,78 +233,132 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 			return ctrl.Result{}, errLogMsg(err, "unable to set configmap controller reference", "configmap", cm.Name)
 		}
 
-		log.Info("create terraform configMap")
+		if err := ctrl.SetControllerReference(&tfplan, secret, r.Scheme); err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to set secret controller reference", "secret", secret.Name)
+		}
+
+		log.V(1).Info("create httpbackend secret")
+		if err := r.Create(ctx, secret); err != nil {
+			if !apierrors.IsAlreadyExists(err) {
+				return ctrl.Result{}, errLogMsg(err, "unable to create secret", "secret", secret.Name)
+			}
+		}
+
+		log.V(1).Info("create terraform configMap")
 		if err := r.Create(ctx, cm); err != nil {
 			if !apierrors.IsAlreadyExists(err) {
 				return ctrl.Result{}, errLogMsg(err, "unable to create configMap", "configmap", cm.Name)
 			}
 		}
 
-		log.Info("create terraform pod")
+		log.V(1).Info("create terraform pod")
 		if err := r.Create(ctx, pod); err != nil {
 			if !apierrors.IsAlreadyExists(err) {
 				return ctrl.Result{}, errLogMsg(err, "unable to create pod", "pod", pod.Name)
 			}
 		}
 
-		log.Info("update TerraformPlan.Status")
+		log.V(1).Info("update TerraformPlan.Status")
 
+		apply := tfplan.Spec.Approved
+		var approvalWait time.Duration
 		retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 			if _, err := findOrCreate(ctx, r.Client, &tfplan, noopGenerator); err != nil {
 				return err
 			}
 			lastRunAt := metav1.Now()
+			approvalWait = waitingApproval(&tfplan.Status, lastRunAt.Time)
 			tfplan.Status.ConfigurationSpecHash = currentSpecHash
 			tfplan.Status.LastRunAt = &lastRunAt
+			tfplan.Status.Run = run
+			setRunStarted(&tfplan.Status, apply)
 			return r.Status().Update(ctx, &tfplan)
 		})
+		if retryErr == nil {
+			recordRunStarted(r.Recorder, &tfconfig, apply, currentSpecHash)
+			if apply && approvalWait > 0 {
+				metrics.ObserveApprovalWait(metrics.ResultApproved, approvalWait)
+			}
+		}
 
 		return ctrl.Result{}, errLogMsg(retryErr, "can't update TerraformPlan.Status")
 	}
 
-	log.Info("podList")
+	if tfplan.Status.Phase == terapi.TerraformPhaseWaitingApproval && planRejected(&tfplan, currentSpecHash) &&
+		terapi.IsConditionTrue(tfplan.Status.Conditions, terapi.TerraformConditionApprovalRequired) {
+		log.Info("TerraformPlan rejected")
+		var approvalWait time.Duration
+		retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if _, err := findOrCreate(ctx, r.Client, &tfplan, noopGenerator); err != nil {
+				return err
+			}
+			approvalWait = waitingApproval(&tfplan.Status, time.Now())
+			setRejected(&tfplan.Status, tfplan.Spec.Review)
+			return r.Status().Update(ctx, &tfplan)
+		})
+		if retryErr == nil {
+			r.Recorder.Event(&tfconfig, corev1.EventTypeNormal, EventPlanRejected, rejectionMessage(tfplan.Spec.Review))
+			if approvalWait > 0 {
+				metrics.ObserveApprovalWait(metrics.ResultRejected, approvalWait)
+			}
+		}
+		return ctrl.Result{}, errLogMsg(retryErr, "can't update TerraformPlan.Status")
+	}
+
+	if approvalRequested && tfplan.Status.Phase == terapi.TerraformPhaseApplyRunning {
+		log.V(1).Info("approval consumed by apply")
+		if err := r.consumeApproval(ctx, &tfplan); err != nil {
+			return ctrl.Result{}, errLogMsg(err, "unable to update TerraformPlan.Spec.Approved")
+		}
+	}
+
+	log.V(1).Info("podList")
 
 	var podList corev1.PodList
 	if err := r.List(ctx, &podList, client.InNamespace(req.Namespace), client.MatchingFields{indexOwnerKey: req.Name}); err != nil {
 		return ctrl.Result{}, errLogMsg(err, "unable to list owned Pods")
 	}
 
-	prefix := hashedName(&tfplan)
+	// pods are matched by run rather than by name, which changes with the
+	// approval while the plan is still running
+	run := strconv.FormatInt(tfplan.Status.Run, 10)
 	podsToDelete := []corev1.Pod{}
 
 	for _, p := range podList.Items {
 		pod := p
 		switch {
-		case !strings.HasPrefix(pod.Name, prefix):
-			log.Info("pod has wrong prefix")
+		case pod.Labels[resources.RunLabel] != run:
+			log.V(1).Info("pod belongs to other run", logging.RunIDKey, pod.Name)
 			podsToDelete = append(podsToDelete, pod)
 		case r.terraformRunFinished(pod):
-			log.Info("terraform pod finished")
+			log.Info("terraform pod finished", logging.RunIDKey, pod.Name)
 			podsToDelete = append(podsToDelete, pod)
 
-			// logCM := &corev1.ConfigMap{
-			// 	ObjectMeta: metav1.ObjectMeta{
-			// 		Name:      hashedName(&tfplan),
-			// 		Namespace: tfplan.Namespace,
-			// 	},
-			// }
-
-			// created, err := findOrCreate(ctx, r.Client, logCM, func() error {
-			// 	logConfigMap, errGenerate := r.generateTerraformLog(&tfplan, pod)
-			// 	if errGenerate != nil {
-			// 		return errGenerate
-			// 	}
-			// 	logConfigMap.DeepCopyInto(logCM)
-			// 	return nil
-			// })
-			// if err != nil {
-			// 	return ctrl.Result{}, err
-			// }
-			// if created {
-			// 	log.Info("terraform logs saved", "namespace", logCM.Namespace, "configMap", logCM.Name)
-			// }
+			// pod left over after the result was recorded is not counted
+			// again
+			running := false
+			retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				if _, err := findOrCreate(ctx, r.Client, &tfplan, noopGenerator); err != nil {
+					return err
+				}
+				running = tfplan.Status.Phase == terapi.TerraformPhasePlanRunning || tfplan.Status.Phase == terapi.TerraformPhaseApplyRunning
+				setRunResult(&tfplan.Status, pod)
+				return r.Status().Update(ctx, &tfplan)
+			})
+			if retryErr != nil {
+				return ctrl.Result{}, errLogMsg(retryErr, "can't update TerraformPlan.Status")
+			}
+			if running {
+				observeRun(pod)
+				recordRunResult(r.Recorder, &tfconfig, &tfplan.Status, pod)
+				r.Notifier.Notify(runNotifications(&tfconfig, &tfplan.Status, pod)...)
+			}
+
+			if err := r.saveTerraformLog(ctx, &tfplan, pod); err != nil {
+				return ctrl.Result{}, errLogMsg(err, "unable to save terraform logs", logging.RunIDKey, pod.Name)
+			}
 		case !podInPhase(pod, corev1.PodPending, corev1.PodRunning):
-			log.Info("pod is not in pending or running phase", "phase", pod.Status.Phase)
+			log.V(1).Info("pod is not in pending or running phase", logging.RunIDKey, pod.Name, "phase", pod.Status.Phase)
 			podsToDelete = append(podsToDelete, pod)
 		}
 	}
@@ -255,6 +376,17 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 			}
 		}
 
+		if secretName, ok := pod.Annotations[resources.LinkedBackendSecretAnnotation]; ok {
+			secretKey := metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: pod.Namespace,
+			}
+			err := ignoreAPIErrors(r.Delete(ctx, &corev1.Secret{ObjectMeta: secretKey}), apierrors.IsNotFound, apierrors.IsGone)
+			if err != nil {
+				return ctrl.Result{}, errLogMsg(err, "unable to delete secret")
+			}
+		}
+
 		err := ignoreAPIErrors(r.Delete(ctx, &pod), apierrors.IsNotFound, apierrors.IsGone)
 		if err != nil {
 			return ctrl.Result{}, errLogMsg(err, "unable to delete pod")
@@ -264,13 +396,213 @@ func (r *TerraformPlanReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 	return ctrl.Result{}, nil
 }
 
+// failPlan marks TerraformPlan as failed for the given spec hash, so it won't
+// be retried until TerraformConfiguration.Spec is changed
+func (r *TerraformPlanReconciler) failPlan(ctx context.Context, tfplan *terapi.TerraformPlan, specHash, reason string, cause error) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if _, err := findOrCreate(ctx, r.Client, tfplan, noopGenerator); err != nil {
+			return err
+		}
+		tfplan.Status.ConfigurationSpecHash = specHash
+		tfplan.Status.Phase = terapi.TerraformPhasePlanFailed
+		terapi.SetCondition(&tfplan.Status.Conditions, terapi.TerraformCondition{
+			Type:    terapi.TerraformConditionFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  reason,
+			Message: cause.Error(),
+		})
+		return r.Status().Update(ctx, tfplan)
+	})
+}
+
 func (r *TerraformPlanReconciler) terraformRunFinished(pod corev1.Pod) bool {
+	return terraformTerminated(pod) != nil
+}
+
+// terraformTerminated returns terminated state of terraform container
+func terraformTerminated(pod corev1.Pod) *corev1.ContainerStateTerminated {
 	for _, contStatus := range pod.Status.ContainerStatuses {
 		if contStatus.Name == "terraform" && contStatus.State.Terminated != nil {
-			return true
+			return contStatus.State.Terminated
 		}
 	}
-	return false
+	return nil
+}
+
+// ConfigurationSpecHash returns hash of TerraformConfigurationSpec, plans are
+// made and approved for the given hash
+func ConfigurationSpecHash(spec terapi.TerraformConfigurationSpec) string {
+	return deepHashObject(spec)
+}
+
+// planApproved tells if the plan for specHash may be applied. Approvals given
+// with "kubeterra plan approve" only apply to the reviewed spec hash and run.
+func planApproved(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan, specHash string) bool {
+	if tfconfig.Spec.Mode == terapi.TerraformModeAuto {
+		return true
+	}
+	if !tfplan.Spec.Approved {
+		return false
+	}
+	review := tfplan.Spec.Review
+	return review == nil || (review.Approved && reviewApplies(review, &tfplan.Status, specHash))
+}
+
+// planRejected tells if the plan for specHash was rejected, rejection holds
+// for every later plan of the same spec hash
+func planRejected(tfplan *terapi.TerraformPlan, specHash string) bool {
+	review := tfplan.Spec.Review
+	return review != nil && !review.Approved && review.ConfigurationSpecHash == specHash
+}
+
+// reviewApplies tells if the review was given to the latest plan, reviews
+// made by earlier versions aren't bound to a run
+func reviewApplies(review *terapi.TerraformPlanReview, status *terapi.TerraformPlanStatus, specHash string) bool {
+	return review.ConfigurationSpecHash == specHash && (review.Run == 0 || review.Run == status.Run)
+}
+
+// consumeApproval clears the approval once the apply run it has started is
+// recorded, so later runs wait for approval again
+func (r *TerraformPlanReconciler) consumeApproval(ctx context.Context, tfplan *terapi.TerraformPlan) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &terapi.TerraformPlan{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: tfplan.Namespace, Name: tfplan.Name}, latest); err != nil {
+			return err
+		}
+		if !latest.Spec.Approved {
+			return nil
+		}
+		latest.Spec.Approved = false
+		return r.Update(ctx, latest)
+	})
+}
+
+// setRunStarted moves status to the phase of just started terraform run
+func setRunStarted(status *terapi.TerraformPlanStatus, apply bool) {
+	if !apply {
+		status.Phase = terapi.TerraformPhasePlanRunning
+		return
+	}
+
+	status.Phase = terapi.TerraformPhaseApplyRunning
+	if terapi.IsConditionTrue(status.Conditions, terapi.TerraformConditionApprovalRequired) {
+		terapi.SetCondition(&status.Conditions, terapi.TerraformCondition{
+			Type:   terapi.TerraformConditionApprovalRequired,
+			Status: corev1.ConditionFalse,
+			Reason: "Approved",
+		})
+	}
+}
+
+// setRejected records rejection of the plan waiting for approval
+func setRejected(status *terapi.TerraformPlanStatus, review *terapi.TerraformPlanReview) {
+	cond := terapi.TerraformCondition{
+		Type:   terapi.TerraformConditionApprovalRequired,
+		Status: corev1.ConditionFalse,
+		Reason: "Rejected",
+	}
+	if review != nil {
+		cond.Message = review.Reason
+	}
+	terapi.SetCondition(&status.Conditions, cond)
+}
+
+// setRunResult updates status according to finished terraform pod, output of
+// plans is kept for review
+func setRunResult(status *terapi.TerraformPlanStatus, pod corev1.Pod) {
+	terminated := terraformTerminated(pod)
+	if terminated == nil {
+		return
+	}
+
+	output := strings.TrimSpace(terminated.Message)
+	summary := outputSummary(output)
+	apply := pod.Annotations[resources.TerraformCommandAnnotation] == "apply"
+
+	if !apply {
+		status.PlanSummary = summary
+		status.PlanOutput = output
+	}
+
+	if terminated.ExitCode != 0 {
+		status.Phase = terapi.TerraformPhasePlanFailed
+		reason := "PlanFailed"
+		if apply {
+			status.Phase = terapi.TerraformPhaseApplyFailed
+			reason = "ApplyFailed"
+		}
+		terapi.SetCondition(&status.Conditions, terapi.TerraformCondition{
+			Type:    terapi.TerraformConditionFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  reason,
+			Message: fmt.Sprintf("terraform exited with code %d", terminated.ExitCode),
+		})
+		return
+	}
+
+	terapi.SetCondition(&status.Conditions, terapi.TerraformCondition{
+		Type:   terapi.TerraformConditionFailed,
+		Status: corev1.ConditionFalse,
+	})
+
+	if !apply {
+		status.Phase = terapi.TerraformPhaseWaitingApproval
+		terapi.SetCondition(&status.Conditions, terapi.TerraformCondition{
+			Type:    terapi.TerraformConditionApprovalRequired,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Planned",
+			Message: summary,
+		})
+		return
+	}
+
+	status.Phase = terapi.TerraformPhaseDone
+	status.AppliedConfigurationSpecHash = status.ConfigurationSpecHash
+	terapi.SetCondition(&status.Conditions, terapi.TerraformCondition{
+		Type:    terapi.TerraformConditionReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Applied",
+		Message: summary,
+	})
+}
+
+// waitingApproval returns how long the plan has been waiting for approval
+// until now, zero if it doesn't wait
+func waitingApproval(status *terapi.TerraformPlanStatus, now time.Time) time.Duration {
+	cond := terapi.GetCondition(status.Conditions, terapi.TerraformConditionApprovalRequired)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.LastTransitionTime.IsZero() {
+		return 0
+	}
+	return now.Sub(cond.LastTransitionTime.Time)
+}
+
+// observeRun records metrics of the finished terraform pod
+func observeRun(pod corev1.Pod) {
+	terminated := terraformTerminated(pod)
+	if terminated == nil {
+		return
+	}
+
+	result := metrics.ResultSucceeded
+	if terminated.ExitCode != 0 {
+		result = metrics.ResultFailed
+	}
+	duration := terminated.FinishedAt.Sub(terminated.StartedAt.Time)
+	metrics.ObserveRun(pod.Annotations[resources.TerraformCommandAnnotation], result, duration)
+}
+
+// outputSummary returns the line of terraform output summarizing changes
+func outputSummary(output string) string {
+	lines := strings.Split(output, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		for _, prefix := range []string{"Plan:", "No changes.", "Apply complete!"} {
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		}
+	}
+	return ""
 }
 
 func podInPhase(pod corev1.Pod, phases ...corev1.PodPhase) bool {
@@ -282,18 +614,24 @@ func podInPhase(pod corev1.Pod, phases ...corev1.PodPhase) bool {
 	return false
 }
 
-func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan) *corev1.Pod {
-	scriptToRun := resources.TerraformPlanScript
+func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan, tfstate *terapi.TerraformState) *corev1.Pod {
+	scriptToRun, command := resources.TerraformPlanScript, "plan"
 	if tfplan.Spec.Approved {
-		scriptToRun = resources.TerraformApplyAutoApproveScript
+		scriptToRun, command = resources.TerraformApplyAutoApproveScript, "apply"
 	}
 
-	return &corev1.Pod{
+	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hashedName(tfplan),
 			Namespace: tfplan.Namespace,
+			Labels: map[string]string{
+				resources.PlanLabel: tfplan.Name,
+				resources.RunLabel:  strconv.FormatInt(tfplan.Status.Run, 10),
+			},
 			Annotations: map[string]string{
 				resources.LinkedTerraformConfigMapAnnotation: hashedName(tfplan),
+				resources.LinkedBackendSecretAnnotation:      hashedName(tfplan),
+				resources.TerraformCommandAnnotation:         command,
 			},
 		},
 		Spec: corev1.PodSpec{
@@ -312,7 +650,7 @@ func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.Terrafo
 					WorkingDir: "/terraform/config",
 					EnvFrom:    tfconfig.Spec.Template.EnvFrom,
 					Env: append(
-						tfconfig.Spec.Template.Env,
+						append(tfconfig.Spec.Template.Env, variablesEnv(tfconfig)...),
 						corev1.EnvVar{
 							Name:  "TF_DATA_DIR",
 							Value: "/tmp/tfdata",
@@ -321,6 +659,16 @@ func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.Terrafo
 							Name:  "TF_IN_AUTOMATION",
 							Value: "1",
 						},
+						corev1.EnvVar{
+							Name:  "KUBETERRA_BACKEND_ADDRESS",
+							Value: resources.HTTPBackendAddress,
+						},
+						corev1.EnvVar{
+							Name:  resources.BackendCAFileEnv,
+							Value: path.Join(resources.BackendCADir, resources.BackendCAKey),
+						},
+						backendSecretEnv(tfplan, resources.BackendUsernameEnv, resources.BackendUsernameKey),
+						backendSecretEnv(tfplan, resources.BackendPasswordEnv, resources.BackendPasswordKey),
 					),
 					Resources: corev1.ResourceRequirements{},
 					VolumeMounts: append(
@@ -329,7 +677,14 @@ func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.Terrafo
 							Name:      "tfconfig",
 							MountPath: "/terraform/config",
 						},
+						corev1.VolumeMount{
+							Name:      "httpbackend-ca",
+							MountPath: resources.BackendCADir,
+							ReadOnly:  true,
+						},
 					),
+					// failed terraform leaves no output tail behind
+					TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
 				},
 				{
 					Name:  "httpbackend",
@@ -341,6 +696,26 @@ func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.Terrafo
 						tfplan.Name,
 						"--namespace",
 						tfplan.Namespace,
+						"--listen",
+						resources.HTTPBackendListen,
+						"--run-id",
+						hashedName(tfplan),
+						"--tls-cert-file",
+						path.Join(resources.BackendTLSDir, corev1.TLSCertKey),
+						"--tls-key-file",
+						path.Join(resources.BackendTLSDir, corev1.TLSPrivateKeyKey),
+					},
+					ReadinessProbe: backendReadinessProbe(),
+					Env: []corev1.EnvVar{
+						backendSecretEnv(tfplan, resources.BackendUsernameEnv, resources.BackendUsernameKey),
+						backendSecretEnv(tfplan, resources.BackendPasswordEnv, resources.BackendPasswordKey),
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "httpbackend-tls",
+							MountPath: resources.BackendTLSDir,
+							ReadOnly:  true,
+						},
 					},
 				},
 			},
@@ -353,63 +728,347 @@ func generatePod(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.Terrafo
 							LocalObjectReference: corev1.LocalObjectReference{
 								Name: hashedName(tfplan),
 							},
+							Items:    configMapItems(tfconfig),
 							Optional: pointer.BoolPtr(false),
 						},
 					},
 				},
+				backendSecretVolume(tfplan, "httpbackend-tls", corev1.TLSCertKey, corev1.TLSPrivateKeyKey),
+				backendSecretVolume(tfplan, "httpbackend-ca", resources.BackendCAKey),
 			),
 			RestartPolicy: corev1.RestartPolicyNever,
 		},
 	}
+
+	if storage := tfstate.Spec.Storage; storage != nil && storage.Filesystem != nil {
+		// volume of the filesystem state storage is only needed by httpbackend
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "tfstate",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: storage.Filesystem.ClaimName,
+				},
+			},
+		})
+
+		backend := &pod.Spec.Containers[1]
+		backend.VolumeMounts = append(backend.VolumeMounts, corev1.VolumeMount{
+			Name:      "tfstate",
+			MountPath: resources.BackendStateDir,
+			SubPath:   storage.Filesystem.SubPath,
+		})
+		backend.Command = append(backend.Command, "--state-dir", resources.BackendStateDir)
+	}
+
+	return pod
 }
 
-func generateConfigMap(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan) *corev1.ConfigMap {
+// generateBackendSecret mints random per-run credentials, that terraform uses
+// to authenticate against httpbackend sidecar, and self-signed certificate
+// httpbackend sidecar serves
+func generateBackendSecret(tfplan *terapi.TerraformPlan) (*corev1.Secret, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+
+	cert, err := httpbackend.GenerateSelfSignedCertificate(
+		strings.Split(resources.HTTPBackendTLSHosts, ","),
+		backendCertificateValidity,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hashedName(tfplan),
+			Namespace: tfplan.Namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			resources.BackendUsernameKey: []byte("terraform"),
+			resources.BackendPasswordKey: []byte(hex.EncodeToString(password)),
+			resources.BackendCAKey:       cert.CA,
+			corev1.TLSCertKey:            cert.Cert,
+			corev1.TLSPrivateKeyKey:      cert.Key,
+		},
+	}, nil
+}
+
+// backendSecretVolume projects given keys of the per-run secret
+func backendSecretVolume(tfplan *terapi.TerraformPlan, name string, keys ...string) corev1.Volume {
+	items := make([]corev1.KeyToPath, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: hashedName(tfplan),
+				Items:      items,
+				Optional:   pointer.BoolPtr(false),
+			},
+		},
+	}
+}
+
+func backendSecretEnv(tfplan *terapi.TerraformPlan, name, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: hashedName(tfplan),
+				},
+				Key: key,
+			},
+		},
+	}
+}
+
+func generateConfigMap(tfconfig *terapi.TerraformConfiguration, tfplan *terapi.TerraformPlan) (*corev1.ConfigMap, error) {
+	data, err := configMapData(tfconfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hashedName(tfplan),
 			Namespace: tfplan.Namespace,
 		},
-		Data: map[string]string{
-			"main.tf":          tfconfig.Spec.Configuration,
-			"terraform.tfvars": tfconfig.Spec.Values,
+		Data: data,
+	}, nil
+}
+
+// configMapData renders terraform working directory files, user defined
+// backends are replaced by generated httpbackend configuration
+func configMapData(tfconfig *terapi.TerraformConfiguration) (map[string]string, error) {
+	data := map[string]string{
+		resources.TerraformHTTPBackendFile: resources.TerraformHTTPBackendConfig,
+	}
+
+	for _, source := range tfconfig.Spec.Sources {
+		if !validSourceName(source.Name) {
+			return nil, fmt.Errorf("source %q must be a relative path inside of working directory", source.Name)
+		}
+
+		key := sourceKey(source.Name)
+		if _, exists := data[key]; exists {
+			return nil, fmt.Errorf("source %q is duplicated or reserved", source.Name)
+		}
+
+		content := source.Content
+		if strings.HasSuffix(source.Name, ".tf") || strings.HasSuffix(source.Name, ".tf.json") {
+			var err error
+			if content, err = stripBackendBlocks(source.Name, content); err != nil {
+				return nil, err
+			}
+		}
+		data[key] = content
+	}
+
+	if tfconfig.Spec.Variables != nil && tfconfig.Spec.Variables.File != "" {
+		if _, exists := data["terraform.tfvars"]; exists {
+			return nil, fmt.Errorf("source %q conflicts with variables file", "terraform.tfvars")
+		}
+		data["terraform.tfvars"] = tfconfig.Spec.Variables.File
+	}
+
+	return data, nil
+}
+
+// validSourceName checks source is named by a clean relative path, sources
+// of local modules are kept in subdirectories
+func validSourceName(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// sourceKey returns key of terraform ConfigMap holding the source, keys can't
+// contain slashes
+func sourceKey(name string) string {
+	return strings.ReplaceAll(name, "/", "__")
+}
+
+// configMapItems maps keys of terraform ConfigMap to paths in working
+// directory, nil if all sources are in its root
+func configMapItems(tfconfig *terapi.TerraformConfiguration) []corev1.KeyToPath {
+	nested := false
+	for _, source := range tfconfig.Spec.Sources {
+		if strings.Contains(source.Name, "/") {
+			nested = true
+		}
+	}
+	if !nested {
+		return nil
+	}
+
+	items := []corev1.KeyToPath{
+		{Key: resources.TerraformHTTPBackendFile, Path: resources.TerraformHTTPBackendFile},
+	}
+	for _, source := range tfconfig.Spec.Sources {
+		items = append(items, corev1.KeyToPath{Key: sourceKey(source.Name), Path: source.Name})
+	}
+	if tfconfig.Spec.Variables != nil && tfconfig.Spec.Variables.File != "" {
+		items = append(items, corev1.KeyToPath{Key: "terraform.tfvars", Path: "terraform.tfvars"})
+	}
+	return items
+}
+
+// variablesEnv translates terraform variables into TF_VAR_<name> environment variables
+func variablesEnv(tfconfig *terapi.TerraformConfiguration) []corev1.EnvVar {
+	if tfconfig.Spec.Variables == nil {
+		return nil
+	}
+
+	env := []corev1.EnvVar{}
+	for _, variable := range tfconfig.Spec.Variables.Values {
+		env = append(env, corev1.EnvVar{
+			Name:      "TF_VAR_" + variable.Name,
+			Value:     variable.Value,
+			ValueFrom: variable.ValueFrom,
+		})
+	}
+	return env
+}
+
+// saveTerraformLog keeps logs of the finished terraform container in a
+// ConfigMap, as they are gone with the pod. Logs that can't be read anymore
+// are skipped.
+func (r *TerraformPlanReconciler) saveTerraformLog(ctx context.Context, tfplan *terapi.TerraformPlan, pod corev1.Pod) error {
+	run, err := strconv.ParseInt(pod.Labels[resources.RunLabel], 10, 64)
+	if err != nil {
+		// pods created by earlier versions are not numbered
+		return nil
+	}
+
+	logCM := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TerraformLogName(tfplan.Name, run),
+			Namespace: tfplan.Namespace,
 		},
 	}
+
+	created, err := findOrCreate(ctx, r.Client, logCM, func() error {
+		logs, err := r.terraformLogs(pod)
+		if err != nil {
+			r.Log.Info("unable to read terraform logs", logging.PlanKey, client.ObjectKey{Namespace: tfplan.Namespace, Name: tfplan.Name}, logging.RunIDKey, pod.Name, "error", err.Error())
+			logs = fmt.Sprintf("logs are not available: %v\n", err)
+		}
+
+		logCM.Labels = map[string]string{
+			resources.PlanLabel: tfplan.Name,
+			resources.RunLabel:  pod.Labels[resources.RunLabel],
+		}
+		logCM.Data = map[string]string{
+			resources.TerraformLogKey: logs,
+		}
+		return ctrl.SetControllerReference(tfplan, logCM, r.Scheme)
+	})
+	if err != nil || !created {
+		return err
+	}
+
+	return r.pruneTerraformLogs(ctx, tfplan)
+}
+
+// terraformLogs returns tail of terraform container logs fitting a ConfigMap
+func (r *TerraformPlanReconciler) terraformLogs(pod corev1.Pod) (string, error) {
+	logsReq := r.PodClient.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: "terraform",
+	})
+
+	terraformLogs, err := logsReq.Stream()
+	if err != nil {
+		return "", err
+	}
+	defer terraformLogs.Close()
+
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, terraformLogs); err != nil {
+		return "", err
+	}
+
+	logs := buf.Bytes()
+	if len(logs) > maxTerraformLogSize {
+		logs = logs[len(logs)-maxTerraformLogSize:]
+	}
+	return string(logs), nil
 }
 
-// func (r *TerraformPlanReconciler) generateTerraformLog(tfplan *terapi.TerraformPlan, pod corev1.Pod) (*corev1.ConfigMap, error) {
-// 	sinceForever := metav1.Unix(1, 0)
-// 	logsReq := r.PodClient.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
-// 		Container: "terraform",
-// 		SinceTime: &sinceForever,
-// 	})
+// pruneTerraformLogs removes logs of the oldest runs above
+// terraformLogHistoryLimit
+func (r *TerraformPlanReconciler) pruneTerraformLogs(ctx context.Context, tfplan *terapi.TerraformPlan) error {
+	var logList corev1.ConfigMapList
+	err := r.List(ctx, &logList,
+		client.InNamespace(tfplan.Namespace),
+		client.MatchingLabels{resources.PlanLabel: tfplan.Name},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, logCM := range oldTerraformLogs(logList.Items, terraformLogHistoryLimit) {
+		cm := logCM
+		if err := ignoreAPIErrors(r.Delete(ctx, &cm), apierrors.IsNotFound, apierrors.IsGone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-// 	terraformLogs, err := logsReq.Stream()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	defer terraformLogs.Close()
+// oldTerraformLogs returns logs of all but the latest limit runs
+func oldTerraformLogs(logs []corev1.ConfigMap, limit int) []corev1.ConfigMap {
+	if len(logs) <= limit {
+		return nil
+	}
 
-// 	var buf bytes.Buffer
-// 	_, err = io.Copy(&buf, terraformLogs)
-// 	if err != nil {
-// 		return nil, err
-// 	}
+	sorted := append([]corev1.ConfigMap{}, logs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return runNumber(sorted[i].Labels) < runNumber(sorted[j].Labels)
+	})
+	return sorted[:len(sorted)-limit]
+}
 
-// 	tflog := &corev1.ConfigMap{
-// 		ObjectMeta: metav1.ObjectMeta{
-// 			Name:      hashedName(tfplan),
-// 			Namespace: tfplan.Namespace,
-// 		},
-// 		Data: map[string]string{
-// 			"logs": buf.String(),
-// 		},
-// 	}
+func runNumber(labels map[string]string) int64 {
+	run, _ := strconv.ParseInt(labels[resources.RunLabel], 10, 64)
+	return run
+}
 
-// 	return tflog, ctrl.SetControllerReference(tfplan, tflog, r.Scheme)
-// }
+// TerraformLogName returns name of the ConfigMap holding logs of the
+// terraform run
+func TerraformLogName(planName string, run int64) string {
+	return fmt.Sprintf("%s-run-%d", planName, run)
+}
 
+// hashedName names resources of the terraform run, apply runs are named
+// differently from the plan they follow. Run number keeps resources of
+// consecutive runs of the same spec apart, while the previous ones are still
+// being deleted.
 func hashedName(tfplan *terapi.TerraformPlan) string {
-	return fmt.Sprintf("%s-%s", tfplan.Name, tfplan.Status.ConfigurationSpecHash)
+	name := fmt.Sprintf("%s-%s-%d", tfplan.Name, tfplan.Status.ConfigurationSpecHash, tfplan.Status.Run)
+	if tfplan.Spec.Approved {
+		name += "-apply"
+	}
+	return name
+}
+
+// backendReadinessProbe checks readiness of httpbackend sidecar, it only
+// listens on localhost, so it's probed from within the container
+func backendReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"wget", "-q", "-O", "/dev/null", "--no-check-certificate", resources.HTTPBackendReadyzAddress},
+			},
+		},
+		PeriodSeconds:  2,
+		TimeoutSeconds: 2,
+	}
 }
 
 func shellCMD(cmdLines ...string) string {
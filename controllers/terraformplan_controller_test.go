@@ -0,0 +1,582 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/notifier"
+	"github.com/loodse/kubeterra/resources"
+)
+
+func TestPlanApproved(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   terapi.TerraformMode
+		spec   terapi.TerraformPlanSpec
+		run    int64
+		want   bool
+		reject bool
+	}{
+		{
+			name: "auto mode",
+			mode: terapi.TerraformModeAuto,
+			want: true,
+		},
+		{
+			name: "not approved",
+			mode: terapi.TerraformModeManual,
+		},
+		{
+			name: "approved without review",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{Approved: true},
+			want: true,
+		},
+		{
+			name: "approved for current hash",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{
+				Approved: true,
+				Review:   &terapi.TerraformPlanReview{Approved: true, ConfigurationSpecHash: "current"},
+			},
+			want: true,
+		},
+		{
+			name: "approved for superseded hash",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{
+				Approved: true,
+				Review:   &terapi.TerraformPlanReview{Approved: true, ConfigurationSpecHash: "old"},
+			},
+		},
+		{
+			name: "approved for current run",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{
+				Approved: true,
+				Review:   &terapi.TerraformPlanReview{Approved: true, ConfigurationSpecHash: "current", Run: 3},
+			},
+			run:  3,
+			want: true,
+		},
+		{
+			name: "approved for earlier run",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{
+				Approved: true,
+				Review:   &terapi.TerraformPlanReview{Approved: true, ConfigurationSpecHash: "current", Run: 3},
+			},
+			run: 5,
+		},
+		{
+			name: "rejected",
+			mode: terapi.TerraformModeManual,
+			spec: terapi.TerraformPlanSpec{
+				Review: &terapi.TerraformPlanReview{ConfigurationSpecHash: "current"},
+			},
+			reject: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfconfig := &terapi.TerraformConfiguration{Spec: terapi.TerraformConfigurationSpec{Mode: tt.mode}}
+			tfplan := &terapi.TerraformPlan{Spec: tt.spec, Status: terapi.TerraformPlanStatus{Run: tt.run}}
+
+			if got := planApproved(tfconfig, tfplan, "current"); got != tt.want {
+				t.Errorf("planApproved() = %v, want %v", got, tt.want)
+			}
+			if got := planRejected(tfplan, "current"); got != tt.reject {
+				t.Errorf("planRejected() = %v, want %v", got, tt.reject)
+			}
+		})
+	}
+}
+
+func TestSetRunResult(t *testing.T) {
+	const planOutput = `+ null_resource.test
+
+Plan: 1 to add, 0 to change, 0 to destroy.`
+
+	tests := []struct {
+		name        string
+		command     string
+		exitCode    int32
+		message     string
+		wantPhase   terapi.TerraformPhase
+		wantCond    terapi.TerraformConditionType
+		wantSummary string
+	}{
+		{
+			name:        "plan succeeded",
+			command:     "plan",
+			message:     planOutput + "\n",
+			wantPhase:   terapi.TerraformPhaseWaitingApproval,
+			wantCond:    terapi.TerraformConditionApprovalRequired,
+			wantSummary: "Plan: 1 to add, 0 to change, 0 to destroy.",
+		},
+		{
+			name:      "plan failed",
+			command:   "plan",
+			exitCode:  1,
+			message:   "Error: invalid resource",
+			wantPhase: terapi.TerraformPhasePlanFailed,
+			wantCond:  terapi.TerraformConditionFailed,
+		},
+		{
+			name:      "apply succeeded",
+			command:   "apply",
+			message:   "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.",
+			wantPhase: terapi.TerraformPhaseDone,
+			wantCond:  terapi.TerraformConditionReady,
+		},
+		{
+			name:      "apply failed",
+			command:   "apply",
+			exitCode:  1,
+			wantPhase: terapi.TerraformPhaseApplyFailed,
+			wantCond:  terapi.TerraformConditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{resources.TerraformCommandAnnotation: tt.command},
+				},
+				Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{
+						{Name: "httpbackend", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+						{Name: "terraform", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: tt.exitCode,
+							Message:  tt.message,
+						}}},
+					},
+				},
+			}
+
+			status := terapi.TerraformPlanStatus{Phase: terapi.TerraformPhasePlanRunning, ConfigurationSpecHash: "abcd"}
+			setRunResult(&status, pod)
+
+			if status.Phase != tt.wantPhase {
+				t.Errorf("phase = %q, want %q", status.Phase, tt.wantPhase)
+			}
+			if !terapi.IsConditionTrue(status.Conditions, tt.wantCond) {
+				t.Errorf("condition %s is expected to be true, got %+v", tt.wantCond, status.Conditions)
+			}
+			if status.PlanSummary != tt.wantSummary {
+				t.Errorf("planSummary = %q, want %q", status.PlanSummary, tt.wantSummary)
+			}
+			applied := status.Phase == terapi.TerraformPhaseDone
+			if (status.AppliedConfigurationSpecHash == status.ConfigurationSpecHash) != applied {
+				t.Errorf("appliedConfigurationSpecHash = %q, expected to be recorded only by successful apply", status.AppliedConfigurationSpecHash)
+			}
+		})
+	}
+}
+
+func TestWaitingApproval(t *testing.T) {
+	now := time.Now()
+	status := terapi.TerraformPlanStatus{}
+	if wait := waitingApproval(&status, now); wait != 0 {
+		t.Errorf("plan without approval condition waits %v", wait)
+	}
+
+	status.Conditions = []terapi.TerraformCondition{{
+		Type:               terapi.TerraformConditionApprovalRequired,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(now.Add(-time.Hour)),
+	}}
+	if wait := waitingApproval(&status, now); wait != time.Hour {
+		t.Errorf("waitingApproval() = %v, want %v", wait, time.Hour)
+	}
+
+	status.Conditions[0].Status = corev1.ConditionFalse
+	if wait := waitingApproval(&status, now); wait != 0 {
+		t.Errorf("approved plan waits %v", wait)
+	}
+}
+
+func TestHashedName(t *testing.T) {
+	tfplan := &terapi.TerraformPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Status:     terapi.TerraformPlanStatus{ConfigurationSpecHash: "abcd"},
+	}
+	planName := hashedName(tfplan)
+
+	tfplan.Spec.Approved = true
+	if applyName := hashedName(tfplan); applyName == planName {
+		t.Errorf("apply run is expected to be named differently from plan %s", planName)
+	}
+
+	tfplan.Spec.Approved = false
+	tfplan.Status.Run++
+	if nextName := hashedName(tfplan); nextName == planName {
+		t.Errorf("next run is expected to be named differently from plan %s", planName)
+	}
+}
+
+func TestOldTerraformLogs(t *testing.T) {
+	logs := []corev1.ConfigMap{}
+	for _, run := range []int64{3, 11, 1, 2, 10} {
+		logs = append(logs, corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   TerraformLogName("test", run),
+				Labels: map[string]string{resources.RunLabel: strconv.FormatInt(run, 10)},
+			},
+		})
+	}
+
+	if old := oldTerraformLogs(logs, 5); len(old) != 0 {
+		t.Errorf("no logs are expected to be removed, got %d", len(old))
+	}
+
+	old := oldTerraformLogs(logs, 2)
+	got := []string{}
+	for _, cm := range old {
+		got = append(got, cm.Name)
+	}
+	want := []string{"test-run-1", "test-run-2", "test-run-3"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("oldTerraformLogs() = %v, want %v", got, want)
+	}
+}
+
+// planReconcileTest drives TerraformPlanReconciler through terraform runs,
+// finishing terraform pods in place of kubelet
+type planReconcileTest struct {
+	*testing.T
+	r   *TerraformPlanReconciler
+	key client.ObjectKey
+
+	// logs serves terraform container logs
+	logs *httptest.Server
+}
+
+func newPlanReconcileTest(t *testing.T, mode terapi.TerraformMode) *planReconcileTest {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terapi.AddToScheme(scheme)
+
+	meta := metav1.ObjectMeta{Name: "test", Namespace: "default"}
+	c := fake.NewFakeClientWithScheme(scheme,
+		&terapi.TerraformConfiguration{
+			ObjectMeta: meta,
+			Spec: terapi.TerraformConfigurationSpec{
+				Mode:        mode,
+				RepeatEvery: &metav1.Duration{Duration: time.Hour},
+				Sources:     []terapi.TerraformSource{{Name: "main.tf", Content: `resource "random_id" "rand" {}`}},
+			},
+		},
+		&terapi.TerraformPlan{
+			ObjectMeta: meta,
+			Status:     terapi.TerraformPlanStatus{Phase: terapi.TerraformPhasePlanScheduled},
+		},
+		&terapi.TerraformState{ObjectMeta: meta},
+	)
+
+	logs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "terraform logs")
+	}))
+	podClient, err := corev1typed.NewForConfig(&rest.Config{Host: logs.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &planReconcileTest{
+		T: t,
+		r: &TerraformPlanReconciler{
+			Client:    c,
+			Log:       log.NullLogger{},
+			Scheme:    scheme,
+			PodClient: podClient,
+			Recorder:  record.NewFakeRecorder(100),
+			Notifier:  notifier.New(c, log.NullLogger{}),
+		},
+		key:  client.ObjectKey{Namespace: meta.Namespace, Name: meta.Name},
+		logs: logs,
+	}
+}
+
+func (tt *planReconcileTest) close() {
+	tt.logs.Close()
+}
+
+func (tt *planReconcileTest) reconcile() {
+	tt.Helper()
+	if _, err := tt.r.Reconcile(ctrl.Request{NamespacedName: tt.key}); err != nil {
+		tt.Fatalf("Reconcile() error = %v", err)
+	}
+}
+
+func (tt *planReconcileTest) plan() *terapi.TerraformPlan {
+	tt.Helper()
+	tfplan := &terapi.TerraformPlan{}
+	if err := tt.r.Get(context.Background(), tt.key, tfplan); err != nil {
+		tt.Fatal(err)
+	}
+	return tfplan
+}
+
+// runPod returns the only terraform pod, which is expected to run command
+func (tt *planReconcileTest) runPod(command string) *corev1.Pod {
+	tt.Helper()
+	pods := corev1.PodList{}
+	if err := tt.r.List(context.Background(), &pods, client.InNamespace(tt.key.Namespace)); err != nil {
+		tt.Fatal(err)
+	}
+	if len(pods.Items) != 1 {
+		tt.Fatalf("%d terraform pods are running, want 1", len(pods.Items))
+	}
+	pod := &pods.Items[0]
+	if got := pod.Annotations[resources.TerraformCommandAnnotation]; got != command {
+		tt.Fatalf("terraform pod runs %s, want %s", got, command)
+	}
+	return pod
+}
+
+// startRun marks the terraform pod running
+func (tt *planReconcileTest) startRun(command string) {
+	tt.Helper()
+	pod := tt.runPod(command)
+	pod.Status.Phase = corev1.PodRunning
+	if err := tt.r.Update(context.Background(), pod); err != nil {
+		tt.Fatal(err)
+	}
+}
+
+// finishRun terminates terraform container of the pod with the output
+func (tt *planReconcileTest) finishRun(command, output string) {
+	tt.Helper()
+	pod := tt.runPod(command)
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "terraform", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: output}}},
+	}
+	if err := tt.r.Update(context.Background(), pod); err != nil {
+		tt.Fatal(err)
+	}
+}
+
+func (tt *planReconcileTest) expectPhase(phase terapi.TerraformPhase) *terapi.TerraformPlan {
+	tt.Helper()
+	tfplan := tt.plan()
+	if tfplan.Status.Phase != phase {
+		tt.Fatalf("phase = %s, want %s", tfplan.Status.Phase, phase)
+	}
+	return tfplan
+}
+
+// approve approves the plan waiting for approval like "kubeterra plan approve"
+func (tt *planReconcileTest) approve() {
+	tt.Helper()
+	tfplan := tt.plan()
+	tfplan.Spec.Approved = true
+	tfplan.Spec.Review = &terapi.TerraformPlanReview{
+		Approved:              true,
+		ConfigurationSpecHash: tfplan.Status.ConfigurationSpecHash,
+		Run:                   tfplan.Status.Run,
+	}
+	if err := tt.r.Update(context.Background(), tfplan); err != nil {
+		tt.Fatal(err)
+	}
+}
+
+// setNextRunAt schedules the next run like TerraformConfiguration controller
+func (tt *planReconcileTest) setNextRunAt(at time.Time) {
+	tt.Helper()
+	tfplan := tt.plan()
+	next := metav1.NewTime(at)
+	tfplan.Spec.NextRunAt = &next
+	if err := tt.r.Update(context.Background(), tfplan); err != nil {
+		tt.Fatal(err)
+	}
+}
+
+func TestReconcileApprovalIsConsumed(t *testing.T) {
+	tt := newPlanReconcileTest(t, terapi.TerraformModeManual)
+	defer tt.close()
+
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhasePlanRunning)
+	tt.finishRun("plan", "Plan: 1 to add, 0 to change, 0 to destroy.")
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhaseWaitingApproval)
+
+	tt.approve()
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhaseApplyRunning)
+	tt.startRun("apply")
+	tt.reconcile()
+	if tt.plan().Spec.Approved {
+		t.Error("approval is expected to be consumed by the apply run")
+	}
+	tt.finishRun("apply", "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.")
+	tt.reconcile()
+	applied := tt.expectPhase(terapi.TerraformPhaseDone)
+	if applied.Status.AppliedConfigurationSpecHash != applied.Status.ConfigurationSpecHash {
+		t.Errorf("applied spec hash = %q, want %q", applied.Status.AppliedConfigurationSpecHash, applied.Status.ConfigurationSpecHash)
+	}
+
+	// scheduled run of the same configuration waits for approval again
+	tt.setNextRunAt(time.Now().Add(-time.Minute))
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhasePlanRunning)
+	tt.setNextRunAt(time.Now().Add(time.Hour))
+	tt.finishRun("plan", "Plan: 0 to add, 1 to change, 0 to destroy.")
+	tt.reconcile()
+	replanned := tt.expectPhase(terapi.TerraformPhaseWaitingApproval)
+	if !replanned.Status.Drifted() {
+		t.Error("changes found by the plan of applied configuration are expected to be reported as drift")
+	}
+}
+
+func TestReconcileApprovedWhilePlanRunning(t *testing.T) {
+	tt := newPlanReconcileTest(t, terapi.TerraformModeManual)
+	defer tt.close()
+
+	tt.reconcile()
+	tt.startRun("plan")
+
+	// approval renames the apply run while the plan is still running
+	tt.approve()
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhasePlanRunning)
+
+	tt.finishRun("plan", "Plan: 1 to add, 0 to change, 0 to destroy.")
+	tt.reconcile()
+	tt.expectPhase(terapi.TerraformPhaseWaitingApproval)
+}
+
+func TestReconcileRunWhilePreviousPodExists(t *testing.T) {
+	tt := newPlanReconcileTest(t, terapi.TerraformModeManual)
+	defer tt.close()
+
+	tt.reconcile()
+	tt.startRun("plan")
+
+	// scheduled run of the same spec starts before the pod of the previous
+	// one is gone
+	tt.setNextRunAt(time.Now().Add(-time.Minute))
+	tt.reconcile()
+	tt.setNextRunAt(time.Now().Add(time.Hour))
+	tfplan := tt.expectPhase(terapi.TerraformPhasePlanRunning)
+	run := strconv.FormatInt(tfplan.Status.Run, 10)
+
+	pods := corev1.PodList{}
+	if err := tt.r.List(context.Background(), &pods, client.InNamespace(tt.key.Namespace)); err != nil {
+		t.Fatal(err)
+	}
+	started := false
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Labels[resources.RunLabel] != run {
+			continue
+		}
+		started = true
+		pod.Status.Phase = corev1.PodRunning
+		if err := tt.r.Update(context.Background(), pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !started {
+		t.Fatalf("no terraform pod is created for run %s", run)
+	}
+
+	// pod of the previous run is deleted
+	tt.reconcile()
+	if pod := tt.runPod("plan"); pod.Labels[resources.RunLabel] != run {
+		t.Errorf("terraform pod of run %s is running, want %s", pod.Labels[resources.RunLabel], run)
+	}
+}
+
+func TestReconcileNotifiesDrift(t *testing.T) {
+	tt := newPlanReconcileTest(t, terapi.TerraformModeManual)
+	defer tt.close()
+
+	events := make(chan terapi.TerraformNotificationEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		notification := notifier.Notification{}
+		if err := json.NewDecoder(req.Body).Decode(&notification); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		events <- notification.Event
+	}))
+	defer webhook.Close()
+
+	err := tt.r.Create(context.Background(), &terapi.TerraformNotification{
+		ObjectMeta: metav1.ObjectMeta{Name: "drift", Namespace: tt.key.Namespace},
+		Spec: terapi.TerraformNotificationSpec{
+			Events:  []terapi.TerraformNotificationEvent{terapi.TerraformNotificationDrifted},
+			Webhook: terapi.TerraformNotificationWebhook{URL: webhook.URL},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt.reconcile()
+	tt.finishRun("plan", "Plan: 1 to add, 0 to change, 0 to destroy.")
+	tt.reconcile()
+	tt.approve()
+	tt.reconcile()
+	tt.finishRun("apply", "Apply complete! Resources: 1 added, 0 changed, 0 destroyed.")
+	tt.reconcile()
+
+	tt.setNextRunAt(time.Now().Add(-time.Minute))
+	tt.reconcile()
+	tt.setNextRunAt(time.Now().Add(time.Hour))
+	tt.finishRun("plan", "Plan: 0 to add, 1 to change, 0 to destroy.")
+	tt.reconcile()
+
+	select {
+	case event := <-events:
+		if event != terapi.TerraformNotificationDrifted {
+			t.Errorf("notified about %s, want %s", event, terapi.TerraformNotificationDrifted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drift is not notified")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s notification", event)
+	default:
+	}
+}
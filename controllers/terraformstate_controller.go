@@ -0,0 +1,261 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/revision"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+const lockedStateRetryInterval = 30 * time.Second
+
+// TerraformStateReconciler reconciles a TerraformState object
+type TerraformStateReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformstates,verbs=*
+// +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformstates/status,verbs=*
+// +kubebuilder:rbac:groups=terraform.kubeterra.io,resources=terraformstaterevisions,verbs=*
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=*
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// SetupWithManager dependency inject controller
+func (r *TerraformStateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&terapi.TerraformState{}).
+		Complete(r)
+}
+
+// Reconcile state
+func (r *TerraformStateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues(logging.StateKey, req.NamespacedName)
+	errLogMsg := logError(log)
+
+	var tfstate terapi.TerraformState
+
+	if err := r.Get(ctx, req.NamespacedName, &tfstate); err != nil {
+		return ctrl.Result{}, errLogMsg(client.IgnoreNotFound(err), "unable to get TerraformState")
+	}
+
+	if lockID, ok := tfstate.Annotations[terapi.ForceUnlockAnnotation]; ok {
+		return r.forceUnlock(ctx, log.WithValues("lock", lockID), &tfstate, lockID)
+	}
+
+	revisionName, ok := tfstate.Annotations[terapi.RollbackToAnnotation]
+	if !ok {
+		return ctrl.Result{}, nil
+	}
+
+	log = log.WithValues("revision", revisionName)
+	errLogMsg = logError(log)
+
+	store, err := statestore.New(ctx, r.Client, &tfstate, "")
+	switch {
+	case errors.Is(err, statestore.ErrStorageUnavailable):
+		return r.dropRollback(ctx, log, &tfstate, err)
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to open state storage")
+	}
+
+	rev := terapi.TerraformStateRevision{}
+	revKey := client.ObjectKey{Name: revisionName, Namespace: tfstate.Namespace}
+	err = r.Get(ctx, revKey, &rev)
+	switch {
+	case apierrors.IsNotFound(err):
+		return r.dropRollback(ctx, log, &tfstate, err)
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformStateRevision")
+	}
+
+	if rev.Spec.StateName != tfstate.Name {
+		return r.dropRollback(ctx, log, &tfstate, fmt.Errorf("revision belongs to TerraformState %q", rev.Spec.StateName))
+	}
+
+	revState, err := revision.Load(ctx, r.Client, &rev)
+	switch {
+	case errors.Is(err, statestore.ErrCorruptedState):
+		return r.dropRollback(ctx, log, &tfstate, err)
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to load revision")
+	}
+
+	if _, err := revision.Rollback(nil, revState); err != nil {
+		return r.dropRollback(ctx, log, &tfstate, err)
+	}
+
+	lockID := "kubeterra-rollback-" + revisionName
+	err = store.Lock(ctx, &statestore.LockInfo{
+		ID:        lockID,
+		Operation: "rollback",
+		Info:      "rollback to TerraformStateRevision " + revisionName,
+		Who:       "kubeterra",
+	})
+	switch {
+	case errors.Is(err, statestore.ErrLocked):
+		log.Info("TerraformState is locked, postpone rollback")
+		return ctrl.Result{RequeueAfter: lockedStateRetryInterval}, nil
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to lock state")
+	}
+
+	log.Info("rollback TerraformState")
+	err = r.restoreRevision(ctx, log, &tfstate, store, lockID, revState)
+	if unlockErr := store.Unlock(ctx, lockID); err == nil {
+		err = unlockErr
+	}
+	if err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to rollback state")
+	}
+
+	// lock has changed TerraformState in the meantime
+	if err := r.Get(ctx, req.NamespacedName, &tfstate); err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformState")
+	}
+
+	delete(tfstate.Annotations, terapi.RollbackToAnnotation)
+	// conflicts are retried on requeue, rollback of the same revision again
+	// is harmless
+	if err := r.Update(ctx, &tfstate); err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to update TerraformState")
+	}
+	r.Recorder.Eventf(&tfstate, corev1.EventTypeNormal, EventRolledBack, "state rolled back to TerraformStateRevision %s", revisionName)
+
+	return ctrl.Result{}, nil
+}
+
+// restoreRevision puts the revision state into the locked store, with the
+// serial bumped above the current one
+func (r *TerraformStateReconciler) restoreRevision(ctx context.Context, log logr.Logger, tfstate *terapi.TerraformState, store statestore.StateStore, lockID string, revState []byte) error {
+	current, err := store.Get(ctx)
+	switch {
+	case errors.Is(err, statestore.ErrEmptyState), errors.Is(err, statestore.ErrCorruptedState):
+		// broken state is exactly what rollback is for
+		log.Info("current state is not recorded", "reason", err.Error())
+		current = nil
+	case err != nil:
+		return err
+	}
+
+	restored, err := revision.Rollback(current, revState)
+	if err != nil {
+		return err
+	}
+
+	// restored state may belong to other lineage than the current one
+	result, err := revision.Push(ctx, r.Client, tfstate, store, lockID, restored, revision.PushOptions{Force: true})
+	if err != nil {
+		return err
+	}
+	if result.HistoryErr != nil {
+		log.Error(result.HistoryErr, "unable to record state revisions")
+	}
+	return nil
+}
+
+// forceUnlock breaks the lock with lockID and records it in TerraformState
+// status. Annotation is removed whether the lock was held or not.
+func (r *TerraformStateReconciler) forceUnlock(ctx context.Context, log logr.Logger, tfstate *terapi.TerraformState, lockID string) (ctrl.Result, error) {
+	errLogMsg := logError(log)
+
+	store, err := statestore.New(ctx, r.Client, tfstate, "")
+	switch {
+	case errors.Is(err, statestore.ErrStorageUnavailable):
+		log.Info("force unlock is not possible", "error", err.Error())
+		return ctrl.Result{}, errLogMsg(r.dropForceUnlock(ctx, tfstate), "unable to update TerraformState")
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to open state storage")
+	}
+
+	holder, err := store.Holder(ctx)
+	if err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to get lock holder")
+	}
+
+	if holder == nil || holder.ID != lockID {
+		log.Info("lock is not held, nothing to unlock")
+		return ctrl.Result{}, errLogMsg(r.dropForceUnlock(ctx, tfstate), "unable to update TerraformState")
+	}
+
+	err = store.Unlock(ctx, lockID)
+	switch {
+	case errors.Is(err, statestore.ErrLocked):
+		// lock was released or broken in the meantime
+		log.Info("lock is not held anymore")
+		return ctrl.Result{}, errLogMsg(r.dropForceUnlock(ctx, tfstate), "unable to update TerraformState")
+	case err != nil:
+		return ctrl.Result{}, errLogMsg(err, "unable to unlock state")
+	}
+
+	by := tfstate.Annotations[terapi.ForceUnlockByAnnotation]
+	log.Info("lock is broken", "who", holder.Who, "by", by)
+	msg := fmt.Sprintf("lock %s held by %s is broken", lockID, holder.Who)
+	if by != "" {
+		msg += " by " + by
+	}
+	r.Recorder.Event(tfstate, corev1.EventTypeWarning, EventForceUnlocked, msg)
+
+	// unlock has changed TerraformState in the meantime
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tfstate.Namespace, Name: tfstate.Name}, tfstate); err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to get TerraformState")
+	}
+
+	tfstate.Status.LastForceUnlock = &terapi.TerraformStateForceUnlock{
+		Lock: *statestore.LockToStatus(holder),
+		By:   by,
+		Time: metav1.Now(),
+	}
+	if err := r.Status().Update(ctx, tfstate); err != nil {
+		return ctrl.Result{}, errLogMsg(err, "unable to update TerraformState.Status")
+	}
+
+	return ctrl.Result{}, errLogMsg(r.dropForceUnlock(ctx, tfstate), "unable to update TerraformState")
+}
+
+func (r *TerraformStateReconciler) dropForceUnlock(ctx context.Context, tfstate *terapi.TerraformState) error {
+	delete(tfstate.Annotations, terapi.ForceUnlockAnnotation)
+	delete(tfstate.Annotations, terapi.ForceUnlockByAnnotation)
+	return r.Update(ctx, tfstate)
+}
+
+// dropRollback removes rollback request that can't be fulfilled, retrying
+// won't help
+func (r *TerraformStateReconciler) dropRollback(ctx context.Context, log logr.Logger, tfstate *terapi.TerraformState, reason error) (ctrl.Result, error) {
+	log.Info("rollback is not possible", "error", reason.Error())
+	delete(tfstate.Annotations, terapi.RollbackToAnnotation)
+	return ctrl.Result{}, logError(log)(r.Update(ctx, tfstate), "unable to update TerraformState")
+}
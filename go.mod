@@ -5,9 +5,14 @@ go 1.13
 require (
 	github.com/go-logr/logr v0.1.0
 	github.com/hashicorp/go-uuid v1.0.1
+	github.com/hashicorp/hcl/v2 v2.1.0
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
+	github.com/prometheus/client_golang v0.9.0
 	github.com/spf13/cobra v0.0.3
+	github.com/spf13/pflag v1.0.3
+	github.com/zclconf/go-cty v1.1.0
+	go.uber.org/zap v1.9.1
 	k8s.io/api v0.0.0-20190409021203-6e4e0e4f393b
 	k8s.io/apimachinery v0.0.0-20190404173353-6a84e37a896d
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
@@ -15,4 +20,5 @@ require (
 	k8s.io/utils v0.0.0-20190506122338-8fab8cb257d5
 	sigs.k8s.io/controller-runtime v0.2.1
 	sigs.k8s.io/controller-tools v0.2.1
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -57,6 +57,7 @@ var (
 		"_output":                               struct{}{},
 		".git":                                  struct{}{},
 		"api/v1alpha1/zz_generated.deepcopy.go": struct{}{},
+		"api/v1beta1/zz_generated.deepcopy.go":  struct{}{},
 		"bin":                                   struct{}{},
 		"hack/boilerplate/boilerplate.go":       struct{}{},
 		"hack/boilerplate/test":                 struct{}{},
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package healthz serves /healthz and /readyz probes of manager and backend.
+// Every probe runs its named checks and fails with 500 if any of them fails,
+// listing results of the checks like the Kubernetes API server does.
+package healthz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// HealthzPath is where liveness probe is served
+	HealthzPath = "/healthz"
+
+	// ReadyzPath is where readiness probe is served
+	ReadyzPath = "/readyz"
+)
+
+// Checker returns why the process isn't healthy or ready
+type Checker func(req *http.Request) error
+
+// Ping checks nothing, the process is healthy as long as it serves requests
+func Ping(*http.Request) error {
+	return nil
+}
+
+// Handler runs checks of the probe
+type Handler struct {
+	names  []string
+	checks map[string]Checker
+}
+
+// AddCheck adds named check to the probe, checks run in order they're added
+func (h *Handler) AddCheck(name string, check Checker) {
+	if h.checks == nil {
+		h.checks = map[string]Checker{}
+	}
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// ServeHTTP responds "ok" when every check passes, results of every check are
+// listed when any of them fails or verbose output is requested. Checks named by
+// exclude query parameters are skipped.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	excluded := map[string]bool{}
+	for _, name := range query["exclude"] {
+		excluded[name] = true
+	}
+
+	out := bytes.Buffer{}
+	failed := false
+	for _, name := range h.names {
+		if excluded[name] {
+			fmt.Fprintf(&out, "[+]%s excluded: ok\n", name)
+			continue
+		}
+		if err := h.checks[name](r); err != nil {
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: %v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(&out, "[+]%s ok\n", name)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%s%s check failed\n", out.String(), r.URL.Path)
+		return
+	}
+	if _, verbose := query["verbose"]; verbose {
+		fmt.Fprintf(w, "%s%s check passed\n", out.String(), r.URL.Path)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+// Probes are liveness and readiness probes of the process
+type Probes struct {
+	Healthz Handler
+	Readyz  Handler
+}
+
+// NewProbes returns probes with ping check added to both
+func NewProbes() *Probes {
+	p := &Probes{}
+	p.Healthz.AddCheck("ping", Ping)
+	p.Readyz.AddCheck("ping", Ping)
+	return p
+}
+
+// Register serves probes at HealthzPath and ReadyzPath of the mux
+func (p *Probes) Register(mux *http.ServeMux) {
+	mux.Handle(HealthzPath, &p.Healthz)
+	mux.Handle(ReadyzPath, &p.Readyz)
+}
+
+// Flag is a check failing until it's set
+type Flag struct {
+	set int32
+	err error
+}
+
+// NewFlag returns unset flag failing with the reason
+func NewFlag(reason string) *Flag {
+	return &Flag{err: errors.New(reason)}
+}
+
+// Set makes the check pass
+func (f *Flag) Set() {
+	atomic.StoreInt32(&f.set, 1)
+}
+
+// Check fails until the flag is set
+func (f *Flag) Check(*http.Request) error {
+	if atomic.LoadInt32(&f.set) == 0 {
+		return f.err
+	}
+	return nil
+}
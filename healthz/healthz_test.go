@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbes(t *testing.T) {
+	synced := NewFlag("caches are not synced")
+
+	probes := NewProbes()
+	probes.Readyz.AddCheck("cache-sync", synced.Check)
+	mux := http.NewServeMux()
+	probes.Register(mux)
+
+	tests := []struct {
+		name     string
+		path     string
+		synced   bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "healthy",
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "not ready",
+			path:     "/readyz",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[+]ping ok\n[-]cache-sync failed: caches are not synced\n/readyz check failed\n",
+		},
+		{
+			name:     "not ready check excluded",
+			path:     "/readyz?exclude=cache-sync&verbose",
+			wantCode: http.StatusOK,
+			wantBody: "[+]ping ok\n[+]cache-sync excluded: ok\n/readyz check passed\n",
+		},
+		{
+			name:     "ready",
+			path:     "/readyz",
+			synced:   true,
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name:     "ready verbose",
+			path:     "/readyz?verbose",
+			synced:   true,
+			wantCode: http.StatusOK,
+			wantBody: "[+]ping ok\n[+]cache-sync ok\n/readyz check passed\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.synced {
+				synced.Set()
+			}
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/logging"
+)
+
+const (
+	// StatesPath is where central backend serves TerraformStates, as
+	// /states/{namespace}/{name}
+	StatesPath = "/states/"
+
+	// DefaultCentralIdleTimeout is how long central backend keeps handlers of
+	// states served without a lock by default
+	DefaultCentralIdleTimeout = time.Hour
+
+	// handlersSweepInterval is how often idle handlers are looked for
+	handlersSweepInterval = time.Minute
+)
+
+// centralHandler serves every TerraformState. Callers are authenticated with
+// their Kubernetes bearer tokens and authorized to access the TerraformState
+// they request.
+type centralHandler struct {
+	client.Client
+	log      logr.Logger
+	recorder record.EventRecorder
+	ctx      context.Context
+
+	lockMethod   string
+	unlockMethod string
+
+	// idleTimeout is how long handlers of states without a lock are kept
+	// after their last request, handlers renewing a lock are kept until
+	// unlock
+	idleTimeout time.Duration
+
+	// handlers of states served recently, they keep renewing locks between
+	// requests
+	mu        sync.Mutex
+	handlers  map[client.ObjectKey]*centralEntry
+	lastSweep time.Time
+}
+
+// centralEntry is a handler of the single state along with the time of its
+// last request
+type centralEntry struct {
+	handler  *backendHandler
+	lastUsed time.Time
+}
+
+func (h *centralHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := h.serveState(w, r); err != nil {
+		apiErr := extractAPIError(err)
+		http.Error(w, apiErr.msg, apiErr.code)
+	}
+}
+
+func (h *centralHandler) serveState(w http.ResponseWriter, r *http.Request) error {
+	key, ok := parseStatePath(r.URL.Path)
+	if !ok {
+		return &httpAPIError{code: http.StatusNotFound, msg: "404 page not found"}
+	}
+
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kubeterra"`)
+		return &httpAPIError{code: http.StatusUnauthorized, msg: "unauthorized"}
+	}
+
+	user, err := h.authenticate(r.Context(), token)
+	if err != nil {
+		return err
+	}
+
+	if err := h.authorize(r.Context(), user, stateVerb(r.Method), key); err != nil {
+		return err
+	}
+
+	h.stateHandler(key).ServeHTTP(w, r)
+	return nil
+}
+
+// authenticate returns user the token belongs to
+func (h *centralHandler) authenticate(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := h.Create(ctx, review); err != nil {
+		return nil, err
+	}
+
+	if !review.Status.Authenticated {
+		h.log.V(1).Info("token is not authenticated", "reason", review.Status.Error)
+		return nil, &httpAPIError{code: http.StatusUnauthorized, msg: "unauthorized"}
+	}
+
+	return &review.Status.User, nil
+}
+
+// authorize checks the user is allowed to access the TerraformState with verb
+func (h *centralHandler) authorize(ctx context.Context, user *authenticationv1.UserInfo, verb string, key client.ObjectKey) error {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for name, value := range user.Extra {
+		extra[name] = authorizationv1.ExtraValue(value)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+				Verb:      verb,
+				Group:     terraformv1beta1.GroupVersion.Group,
+				Resource:  "terraformstates",
+			},
+		},
+	}
+	if err := h.Create(ctx, review); err != nil {
+		return err
+	}
+
+	if !review.Status.Allowed {
+		return &httpAPIError{
+			code: http.StatusForbidden,
+			msg:  fmt.Sprintf("%s is not allowed to %s terraformstate %s", user.Username, verb, key),
+		}
+	}
+	return nil
+}
+
+// stateHandler returns handler of the single TerraformState
+func (h *centralHandler) stateHandler(key client.ObjectKey) *backendHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.evictIdle(now)
+
+	if entry, ok := h.handlers[key]; ok {
+		entry.lastUsed = now
+		return entry.handler
+	}
+
+	// callers are authenticated already, filesystem storages are never
+	// mounted into central backend
+	handler := &backendHandler{
+		Client:    h.Client,
+		log:       h.log.WithValues(logging.StateKey, key),
+		recorder:  h.recorder,
+		ctx:       h.ctx,
+		name:      key.Name,
+		namespace: key.Namespace,
+
+		lockMethod:   h.lockMethod,
+		unlockMethod: h.unlockMethod,
+	}
+	h.handlers[key] = &centralEntry{handler: handler, lastUsed: now}
+	return handler
+}
+
+// evictIdle drops handlers idle for longer than idleTimeout. Handlers renewing
+// a lock are kept, terraform may hold it through a long apply without any
+// request. mu has to be held.
+func (h *centralHandler) evictIdle(now time.Time) {
+	if now.Sub(h.lastSweep) < handlersSweepInterval {
+		return
+	}
+	h.lastSweep = now
+
+	for key, entry := range h.handlers {
+		if now.Sub(entry.lastUsed) > h.idleTimeout && !entry.handler.renewing() {
+			delete(h.handlers, key)
+		}
+	}
+}
+
+// parseStatePath returns TerraformState addressed by /states/{namespace}/{name}
+func parseStatePath(path string) (client.ObjectKey, bool) {
+	if !strings.HasPrefix(path, StatesPath) {
+		return client.ObjectKey{}, false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, StatesPath), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return client.ObjectKey{}, false
+	}
+	return client.ObjectKey{Namespace: parts[0], Name: parts[1]}, true
+}
+
+// bearerToken returns token of the request. Terraform http backend only
+// supports basic auth, so the token is also taken from the password.
+func bearerToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	return ""
+}
+
+// stateVerb returns verb of TerraformState access the method needs
+func stateVerb(method string) string {
+	switch method {
+	case "GET":
+		return "get"
+	case "DELETE":
+		return "delete"
+	}
+	return "update"
+}
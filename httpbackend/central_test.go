@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reviewingClient answers token and access reviews the way API server would
+type reviewingClient struct {
+	client.Client
+
+	// users by their tokens
+	users map[string]string
+
+	// access allowed to users, as "user verb namespace/name"
+	allowed map[string]bool
+}
+
+func (c *reviewingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		username, ok := c.users[review.Spec.Token]
+		review.Status.Authenticated = ok
+		review.Status.User.Username = username
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		attrs := review.Spec.ResourceAttributes
+		access := review.Spec.User + " " + attrs.Verb + " " + attrs.Namespace + "/" + attrs.Name
+		review.Status.Allowed = attrs.Group == "terraform.kubeterra.io" && attrs.Resource == "terraformstates" && c.allowed[access]
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestCentralHandler(t *testing.T) {
+	statePath := StatesPath + testNamespace + "/" + testName
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		bearer   bool
+		wantCode int
+	}{
+		{
+			name:     "pull",
+			method:   "GET",
+			path:     statePath,
+			token:    "alice-token",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "pull with bearer token",
+			method:   "GET",
+			path:     statePath,
+			token:    "alice-token",
+			bearer:   true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "lock",
+			method:   "LOCK",
+			path:     statePath,
+			body:     `{"ID":"first"}`,
+			token:    "alice-token",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "lock without update permission",
+			method:   "LOCK",
+			path:     statePath,
+			body:     `{"ID":"first"}`,
+			token:    "bob-token",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "pull of other state",
+			method:   "GET",
+			path:     StatesPath + testNamespace + "/other",
+			token:    "bob-token",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "no token",
+			method:   "GET",
+			path:     statePath,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown token",
+			method:   "GET",
+			path:     statePath,
+			token:    "stolen",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "state not addressed",
+			method:   "GET",
+			path:     StatesPath + testNamespace,
+			token:    "alice-token",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &centralHandler{
+				Client: &reviewingClient{
+					Client: newTestHandler().Client,
+					users:  map[string]string{"alice-token": "alice", "bob-token": "bob"},
+					allowed: map[string]bool{
+						"alice get " + testNamespace + "/" + testName:    true,
+						"alice update " + testNamespace + "/" + testName: true,
+						"bob get " + testNamespace + "/" + testName:      true,
+					},
+				},
+				log:         log.NullLogger{},
+				recorder:    record.NewFakeRecorder(100),
+				ctx:         context.Background(),
+				handlers:    map[client.ObjectKey]*centralEntry{},
+				idleTimeout: DefaultCentralIdleTimeout,
+			}
+			defer func() {
+				for _, entry := range h.handlers {
+					entry.handler.stopRenewal()
+				}
+			}()
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			switch {
+			case tt.token == "":
+			case tt.bearer:
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			default:
+				req.SetBasicAuth("terraform", tt.token)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d, body: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCentralHandlerEvictsIdle(t *testing.T) {
+	h := &centralHandler{
+		Client:      newTestHandler().Client,
+		log:         log.NullLogger{},
+		recorder:    record.NewFakeRecorder(100),
+		ctx:         context.Background(),
+		handlers:    map[client.ObjectKey]*centralEntry{},
+		idleTimeout: time.Hour,
+	}
+
+	idle := client.ObjectKey{Namespace: testNamespace, Name: "idle"}
+	recent := client.ObjectKey{Namespace: testNamespace, Name: "recent"}
+	locked := client.ObjectKey{Namespace: testNamespace, Name: "locked"}
+	h.stateHandler(idle)
+	h.stateHandler(recent)
+	h.stateHandler(locked)
+	h.handlers[idle].lastUsed = time.Now().Add(-2 * time.Hour)
+	h.lastSweep = time.Time{}
+
+	// terraform holds the lock through a long apply without any request
+	lockedHandler := h.handlers[locked].handler
+	lockedHandler.renewCtx, lockedHandler.cancelRenew = context.WithCancel(context.Background())
+	defer lockedHandler.stopRenewal()
+	h.handlers[locked].lastUsed = time.Now().Add(-2 * time.Hour)
+
+	h.stateHandler(client.ObjectKey{Namespace: testNamespace, Name: testName})
+
+	if _, ok := h.handlers[idle]; ok {
+		t.Error("idle handler is expected to be evicted")
+	}
+	if _, ok := h.handlers[recent]; !ok {
+		t.Error("recently used handler is expected to be kept")
+	}
+	if _, ok := h.handlers[locked]; !ok {
+		t.Error("handler renewing the lock is expected to be kept")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of Events recorded on TerraformState. Events are aggregated only by
+// the recorder that sent them and sidecar backend of every run has its own, so
+// locks taken by every run aren't recorded. Messages don't carry serials for
+// pushes to be aggregated by the central backend.
+const (
+	EventStatePushed  = "StatePushed"
+	EventForcePushed  = "ForcePushed"
+	EventStateDeleted = "StateDeleted"
+
+	// EventRevisionFailed and EventForcePushKept are warnings of pushed state
+	// follow-ups that failed
+	EventRevisionFailed = "RevisionFailed"
+	EventForcePushKept  = "ForcePushKept"
+)
+
+// newEventRecorder returns recorder sending Events to the API server
+func newEventRecorder(cfg *rest.Config, scheme *runtime.Scheme) (record.EventRecorder, error) {
+	coreClient, err := corev1typed.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1typed.EventSinkImpl{Interface: coreClient.Events("")})
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "kubeterra-backend"}), nil
+}
@@ -17,59 +17,144 @@ limitations under the License.
 package httpbackend
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	terraformv1alpha1 "github.com/loodse/kubeterra/api/v1alpha1"
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/revision"
+	"github.com/loodse/kubeterra/statestore"
 )
 
 type backendHandler struct {
 	client.Client
 	log       logr.Logger
-	ctx       context.Context
+	recorder  record.EventRecorder
 	name      string
 	namespace string
+	username  string
+	password  string
+	runID     string
+	stateDir  string
+
+	// lockMethod and unlockMethod are HTTP methods terraform locks and
+	// unlocks the state with, LOCK and UNLOCK when empty
+	lockMethod   string
+	unlockMethod string
+
+	// ctx is context of the server, requests are served with their own
+	// context
+	ctx context.Context
+
+	// renewal of the lock held by terraform, cancelled on unlock
+	renewMu     sync.Mutex
+	renewCtx    context.Context
+	cancelRenew context.CancelFunc
 }
 
 func (h *backendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	var err error
+	if h.authorized(r) {
+		err = h.serveState(w, r)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kubeterra"`)
+		err = &httpAPIError{code: http.StatusUnauthorized, msg: "unauthorized"}
+	}
+
+	if err != nil {
+		apiErr := extractAPIError(err)
+		http.Error(w, apiErr.msg, apiErr.code)
+	}
+}
+
+// authorized checks request basic auth credentials in constant time
+func (h *backendHandler) authorized(r *http.Request) bool {
+	if h.username == "" && h.password == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// compare digests to not leak length of credentials
+	usernameMatch := subtle.ConstantTimeCompare(digest(username), digest(h.username))
+	passwordMatch := subtle.ConstantTimeCompare(digest(password), digest(h.password))
+	return usernameMatch&passwordMatch == 1
+}
+
+func digest(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func (h *backendHandler) serveState(w http.ResponseWriter, r *http.Request) error {
+	lockMethod, unlockMethod := h.lockMethod, h.unlockMethod
+	if lockMethod == "" {
+		lockMethod = DefaultLockMethod
+	}
+	if unlockMethod == "" {
+		unlockMethod = DefaultUnlockMethod
+	}
+
 	var err error
 	switch r.Method {
 	case "GET":
 		err = h.pullState(w, r)
 	case "POST":
 		err = h.pushState(w, r)
-	case "LOCK":
+	case "DELETE":
+		err = h.deleteState(w, r)
+	case lockMethod:
 		err = h.lockState(w, r)
-	case "UNLOCK":
+	case unlockMethod:
 		err = h.unlockState(w, r)
 	default:
 		err = &httpAPIError{code: http.StatusNotFound, msg: "404 page not found"}
 	}
+	return err
+}
+
+func (h *backendHandler) pullState(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
 
+	_, store, err := h.stateStore(ctx)
 	if err != nil {
-		apiErr := extractAPIError(err)
-		http.Error(w, apiErr.msg, apiErr.code)
+		return err
 	}
-}
 
-func (h *backendHandler) pullState(w http.ResponseWriter, _ *http.Request) error { //nolint:interfacer
-	state, err := h.getState()
+	raw, err := store.Get(ctx)
+	if errors.Is(err, statestore.ErrEmptyState) {
+		// terraform treats no content as no state yet
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	_, err = fmt.Fprintf(w, "%s", state.Spec.State.Raw)
+	_, err = w.Write(raw)
 	return err
 }
 
@@ -86,33 +171,86 @@ func (h *backendHandler) pushState(w http.ResponseWriter, r *http.Request) error
 	}
 	defer r.Body.Close()
 
-	incomingState := stateModel{}
-	err = json.Unmarshal(buf, &incomingState)
-	if err != nil {
+	if err := verifyContentMD5(r.Header.Get("Content-MD5"), buf); err != nil {
 		return err
 	}
 
-	state, err := h.getState()
+	ctx := r.Context()
+	state, store, err := h.stateStore(ctx)
 	if err != nil {
 		return err
 	}
 
-	existingState := stateModel{}
-	if err := json.Unmarshal(buf, &existingState); err != nil {
+	result, err := revision.Push(ctx, h.Client, state, store, lockID, buf, revision.PushOptions{
+		RunID: h.runID,
+		Force: state.Annotations[terraformv1beta1.ForcePushAnnotation] == "true",
+	})
+	switch {
+	case errors.Is(err, revision.ErrInvalidState):
+		return &httpAPIError{code: http.StatusBadRequest, msg: err.Error()}
+	case errors.Is(err, statestore.ErrIntegrity):
+		return &httpAPIError{code: http.StatusConflict, msg: err.Error()}
+	case err != nil:
 		return err
 	}
 
-	if incomingState.Lineage != existingState.Lineage {
-		return &httpAPIError{code: http.StatusBadRequest, msg: "alien state lineage"}
+	if result.Forced != nil {
+		h.log.Info("forced state push", logging.SerialKey, result.Serial, "reason", result.Forced.Error())
+		h.recorder.Eventf(state, corev1.EventTypeWarning, EventForcePushed, "state is force pushed: %v", result.Forced)
 	}
+	h.log.Info("state pushed", logging.SerialKey, result.Serial)
+	h.recorder.Event(state, corev1.EventTypeNormal, EventStatePushed, "state is pushed")
 
-	if state.Status.LockID != lockID {
-		return &httpAPIError{code: http.StatusLocked, msg: "locked"}
+	// the state is already pushed, terraform isn't failed for the follow-ups
+	if result.HistoryErr != nil {
+		h.log.Error(result.HistoryErr, "unable to record state revisions", logging.SerialKey, result.Serial)
+		h.recorder.Eventf(state, corev1.EventTypeWarning, EventRevisionFailed, "state revision is not recorded: %v", result.HistoryErr)
+	}
+	if result.Forced != nil {
+		if err := h.dropAnnotation(ctx, terraformv1beta1.ForcePushAnnotation); err != nil {
+			h.log.Error(err, "unable to remove force push annotation")
+			h.recorder.Eventf(state, corev1.EventTypeWarning, EventForcePushKept, "%s annotation is not removed, following pushes are not checked: %v", terraformv1beta1.ForcePushAnnotation, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteState removes terraform state, which has to be explicitly allowed
+// and not locked. Revisions of the state are kept.
+func (h *backendHandler) deleteState(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	state, store, err := h.stateStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	if state.Annotations[terraformv1beta1.AllowDeleteAnnotation] != "true" {
+		return &httpAPIError{
+			code: http.StatusForbidden,
+			msg:  fmt.Sprintf("state deletion is not allowed, annotate TerraformState with %s: \"true\"", terraformv1beta1.AllowDeleteAnnotation),
+		}
 	}
 
-	state.Spec.State.Raw = buf
-	// TODO: try to figure out retryable errors and retry
-	if err := h.Update(h.ctx, state); err != nil {
+	holder, err := store.Holder(ctx)
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		return &statestore.LockedError{Holder: holder}
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return store.Delete(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.log.Info("state deleted")
+	h.recorder.Event(state, corev1.EventTypeNormal, EventStateDeleted, "state is deleted")
+	if err := h.dropAnnotation(ctx, terraformv1beta1.AllowDeleteAnnotation); err != nil {
 		return err
 	}
 
@@ -120,83 +258,204 @@ func (h *backendHandler) pushState(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// dropAnnotation removes one-off annotation of TerraformState once it's used
+func (h *backendHandler) dropAnnotation(ctx context.Context, annotation string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		state, err := h.getState(ctx)
+		if err != nil {
+			return err
+		}
+
+		delete(state.Annotations, annotation)
+		return h.Update(ctx, state)
+	})
+}
+
+// verifyContentMD5 checks body against base64 encoded MD5 digest terraform
+// sends along with the state, empty header is not verified
+func verifyContentMD5(header string, body []byte) error {
+	if header == "" {
+		return nil
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return &httpAPIError{code: http.StatusBadRequest, msg: "malformed Content-MD5 header"}
+	}
+
+	sum := md5.Sum(body) //nolint:gosec
+	if !bytes.Equal(expected, sum[:]) {
+		return &httpAPIError{code: http.StatusBadRequest, msg: "Content-MD5 mismatch, state is corrupted"}
+	}
+
+	return nil
+}
+
 func (h *backendHandler) lockState(w http.ResponseWriter, r *http.Request) error {
-	li := lockInfo{}
+	li := statestore.LockInfo{}
 	err := json.NewDecoder(r.Body).Decode(&li)
 	if err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
-	state, err := h.getState()
-	if err != nil {
-		return err
+	if li.ID == "" {
+		return &httpAPIError{code: http.StatusBadRequest, msg: "unknown lock ID"}
 	}
 
-	switch {
-	case li.ID == "":
-		return &httpAPIError{code: http.StatusBadRequest, msg: "unknown lock ID"}
-	case state.Status.LockID != "":
-		return &httpAPIError{code: http.StatusLocked, msg: "locked"}
+	ctx := r.Context()
+	_, store, err := h.stateStore(ctx)
+	if err != nil {
+		return err
 	}
 
-	now := metav1.Now()
-	state.Status.LockID = li.ID
-	state.Status.LockedSince = &now
-	if err := h.Status().Update(h.ctx, state); err != nil {
+	// lost race for the lock is reported as locked, conflicts only come from
+	// unrelated updates
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return store.Lock(ctx, &li)
+	})
+	if err != nil {
 		return err
 	}
+	h.startRenewal(store, li.ID)
 
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
 func (h *backendHandler) unlockState(w http.ResponseWriter, r *http.Request) error {
-	li := lockInfo{}
+	li := statestore.LockInfo{}
 	err := json.NewDecoder(r.Body).Decode(&li)
 	if err != nil {
 		return err
 	}
 	defer r.Body.Close()
 
-	state, err := h.getState()
-	if err != nil {
-		return err
+	if li.ID == "" {
+		return &httpAPIError{code: http.StatusBadRequest, msg: "unknown lock ID"}
 	}
 
-	switch {
-	case li.ID == "":
-		return &httpAPIError{code: http.StatusBadRequest, msg: "unknown lock ID"}
-	case li.ID != state.Status.LockID:
-		return &httpAPIError{code: http.StatusLocked, msg: "locked"}
+	ctx := r.Context()
+	_, store, err := h.stateStore(ctx)
+	if err != nil {
+		return err
 	}
 
-	state.Status.LockID = ""
-	state.Status.LockedSince = nil
-	// TODO: try to figure out retryable errors and retry
-	if err := h.Status().Update(h.ctx, state); err != nil {
+	// Unlock re-validates the lock on every attempt
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return store.Unlock(ctx, li.ID)
+	})
+	if err != nil {
 		return err
 	}
+	h.stopRenewal()
+
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
-func (h *backendHandler) getState() (*terraformv1alpha1.TerraformState, error) {
-	state := &terraformv1alpha1.TerraformState{}
-	stateKey := client.ObjectKey{Name: h.name, Namespace: h.namespace}
+// startRenewal keeps renewing the lock while terraform holds it, so the lock
+// of terraform which is gone along with this sidecar expires
+func (h *backendHandler) startRenewal(store statestore.StateStore, lockID string) {
+	renewer, ok := statestore.AsLockRenewer(store)
+	if !ok {
+		return
+	}
 
-	if err := h.Get(h.ctx, stateKey, state); err != nil {
-		return nil, err
+	h.stopRenewal()
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	h.renewMu.Lock()
+	h.renewCtx = ctx
+	h.cancelRenew = cancel
+	h.renewMu.Unlock()
+
+	go func() {
+		// lost lock isn't renewed anymore
+		defer cancel()
+
+		ticker := time.NewTicker(renewer.RenewInterval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+				return renewer.RenewLock(ctx, lockID)
+			})
+			switch {
+			case errors.Is(err, statestore.ErrLocked):
+				h.log.Info("lock is lost, renewal stopped", "lock-id", lockID)
+				return
+			case err != nil && ctx.Err() == nil:
+				h.log.Error(err, "unable to renew the lock", "lock-id", lockID)
+			}
+		}
+	}()
+}
+
+func (h *backendHandler) stopRenewal() {
+	h.renewMu.Lock()
+	defer h.renewMu.Unlock()
+
+	if h.cancelRenew != nil {
+		h.cancelRenew()
+		h.renewCtx = nil
+		h.cancelRenew = nil
+	}
+}
+
+// renewing tells if the lock held by terraform is being renewed
+func (h *backendHandler) renewing() bool {
+	h.renewMu.Lock()
+	defer h.renewMu.Unlock()
+
+	return h.renewCtx != nil && h.renewCtx.Err() == nil
+}
+
+// stateStore returns TerraformState along with the store of its terraform state
+func (h *backendHandler) stateStore(ctx context.Context) (*terraformv1beta1.TerraformState, statestore.StateStore, error) {
+	state, err := h.getState(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := statestore.New(ctx, h.Client, state, h.stateDir)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if state.Spec.State == nil {
-		return nil, errors.New("state is empty")
+	return state, store, nil
+}
+
+func (h *backendHandler) getState(ctx context.Context) (*terraformv1beta1.TerraformState, error) {
+	state := &terraformv1beta1.TerraformState{}
+	stateKey := client.ObjectKey{Name: h.name, Namespace: h.namespace}
+
+	if err := h.Get(ctx, stateKey, state); err != nil {
+		return nil, err
 	}
 
 	return state, nil
 }
 
 func extractAPIError(err error) *httpAPIError {
+	lockedErr := &statestore.LockedError{}
+	if errors.As(err, &lockedErr) && lockedErr.Holder != nil {
+		// terraform shows the holder of the lock to the user
+		if holder, jsonErr := json.Marshal(lockedErr.Holder); jsonErr == nil {
+			return &httpAPIError{code: http.StatusLocked, msg: string(holder)}
+		}
+	}
+
+	if errors.Is(err, statestore.ErrLocked) {
+		return &httpAPIError{code: http.StatusLocked, msg: "locked"}
+	}
+
 	apiErr := &httpAPIError{}
 
 	switch errAPI := err.(type) {
@@ -217,22 +476,6 @@ func extractAPIError(err error) *httpAPIError {
 	return apiErr
 }
 
-type lockInfo struct {
-	ID        string
-	Operation string
-	Info      string
-	Who       string
-	Version   string
-	Created   string
-	Path      string
-}
-
-type stateModel struct {
-	Version int    `json:"version"`
-	Lineage string `json:"lineage"`
-	Serial  int    `json:"serial"`
-}
-
 type httpAPIError struct {
 	code int
 	msg  string
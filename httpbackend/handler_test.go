@@ -0,0 +1,552 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+const (
+	testName      = "test"
+	testNamespace = "kubeterra-system"
+	testState     = `{"version":4,"serial":1,"lineage":"0dd5e7ed-5bb3-4bc8-a3b0-0f1c8a4f0d8a"}`
+)
+
+func newTestHandler(objs ...runtime.Object) *backendHandler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
+
+	if len(objs) == 0 {
+		objs = append(objs, &terraformv1beta1.TerraformState{
+			ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+			Spec: terraformv1beta1.TerraformStateSpec{
+				State: &runtime.RawExtension{Raw: []byte(testState)},
+			},
+		})
+	}
+
+	return &backendHandler{
+		Client:    fake.NewFakeClientWithScheme(scheme, objs...),
+		log:       log.NullLogger{},
+		recorder:  record.NewFakeRecorder(100),
+		ctx:       context.Background(),
+		name:      testName,
+		namespace: testNamespace,
+	}
+}
+
+// recordedEvents drains events recorded by the handler
+func recordedEvents(h *backendHandler) []string {
+	events := []string{}
+	recorder := h.recorder.(*record.FakeRecorder)
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBackendHandlerAuthentication(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		setAuth  bool
+		wantCode int
+	}{
+		{
+			name:     "valid credentials",
+			username: "terraform",
+			password: "secret",
+			setAuth:  true,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing credentials",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong password",
+			username: "terraform",
+			password: "guess",
+			setAuth:  true,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong username",
+			username: "admin",
+			password: "secret",
+			setAuth:  true,
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.username = "terraform"
+			h.password = "secret"
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.setAuth {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("WWW-Authenticate header is expected")
+			}
+		})
+	}
+}
+
+func TestBackendHandlerAuthenticationDisabled(t *testing.T) {
+	h := newTestHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec.Body.String() != testState {
+		t.Errorf("ServeHTTP() body = %q, want %q", rec.Body.String(), testState)
+	}
+}
+
+func TestBackendHandlerPullEmptyState(t *testing.T) {
+	h := newTestHandler(&terraformv1beta1.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("ServeHTTP() code = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestBackendHandlerLockConflict(t *testing.T) {
+	h := newTestHandler(&terraformv1beta1.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+		Status: terraformv1beta1.TerraformStateStatus{
+			LockID: "first",
+			Lock:   &terraformv1beta1.TerraformStateLock{ID: "first", Who: "alice@laptop", Operation: "OperationTypeApply"},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("LOCK", "/", strings.NewReader(`{"ID":"second","Who":"bob@laptop"}`)))
+
+	if rec.Code != http.StatusLocked {
+		t.Fatalf("ServeHTTP() code = %d, want %d", rec.Code, http.StatusLocked)
+	}
+
+	holder := statestore.LockInfo{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &holder); err != nil {
+		t.Fatalf("lock holder is expected in the response: %v", err)
+	}
+	if holder.ID != "first" || holder.Who != "alice@laptop" || holder.Operation != "OperationTypeApply" {
+		t.Errorf("unexpected lock holder %+v", holder)
+	}
+}
+
+func TestBackendHandlerLockRenewal(t *testing.T) {
+	h := newTestHandler()
+	defer h.stopRenewal()
+
+	lock := `{"ID":"first","Who":"alice@laptop"}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("LOCK", "/", strings.NewReader(lock)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("LOCK code = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if h.cancelRenew == nil {
+		t.Fatal("lock is expected to be renewed while held")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("UNLOCK", "/", strings.NewReader(lock)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("UNLOCK code = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if h.renewing() {
+		t.Error("renewal is expected to stop once unlocked")
+	}
+}
+
+func TestBackendHandlerDeleteState(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowDelete bool
+		lockID      string
+		wantCode    int
+		wantStored  string
+	}{
+		{
+			name:       "not allowed",
+			wantCode:   http.StatusForbidden,
+			wantStored: testState,
+		},
+		{
+			name:        "allowed",
+			allowDelete: true,
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "locked",
+			allowDelete: true,
+			lockID:      "first",
+			wantCode:    http.StatusLocked,
+			wantStored:  testState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &terraformv1beta1.TerraformState{
+				ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+				Spec: terraformv1beta1.TerraformStateSpec{
+					State: &runtime.RawExtension{Raw: []byte(testState)},
+				},
+				Status: terraformv1beta1.TerraformStateStatus{LockID: tt.lockID},
+			}
+			if tt.allowDelete {
+				state.Annotations = map[string]string{terraformv1beta1.AllowDeleteAnnotation: "true"}
+			}
+			h := newTestHandler(state)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("DELETE", "/", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d, body: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+
+			got := &terraformv1beta1.TerraformState{}
+			if err := h.Get(context.Background(), client.ObjectKey{Name: testName, Namespace: testNamespace}, got); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := statestore.Load(context.Background(), h.Client, got)
+			if err != nil && !errors.Is(err, statestore.ErrEmptyState) {
+				t.Fatal(err)
+			}
+			if string(stored) != tt.wantStored {
+				t.Errorf("stored state = %s, want %s", stored, tt.wantStored)
+			}
+			if _, ok := got.Annotations[terraformv1beta1.AllowDeleteAnnotation]; ok && tt.wantCode == http.StatusOK {
+				t.Errorf("%s annotation is expected to be removed", terraformv1beta1.AllowDeleteAnnotation)
+			}
+		})
+	}
+}
+
+func TestBackendHandlerLockMethods(t *testing.T) {
+	h := newTestHandler()
+	defer h.stopRenewal()
+	h.lockMethod = "PUT"
+	h.unlockMethod = "PATCH"
+
+	lock := `{"ID":"first"}`
+	requests := []struct {
+		method   string
+		wantCode int
+	}{
+		{method: "LOCK", wantCode: http.StatusNotFound},
+		{method: "PUT", wantCode: http.StatusOK},
+		{method: "UNLOCK", wantCode: http.StatusNotFound},
+		{method: "PATCH", wantCode: http.StatusOK},
+	}
+
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(req.method, "/", strings.NewReader(lock)))
+		if rec.Code != req.wantCode {
+			t.Errorf("%s code = %d, want %d, body: %s", req.method, rec.Code, req.wantCode, rec.Body.String())
+		}
+	}
+}
+
+// conflictingClient fails the first updates with conflict, as if the objects
+// were concurrently updated by someone else
+type conflictingClient struct {
+	client.Client
+	conflicts int
+}
+
+func (c *conflictingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		return apierrors.NewConflict(schema.GroupResource{}, testName, errors.New("the object has been modified"))
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestBackendHandlerConflictRetry(t *testing.T) {
+	h := newTestHandler()
+	defer h.stopRenewal()
+	conflicting := &conflictingClient{Client: h.Client}
+	h.Client = conflicting
+
+	lock := `{"ID":"first","Who":"alice@laptop"}`
+	newState := `{"version":4,"serial":2,"lineage":"0dd5e7ed-5bb3-4bc8-a3b0-0f1c8a4f0d8a"}`
+
+	requests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: "LOCK", target: "/", body: lock},
+		{method: "POST", target: "/?ID=first", body: newState},
+		{method: "UNLOCK", target: "/", body: lock},
+		{method: "LOCK", target: "/", body: lock},
+	}
+
+	for _, req := range requests {
+		conflicting.conflicts = 2
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(req.method, req.target, strings.NewReader(req.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s code = %d, want %d, body: %s", req.method, rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+
+	got := &terraformv1beta1.TerraformState{}
+	if err := h.Get(context.Background(), client.ObjectKey{Name: testName, Namespace: testNamespace}, got); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := statestore.Load(context.Background(), h.Client, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != newState {
+		t.Errorf("stored state = %s, want %s", stored, newState)
+	}
+}
+
+func TestBackendHandlerPushState(t *testing.T) {
+	const (
+		lineage = "0dd5e7ed-5bb3-4bc8-a3b0-0f1c8a4f0d8a"
+		lockID  = "lock"
+	)
+
+	tests := []struct {
+		name        string
+		body        string
+		contentMD5  string
+		forcePush   bool
+		wantCode    int
+		wantStored  string
+		wantForceOn bool
+	}{
+		{
+			name:       "newer serial",
+			body:       `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+			wantCode:   http.StatusOK,
+			wantStored: `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+		},
+		{
+			name:       "same serial",
+			body:       testState,
+			wantCode:   http.StatusOK,
+			wantStored: testState,
+		},
+		{
+			name:       "older serial",
+			body:       `{"version":4,"serial":0,"lineage":"` + lineage + `"}`,
+			wantCode:   http.StatusConflict,
+			wantStored: testState,
+		},
+		{
+			name:       "alien lineage",
+			body:       `{"version":4,"serial":2,"lineage":"alien"}`,
+			wantCode:   http.StatusConflict,
+			wantStored: testState,
+		},
+		{
+			name:       "forced rollback",
+			body:       `{"version":4,"serial":0,"lineage":"alien"}`,
+			forcePush:  true,
+			wantCode:   http.StatusOK,
+			wantStored: `{"version":4,"serial":0,"lineage":"alien"}`,
+		},
+		{
+			name:        "force is kept when not needed",
+			body:        `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+			forcePush:   true,
+			wantCode:    http.StatusOK,
+			wantStored:  `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+			wantForceOn: true,
+		},
+		{
+			name:       "valid Content-MD5",
+			body:       `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+			contentMD5: contentMD5(`{"version":4,"serial":2,"lineage":"` + lineage + `"}`),
+			wantCode:   http.StatusOK,
+			wantStored: `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+		},
+		{
+			name:       "corrupted body",
+			body:       `{"version":4,"serial":2,"lineage":"` + lineage + `"}`,
+			contentMD5: contentMD5(testState),
+			wantCode:   http.StatusBadRequest,
+			wantStored: testState,
+		},
+		{
+			name:       "invalid JSON",
+			body:       `{"version":4,`,
+			wantCode:   http.StatusBadRequest,
+			wantStored: testState,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &terraformv1beta1.TerraformState{
+				ObjectMeta: metav1.ObjectMeta{Name: testName, Namespace: testNamespace},
+				Spec: terraformv1beta1.TerraformStateSpec{
+					State: &runtime.RawExtension{Raw: []byte(testState)},
+				},
+				Status: terraformv1beta1.TerraformStateStatus{LockID: lockID},
+			}
+			if tt.forcePush {
+				state.Annotations = map[string]string{terraformv1beta1.ForcePushAnnotation: "true"}
+			}
+			h := newTestHandler(state)
+
+			req := httptest.NewRequest("POST", "/?ID="+lockID, strings.NewReader(tt.body))
+			if tt.contentMD5 != "" {
+				req.Header.Set("Content-MD5", tt.contentMD5)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d, body: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+
+			got := &terraformv1beta1.TerraformState{}
+			if err := h.Get(context.Background(), client.ObjectKey{Name: testName, Namespace: testNamespace}, got); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := statestore.Load(context.Background(), h.Client, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(stored) != tt.wantStored {
+				t.Errorf("stored state = %s, want %s", stored, tt.wantStored)
+			}
+			if _, ok := got.Annotations[terraformv1beta1.ForcePushAnnotation]; ok != tt.wantForceOn {
+				t.Errorf("%s annotation presence = %v, want %v", terraformv1beta1.ForcePushAnnotation, ok, tt.wantForceOn)
+			}
+
+			events := strings.Join(recordedEvents(h), "\n")
+			wantEvent := fmt.Sprintf("Normal %s state is pushed", EventStatePushed)
+			if pushed := strings.Contains(events, wantEvent); pushed != (tt.wantCode == http.StatusOK) {
+				t.Errorf("recorded events %q, %q is expected only for pushed state", events, wantEvent)
+			}
+		})
+	}
+}
+
+func contentMD5(body string) string {
+	sum := md5.Sum([]byte(body)) //nolint:gosec
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// failingClient fails creation of revisions and updates after the first one,
+// which pushes the state
+type failingClient struct {
+	client.Client
+	updates int
+}
+
+func (c *failingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*terraformv1beta1.TerraformStateRevision); ok {
+		return errors.New("revisions are unavailable")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *failingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	c.updates++
+	if c.updates > 1 {
+		return errors.New("updates are unavailable")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestBackendHandlerPushFollowUpsFailed(t *testing.T) {
+	lockID := "lock"
+	h := newTestHandler(&terraformv1beta1.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testName,
+			Namespace:   testNamespace,
+			Annotations: map[string]string{terraformv1beta1.ForcePushAnnotation: "true"},
+		},
+		Spec: terraformv1beta1.TerraformStateSpec{
+			State: &runtime.RawExtension{Raw: []byte(testState)},
+		},
+		Status: terraformv1beta1.TerraformStateStatus{LockID: lockID},
+	})
+	h.Client = &failingClient{Client: h.Client}
+
+	rollback := `{"version":4,"serial":0,"lineage":"alien"}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/?ID="+lockID, strings.NewReader(rollback)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() code = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	events := strings.Join(recordedEvents(h), "\n")
+	for _, reason := range []string{EventStatePushed, EventRevisionFailed, EventForcePushKept} {
+		if !strings.Contains(events, reason) {
+			t.Errorf("recorded events %q, %s is expected", events, reason)
+		}
+	}
+}
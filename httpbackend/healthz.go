@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	"github.com/loodse/kubeterra/healthz"
+)
+
+// probeTimeout limits requests to the API server made by readiness checks,
+// kubelet gives up on probes after a second by default
+const probeTimeout = time.Second
+
+// kubernetesCheck checks the API server is reachable
+func kubernetesCheck(cfg *rest.Config) (healthz.Checker, error) {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Timeout = probeTimeout
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(*http.Request) error {
+		_, err := discoveryClient.ServerVersion()
+		return err
+	}, nil
+}
+
+// stateCheck checks TerraformState served by the handler exists
+func stateCheck(h *backendHandler) healthz.Checker {
+	return func(r *http.Request) error {
+		_, err := h.getState(r.Context())
+		return err
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+func TestStateCheck(t *testing.T) {
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	if err := stateCheck(newTestHandler())(req); err != nil {
+		t.Errorf("existing state check failed: %v", err)
+	}
+
+	other := &terraformv1beta1.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: testNamespace},
+	}
+	if err := stateCheck(newTestHandler(other))(req); err == nil {
+		t.Error("missing state check passed")
+	}
+}
@@ -18,16 +18,28 @@ package httpbackend
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	terraformv1alpha1 "github.com/loodse/kubeterra/api/v1alpha1"
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/healthz"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/metrics"
+)
+
+const (
+	// DefaultLockMethod is HTTP method terraform locks the state with
+	DefaultLockMethod = "LOCK"
+
+	// DefaultUnlockMethod is HTTP method terraform unlocks the state with
+	DefaultUnlockMethod = "UNLOCK"
 )
 
 // Options to configure terraform http backend
@@ -35,27 +47,111 @@ type Options struct {
 	TerraformStateName      string
 	TerraformStateNamespace string
 	Listen                  string
+	MetricsAddr             string
 	Development             bool
+	LogLevel                string
+	LogFormat               string
+
+	// Central serves every TerraformState at /states/{namespace}/{name}
+	// instead of the single one, authenticating callers with their
+	// Kubernetes bearer tokens
+	Central bool
+
+	// IdleTimeout is how long central backend keeps handlers of states
+	// served without a lock, DefaultCentralIdleTimeout when not set
+	IdleTimeout time.Duration
+
+	// LockMethod and UnlockMethod are HTTP methods terraform is configured to
+	// lock and unlock the state with, as lock_method and unlock_method
+	LockMethod   string
+	UnlockMethod string
+
+	// RunID identifies terraform run in recorded state revisions
+	RunID string
+
+	// StateDir is where volume of the filesystem state storage is mounted
+	StateDir string
+
+	// Username and Password are credentials terraform should authenticate
+	// with, authentication is disabled when both are empty
+	Username string
+	Password string
+
+	// TLS serves backend over HTTPS when enabled
+	TLS TLSOptions
 }
 
 // ListenAndServe launch terraform http backend server
 func ListenAndServe(opts Options) error {
-	ctrl.SetLogger(zap.Logger(opts.Development))
+	logger, err := logging.New(logging.Options{
+		Level:       opts.LogLevel,
+		Format:      opts.LogFormat,
+		Development: opts.Development,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctrl.SetLogger(logger)
 	httpLog := ctrl.Log.WithName("http")
-	httpLog.Info("starting", "port", opts.Listen, "state-name", opts.TerraformStateName)
+	if opts.Central {
+		httpLog.Info("starting central backend", "port", opts.Listen, "path", StatesPath)
+	} else {
+		httpLog.Info("starting", "port", opts.Listen, "state-name", opts.TerraformStateName)
+	}
+
+	if !opts.Central && opts.Username == "" && opts.Password == "" {
+		httpLog.Info("authentication is disabled")
+	}
 
-	mux, err := newHTTPBackendMux(opts.TerraformStateName, opts.TerraformStateNamespace, httpLog)
+	mux, err := newHTTPBackendMux(opts, httpLog)
 	if err != nil {
 		return err
 	}
 
-	return http.ListenAndServe(opts.Listen, mux)
+	server := &http.Server{
+		Addr:    opts.Listen,
+		Handler: mux,
+	}
+
+	if opts.TLS.Enabled() {
+		server.TLSConfig, err = newTLSConfig(opts.TLS)
+		if err != nil {
+			return err
+		}
+		httpLog.Info("serving TLS", "client-auth", opts.TLS.ClientCAFile != "")
+	}
+
+	errCh := make(chan error, 2)
+	if opts.MetricsAddr != "" {
+		httpLog.Info("serving metrics", "port", opts.MetricsAddr)
+		go func() {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", metrics.Handler())
+			errCh <- http.ListenAndServe(opts.MetricsAddr, metricsMux)
+		}()
+	}
+
+	go func() {
+		if !opts.TLS.Enabled() {
+			errCh <- server.ListenAndServe()
+			return
+		}
+		// certificates are provided by TLSConfig
+		errCh <- server.ListenAndServeTLS("", "")
+	}()
+
+	return <-errCh
 }
 
-func newHTTPBackendMux(name, namespace string, httpLog logr.Logger) (*http.ServeMux, error) {
+func newHTTPBackendMux(opts Options, httpLog logr.Logger) (*http.ServeMux, error) {
+	if err := validateLockMethods(opts.LockMethod, opts.UnlockMethod); err != nil {
+		return nil, err
+	}
+
 	scheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(scheme)
-	_ = terraformv1alpha1.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
 	cfg := ctrl.GetConfigOrDie()
 
 	dynClient, err := client.New(cfg, client.Options{Scheme: scheme})
@@ -63,15 +159,84 @@ func newHTTPBackendMux(name, namespace string, httpLog logr.Logger) (*http.Serve
 		return nil, err
 	}
 
+	recorder, err := newEventRecorder(cfg, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	apiCheck, err := kubernetesCheck(cfg)
+	if err != nil {
+		return nil, err
+	}
+	probes := healthz.NewProbes()
+	probes.Readyz.AddCheck("kubernetes", apiCheck)
+
+	// probes are registered ahead of state handlers and aren't counted as
+	// backend requests
+	mux := http.NewServeMux()
+	probes.Register(mux)
+
+	// requests are only counted by the central backend, sidecars of runs don't
+	// live long enough to be scraped
+	if opts.Central {
+		idleTimeout := opts.IdleTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = DefaultCentralIdleTimeout
+		}
+		mux.Handle(StatesPath, metrics.InstrumentHandler(&centralHandler{
+			Client:      dynClient,
+			log:         httpLog,
+			recorder:    recorder,
+			ctx:         context.Background(),
+			handlers:    map[client.ObjectKey]*centralEntry{},
+			idleTimeout: idleTimeout,
+
+			lockMethod:   opts.LockMethod,
+			unlockMethod: opts.UnlockMethod,
+		}))
+		return mux, nil
+	}
+
+	stateKey := client.ObjectKey{Namespace: opts.TerraformStateNamespace, Name: opts.TerraformStateName}
 	h := &backendHandler{
 		Client:    dynClient,
-		log:       httpLog,
-		name:      name,
-		namespace: namespace,
+		log:       httpLog.WithValues(logging.StateKey, stateKey, logging.RunIDKey, opts.RunID),
+		recorder:  recorder,
+		name:      opts.TerraformStateName,
+		namespace: opts.TerraformStateNamespace,
+		username:  opts.Username,
+		password:  opts.Password,
+		runID:     opts.RunID,
+		stateDir:  opts.StateDir,
 		ctx:       context.Background(),
+
+		lockMethod:   opts.LockMethod,
+		unlockMethod: opts.UnlockMethod,
 	}
 
-	mux := http.NewServeMux()
+	probes.Readyz.AddCheck("state", stateCheck(h))
 	mux.Handle("/", h)
 	return mux, nil
 }
+
+// validateLockMethods rejects lock and unlock methods which can't be told
+// apart from other requests, empty methods are defaulted
+func validateLockMethods(lockMethod, unlockMethod string) error {
+	if lockMethod == "" {
+		lockMethod = DefaultLockMethod
+	}
+	if unlockMethod == "" {
+		unlockMethod = DefaultUnlockMethod
+	}
+
+	if lockMethod == unlockMethod {
+		return fmt.Errorf("lock and unlock methods are both %s", lockMethod)
+	}
+	for _, method := range []string{lockMethod, unlockMethod} {
+		switch method {
+		case "GET", "POST", "DELETE":
+			return fmt.Errorf("%s can't be used as lock or unlock method", method)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"testing"
+)
+
+func TestValidateLockMethods(t *testing.T) {
+	tests := []struct {
+		name         string
+		lockMethod   string
+		unlockMethod string
+		wantErr      bool
+	}{
+		{name: "defaults"},
+		{name: "custom", lockMethod: "PUT", unlockMethod: "PATCH"},
+		{name: "same methods", lockMethod: "PUT", unlockMethod: "PUT", wantErr: true},
+		{name: "lock with push method", lockMethod: "POST", wantErr: true},
+		{name: "unlock with delete method", unlockMethod: "DELETE", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLockMethods(tt.lockMethod, tt.unlockMethod)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLockMethods() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const certReloadInterval = 10 * time.Second
+
+// TLSOptions to configure TLS of terraform http backend
+type TLSOptions struct {
+	// CertFile and KeyFile are PEM encoded serving certificate and its key,
+	// they are reloaded once changed on disk
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is PEM encoded CA bundle to verify client certificates
+	// against. Client certificates are required if set.
+	ClientCAFile string
+
+	// SelfSigned generates in-memory self signed certificate for SelfSignedHosts
+	// in case if CertFile and KeyFile are not set
+	SelfSigned      bool
+	SelfSignedHosts []string
+
+	// SelfSignedCAFile is a path to write PEM encoded CA of self signed
+	// certificate to, so clients are able to trust it
+	SelfSignedCAFile string
+}
+
+// Enabled reports if server should serve TLS
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.SelfSigned
+}
+
+// SelfSignedCertificate is a PEM encoded CA and serving certificate signed by it
+type SelfSignedCertificate struct {
+	CA   []byte
+	Cert []byte
+	Key  []byte
+}
+
+// GenerateSelfSignedCertificate generates a CA and a serving certificate for
+// given DNS names / IP addresses signed by this CA
+func GenerateSelfSignedCertificate(hosts []string, validFor time.Duration) (*SelfSignedCertificate, error) {
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(validFor)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "kubeterra-httpbackend-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "kubeterra-httpbackend"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SelfSignedCertificate{
+		CA:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+// newTLSConfig builds server TLS configuration from options
+func newTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch {
+	case opts.CertFile != "" && opts.KeyFile != "":
+		reloader := &certReloader{certFile: opts.CertFile, keyFile: opts.KeyFile}
+		if err := reloader.reload(); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	case opts.CertFile != "" || opts.KeyFile != "":
+		return nil, errors.New("both TLS certificate and key files are required")
+	case opts.SelfSigned:
+		selfSigned, err := GenerateSelfSignedCertificate(opts.SelfSignedHosts, 365*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(selfSigned.Cert, selfSigned.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		if opts.SelfSignedCAFile != "" {
+			if err := ioutil.WriteFile(opts.SelfSignedCAFile, selfSigned.CA, 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader serves certificate from files and reloads it once files are
+// modified, e.g. by rotating mounted kubernetes secret
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) >= certReloadInterval {
+		c.checkedAt = time.Now()
+		if modTime, err := c.latestModTime(); err == nil && !modTime.Equal(c.modTime) {
+			// keep serving previous certificate in case if new one is broken
+			_ = c.load()
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkedAt = time.Now()
+	return c.load()
+}
+
+func (c *certReloader) load() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
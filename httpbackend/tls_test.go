@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpbackend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	cert, err := GenerateSelfSignedCertificate([]string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCertificate() error = %v", err)
+	}
+
+	if _, err := tls.X509KeyPair(cert.Cert, cert.Key); err != nil {
+		t.Fatalf("certificate doesn't match the key: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cert.CA) {
+		t.Fatal("unable to parse CA")
+	}
+
+	leaf := parseCertificate(t, cert.Cert)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		if err != nil {
+			t.Errorf("certificate is not valid for %q: %v", host, err)
+		}
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err == nil {
+		t.Errorf("certificate is not expected to be valid for example.com")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeterra-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeCertificate := func(modTime time.Time) *SelfSignedCertificate {
+		cert, err := GenerateSelfSignedCertificate([]string{"localhost"}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for file, data := range map[string][]byte{certFile: cert.Cert, keyFile: cert.Key} {
+			if err := ioutil.WriteFile(file, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(file, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return cert
+	}
+
+	now := time.Now()
+	first := writeCertificate(now.Add(-time.Hour))
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	assertServedCertificate(t, reloader, first)
+
+	second := writeCertificate(now)
+
+	// rotated certificate is not picked up before reload interval passes
+	assertServedCertificate(t, reloader, first)
+
+	reloader.checkedAt = time.Time{}
+	assertServedCertificate(t, reloader, second)
+
+	// broken certificate keeps previous one served
+	if err := ioutil.WriteFile(certFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, now.Add(time.Hour), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	reloader.checkedAt = time.Time{}
+	assertServedCertificate(t, reloader, second)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeterra-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	cfg, err := newTLSConfig(TLSOptions{
+		SelfSigned:       true,
+		SelfSignedHosts:  []string{"localhost"},
+		SelfSignedCAFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("newTLSConfig() error = %v", err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("expected single self-signed certificate, got %d", len(cfg.Certificates))
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("client certificates are not expected to be required")
+	}
+
+	cfg, err = newTLSConfig(TLSOptions{SelfSigned: true, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("newTLSConfig() error = %v", err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client certificates are expected to be required")
+	}
+
+	if _, err := newTLSConfig(TLSOptions{CertFile: filepath.Join(dir, "tls.crt")}); err == nil {
+		t.Errorf("newTLSConfig() is expected to fail without key file")
+	}
+}
+
+func assertServedCertificate(t *testing.T, reloader *certReloader, want *SelfSignedCertificate) {
+	t.Helper()
+
+	got, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+
+	if !parseCertificate(t, want.Cert).Equal(parseCertificate(t, pemFromDER(got.Certificate[0]))) {
+		t.Errorf("unexpected certificate served")
+	}
+}
+
+func parseCertificate(t *testing.T, data []byte) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatal("unable to decode PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func pemFromDER(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
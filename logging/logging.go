@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging configures loggers of manager and httpbackend, and defines
+// keys they log objects with
+package logging
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// Formats of log lines
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Keys every log line carries the objects it's about with
+const (
+	// ConfigurationKey is namespace/name of TerraformConfiguration
+	ConfigurationKey = "configuration"
+
+	// PlanKey is namespace/name of TerraformPlan
+	PlanKey = "plan"
+
+	// StateKey is namespace/name of TerraformState
+	StateKey = "state"
+
+	// RunIDKey identifies terraform run, as name of its pod
+	RunIDKey = "runID"
+
+	// SerialKey is serial of terraform state
+	SerialKey = "serial"
+)
+
+// Options to configure logger
+type Options struct {
+	// Level is one of error, info or debug, or verbosity number logged
+	// messages are enabled up to, e.g. 2 for V(2). Defaults to info, or to
+	// debug in development mode.
+	Level string
+
+	// Format is json or console. Defaults to json, or to console in
+	// development mode.
+	Format string
+
+	// Development mode adds stacktraces to warnings and disables sampling
+	Development bool
+}
+
+// New returns logger configured with options
+func New(opts Options) (logr.Logger, error) {
+	level, err := ParseLevel(opts.Level, opts.Development)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := newEncoder(opts.Format, opts.Development)
+	if err != nil {
+		return nil, err
+	}
+
+	atomicLevel := zap.NewAtomicLevelAt(level)
+	return ctrlzap.New(func(o *ctrlzap.Options) {
+		o.Development = opts.Development
+		o.Level = &atomicLevel
+		o.Encoder = encoder
+	}), nil
+}
+
+// ParseLevel returns zap level of the named level or verbosity number, V(n)
+// is logged at level -n
+func ParseLevel(level string, development bool) (zapcore.Level, error) {
+	switch level {
+	case "":
+		if development {
+			return zapcore.DebugLevel, nil
+		}
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "debug":
+		return zapcore.DebugLevel, nil
+	}
+
+	verbosity, err := strconv.Atoi(level)
+	if err != nil || verbosity < 0 || verbosity > 127 {
+		return 0, fmt.Errorf("invalid log level %q, expected error, info, debug or verbosity number", level)
+	}
+	return zapcore.Level(-verbosity), nil
+}
+
+func newEncoder(format string, development bool) (zapcore.Encoder, error) {
+	if format == "" {
+		format = FormatJSON
+		if development {
+			format = FormatConsole
+		}
+	}
+
+	switch format {
+	case FormatJSON:
+		encCfg := zap.NewProductionEncoderConfig()
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewJSONEncoder(encCfg), nil
+	case FormatConsole:
+		encCfg := zap.NewDevelopmentEncoderConfig()
+		return zapcore.NewConsoleEncoder(encCfg), nil
+	}
+
+	return nil, fmt.Errorf("invalid log format %q, expected %s or %s", format, FormatJSON, FormatConsole)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level       string
+		development bool
+		want        zapcore.Level
+		wantErr     bool
+	}{
+		{level: "", want: zapcore.InfoLevel},
+		{level: "", development: true, want: zapcore.DebugLevel},
+		{level: "error", development: true, want: zapcore.ErrorLevel},
+		{level: "info", want: zapcore.InfoLevel},
+		{level: "debug", want: zapcore.DebugLevel},
+		{level: "2", want: zapcore.Level(-2)},
+		{level: "-1", wantErr: true},
+		{level: "trace", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			got, err := ParseLevel(tt.level, tt.development)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, format := range []string{"", FormatJSON, FormatConsole} {
+		if _, err := New(Options{Format: format}); err != nil {
+			t.Errorf("New() with format %q error = %v", format, err)
+		}
+	}
+
+	if _, err := New(Options{Format: "text"}); err == nil {
+		t.Error("New() is expected to reject unknown format")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	ctrlmanager "sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/loodse/kubeterra/healthz"
+)
+
+// setFlagRunnable sets the flag once manager starts it and blocks until stop
+type setFlagRunnable struct {
+	flag           *healthz.Flag
+	leaderElection bool
+}
+
+func (r *setFlagRunnable) Start(stop <-chan struct{}) error {
+	r.flag.Set()
+	<-stop
+	return nil
+}
+
+// NeedLeaderElection implements ctrlmanager.LeaderElectionRunnable
+func (r *setFlagRunnable) NeedLeaderElection() bool {
+	return r.leaderElection
+}
+
+// serveProbes serves /healthz and /readyz at addr. Manager starts runnables
+// only after its caches have synced, and those needing leader election only
+// once it's elected, so readiness is tracked with runnables setting flags.
+// Probes are served right away, not to be restarted by liveness probe while
+// caches sync.
+func serveProbes(mgr ctrlmanager.Manager, addr string, leaderElection bool, log logr.Logger) error {
+	probes := healthz.NewProbes()
+
+	synced := healthz.NewFlag("informer caches are not synced")
+	probes.Readyz.AddCheck("cache-sync", synced.Check)
+	if err := mgr.Add(&setFlagRunnable{flag: synced}); err != nil {
+		return err
+	}
+
+	if leaderElection {
+		leader := healthz.NewFlag("not elected as leader")
+		probes.Readyz.AddCheck("leader", leader.Check)
+		if err := mgr.Add(&setFlagRunnable{flag: leader, leaderElection: true}); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	probes.Register(mux)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Error(err, "problem serving health probes")
+		}
+	}()
+
+	return nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package manager
 
 import (
+	"fmt"
 	"os"
 	"time"
 
@@ -24,20 +25,28 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	// +kubebuilder:scaffold:imports
 
 	terraformv1alpha1 "github.com/loodse/kubeterra/api/v1alpha1"
+	terraformv1beta1 "github.com/loodse/kubeterra/api/v1beta1"
 	"github.com/loodse/kubeterra/controllers"
+	"github.com/loodse/kubeterra/logging"
+	"github.com/loodse/kubeterra/metrics"
+	"github.com/loodse/kubeterra/notifier"
 )
 
 // Options to configure manager
 type Options struct {
-	MetricsAddr    string
-	LeaderElection bool
-	Development    bool
-	Namespace      string
+	MetricsAddr     string
+	HealthProbeAddr string
+	LeaderElection  bool
+	Development     bool
+	Namespace       string
+	EnableWebhooks  bool
+	WebhookPort     int
+	LogLevel        string
+	LogFormat       string
 }
 
 // Launch manager
@@ -45,10 +54,20 @@ func Launch(opts Options) error {
 	scheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = terraformv1alpha1.AddToScheme(scheme)
+	_ = terraformv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 
+	logger, err := logging.New(logging.Options{
+		Level:       opts.LogLevel,
+		Format:      opts.LogFormat,
+		Development: opts.Development,
+	})
+	if err != nil {
+		return err
+	}
+
 	setupLog := ctrl.Log.WithName("setup")
-	ctrl.SetLogger(zap.Logger(opts.Development))
+	ctrl.SetLogger(logger)
 
 	syncPeriod := 10 * time.Minute
 	mgr, err := ctrl.NewManager(
@@ -59,6 +78,7 @@ func Launch(opts Options) error {
 			MetricsBindAddress: opts.MetricsAddr,
 			LeaderElection:     opts.LeaderElection,
 			Namespace:          opts.Namespace,
+			Port:               opts.WebhookPort,
 		},
 	)
 	if err != nil {
@@ -66,6 +86,11 @@ func Launch(opts Options) error {
 		os.Exit(1)
 	}
 
+	if err := metrics.RegisterObjectCollector(mgr.GetClient()); err != nil {
+		setupLog.Error(err, "unable to register metrics")
+		os.Exit(1)
+	}
+
 	restConfig := mgr.GetConfig()
 	coreV1Client, err := corev1typed.NewForConfig(restConfig)
 	if err != nil {
@@ -78,22 +103,56 @@ func Launch(opts Options) error {
 		Log:       ctrl.Log.WithName("controllers").WithName("TerraformPlan"),
 		Scheme:    mgr.GetScheme(),
 		PodClient: coreV1Client,
+		Recorder:  mgr.GetEventRecorderFor("kubeterra"),
+		Notifier:  notifier.New(mgr.GetClient(), ctrl.Log.WithName("notifier")),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TerraformPlan")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.TerraformConfigurationReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("TerraformConfiguration"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("TerraformConfiguration"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("kubeterra"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TerraformConfiguration")
 		os.Exit(1)
 	}
 
+	if err = (&controllers.TerraformStateReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("TerraformState"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("kubeterra"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TerraformState")
+		os.Exit(1)
+	}
+
+	if opts.EnableWebhooks {
+		for _, obj := range []runtime.Object{
+			&terraformv1beta1.TerraformConfiguration{},
+			&terraformv1beta1.TerraformPlan{},
+			&terraformv1beta1.TerraformState{},
+		} {
+			if err = ctrl.NewWebhookManagedBy(mgr).For(obj).Complete(); err != nil {
+				setupLog.Error(err, "unable to create conversion webhook", "webhook", fmt.Sprintf("%T", obj))
+				os.Exit(1)
+			}
+		}
+	}
+
 	// +kubebuilder:scaffold:builder
 
+	if opts.HealthProbeAddr != "" {
+		setupLog.Info("serving health probes", "port", opts.HealthProbeAddr)
+		if err := serveProbes(mgr, opts.HealthProbeAddr, opts.LeaderElection, setupLog); err != nil {
+			setupLog.Error(err, "unable to serve health probes")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
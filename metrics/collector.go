@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+var (
+	stateLockedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "state_locked"),
+		"Whether TerraformState is locked, 1 for locked.",
+		[]string{"namespace", "name"}, nil,
+	)
+
+	stateLockAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "state_lock_age_seconds"),
+		"Time since the lock of locked TerraformState is held.",
+		[]string{"namespace", "name"}, nil,
+	)
+
+	stateSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "state_size_bytes"),
+		"Size of terraform state stored in TerraformState or its chunk Secrets, gzip compressed unless it's stored as is.",
+		[]string{"namespace", "name"}, nil,
+	)
+
+	configurationDriftedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "configuration_drifted"),
+		"Whether the latest plan of already applied TerraformConfiguration has found changes, 1 for drifted.",
+		[]string{"namespace", "name"}, nil,
+	)
+
+	configurationFailedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "configuration_failed"),
+		"Whether the latest terraform run of TerraformConfiguration has failed, 1 for failed.",
+		[]string{"namespace", "name"}, nil,
+	)
+)
+
+// RegisterObjectCollector registers metrics taken from TerraformStates and
+// TerraformPlans on every scrape, reading them with the reader
+func RegisterObjectCollector(reader client.Reader) error {
+	return ctrlmetrics.Registry.Register(&objectCollector{Reader: reader, now: time.Now})
+}
+
+// objectCollector reports locks and sizes of TerraformStates and results of
+// TerraformPlans. Both are only known for states stored in TerraformState
+// itself, external storages keep them elsewhere.
+type objectCollector struct {
+	client.Reader
+	now func() time.Time
+}
+
+// Describe implements prometheus.Collector
+func (c *objectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateLockedDesc
+	ch <- stateLockAgeDesc
+	ch <- stateSizeDesc
+	ch <- configurationDriftedDesc
+	ch <- configurationFailedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *objectCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	states := terapi.TerraformStateList{}
+	if err := c.List(ctx, &states); err != nil {
+		ch <- prometheus.NewInvalidMetric(stateLockedDesc, err)
+	}
+	for i := range states.Items {
+		c.collectState(ch, &states.Items[i])
+	}
+
+	plans := terapi.TerraformPlanList{}
+	if err := c.List(ctx, &plans); err != nil {
+		ch <- prometheus.NewInvalidMetric(configurationDriftedDesc, err)
+	}
+	for i := range plans.Items {
+		plan := &plans.Items[i]
+		ch <- prometheus.MustNewConstMetric(configurationDriftedDesc, prometheus.GaugeValue,
+			boolValue(plan.Status.Drifted()), plan.Namespace, plan.Name)
+		ch <- prometheus.MustNewConstMetric(configurationFailedDesc, prometheus.GaugeValue,
+			boolValue(plan.Status.Failed()), plan.Namespace, plan.Name)
+	}
+}
+
+func (c *objectCollector) collectState(ch chan<- prometheus.Metric, state *terapi.TerraformState) {
+	locked := state.Status.LockID != ""
+	ch <- prometheus.MustNewConstMetric(stateLockedDesc, prometheus.GaugeValue,
+		boolValue(locked), state.Namespace, state.Name)
+
+	if locked && state.Status.LockedSince != nil {
+		age := c.now().Sub(state.Status.LockedSince.Time)
+		ch <- prometheus.MustNewConstMetric(stateLockAgeDesc, prometheus.GaugeValue,
+			age.Seconds(), state.Namespace, state.Name)
+	}
+
+	if size, ok := storedSize(&state.Spec); ok {
+		ch <- prometheus.MustNewConstMetric(stateSizeDesc, prometheus.GaugeValue,
+			float64(size), state.Namespace, state.Name)
+	}
+}
+
+// storedSize returns size of the state kept in TerraformState, sizes of chunks
+// stored before it was recorded aren't known
+func storedSize(spec *terapi.TerraformStateSpec) (int64, bool) {
+	switch {
+	case spec.Chunks != nil:
+		return spec.Chunks.Size, spec.Chunks.Size > 0
+	case len(spec.CompressedState) > 0:
+		return int64(len(spec.CompressedState)), true
+	case spec.State != nil && len(spec.State.Raw) > 0:
+		return int64(len(spec.State.Raw)), true
+	}
+	return 0, false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+func TestObjectCollector(t *testing.T) {
+	now := time.Date(2019, 11, 1, 12, 0, 0, 0, time.UTC)
+	lockedSince := metav1.NewTime(now.Add(-90 * time.Second))
+
+	scheme := runtime.NewScheme()
+	_ = terapi.AddToScheme(scheme)
+
+	objs := []runtime.Object{
+		&terapi.TerraformState{
+			ObjectMeta: metav1.ObjectMeta{Name: "locked", Namespace: "default"},
+			Spec:       terapi.TerraformStateSpec{CompressedState: make([]byte, 120)},
+			Status:     terapi.TerraformStateStatus{LockID: "abcd", LockedSince: &lockedSince},
+		},
+		&terapi.TerraformState{
+			ObjectMeta: metav1.ObjectMeta{Name: "unlocked", Namespace: "default"},
+			Spec: terapi.TerraformStateSpec{
+				Chunks: &terapi.TerraformStateChunks{Secrets: []string{"unlocked-0", "unlocked-1"}, Size: 1048576},
+			},
+		},
+		&terapi.TerraformState{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "default"},
+		},
+		&terapi.TerraformPlan{
+			ObjectMeta: metav1.ObjectMeta{Name: "drifted", Namespace: "default"},
+			Status: terapi.TerraformPlanStatus{
+				Phase:                        terapi.TerraformPhaseWaitingApproval,
+				ConfigurationSpecHash:        "abcd",
+				AppliedConfigurationSpecHash: "abcd",
+				PlanSummary:                  "Plan: 0 to add, 1 to change, 0 to destroy.",
+			},
+		},
+		&terapi.TerraformPlan{
+			ObjectMeta: metav1.ObjectMeta{Name: "failed", Namespace: "default"},
+			Status:     terapi.TerraformPlanStatus{Phase: terapi.TerraformPhaseApplyFailed},
+		},
+	}
+
+	collector := &objectCollector{
+		Reader: fake.NewFakeClientWithScheme(scheme, objs...),
+		now:    func() time.Time { return now },
+	}
+
+	expected := `
+# HELP kubeterra_configuration_drifted Whether the latest plan of already applied TerraformConfiguration has found changes, 1 for drifted.
+# TYPE kubeterra_configuration_drifted gauge
+kubeterra_configuration_drifted{name="drifted",namespace="default"} 1
+kubeterra_configuration_drifted{name="failed",namespace="default"} 0
+# HELP kubeterra_configuration_failed Whether the latest terraform run of TerraformConfiguration has failed, 1 for failed.
+# TYPE kubeterra_configuration_failed gauge
+kubeterra_configuration_failed{name="drifted",namespace="default"} 0
+kubeterra_configuration_failed{name="failed",namespace="default"} 1
+# HELP kubeterra_state_lock_age_seconds Time since the lock of locked TerraformState is held.
+# TYPE kubeterra_state_lock_age_seconds gauge
+kubeterra_state_lock_age_seconds{name="locked",namespace="default"} 90
+# HELP kubeterra_state_locked Whether TerraformState is locked, 1 for locked.
+# TYPE kubeterra_state_locked gauge
+kubeterra_state_locked{name="empty",namespace="default"} 0
+kubeterra_state_locked{name="locked",namespace="default"} 1
+kubeterra_state_locked{name="unlocked",namespace="default"} 0
+# HELP kubeterra_state_size_bytes Size of terraform state stored in TerraformState or its chunk Secrets, gzip compressed unless it's stored as is.
+# TYPE kubeterra_state_size_bytes gauge
+kubeterra_state_size_bytes{name="locked",namespace="default"} 120
+kubeterra_state_size_bytes{name="unlocked",namespace="default"} 1.048576e+06
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDrifted(t *testing.T) {
+	applied := terapi.TerraformPlanStatus{
+		Phase:                        terapi.TerraformPhaseWaitingApproval,
+		ConfigurationSpecHash:        "abcd",
+		AppliedConfigurationSpecHash: "abcd",
+		PlanSummary:                  "Plan: 1 to add, 0 to change, 0 to destroy.",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*terapi.TerraformPlanStatus)
+		want   bool
+	}{
+		{
+			name:   "changes of applied configuration",
+			modify: func(*terapi.TerraformPlanStatus) {},
+			want:   true,
+		},
+		{
+			name:   "changed configuration",
+			modify: func(s *terapi.TerraformPlanStatus) { s.ConfigurationSpecHash = "efgh" },
+		},
+		{
+			name:   "never applied",
+			modify: func(s *terapi.TerraformPlanStatus) { s.AppliedConfigurationSpecHash = "" },
+		},
+		{
+			name:   "no changes",
+			modify: func(s *terapi.TerraformPlanStatus) { s.PlanSummary = "No changes. Infrastructure is up-to-date." },
+		},
+		{
+			name:   "apply running",
+			modify: func(s *terapi.TerraformPlanStatus) { s.Phase = terapi.TerraformPhaseApplyRunning },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := applied
+			tt.modify(&status)
+			if got := status.Drifted(); got != tt.want {
+				t.Errorf("Drifted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
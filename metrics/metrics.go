@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines Prometheus metrics of manager and central httpbackend.
+// They are registered in the controller-runtime registry, served by the manager
+// along with its default metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "kubeterra"
+
+// Results of terraform runs and plan reviews
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultApproved  = "approved"
+	ResultRejected  = "rejected"
+)
+
+var (
+	runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Finished terraform runs by kind (plan or apply) and result.",
+	}, []string{"kind", "result"})
+
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of finished terraform runs by kind (plan or apply) and result.",
+		// 10s to ~1.5h
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	}, []string{"kind", "result"})
+
+	approvalWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "approval_wait_seconds",
+		Help:      "Time plans spent waiting for approval, by review result.",
+		// 1m to ~11d
+		Buckets: prometheus.ExponentialBuckets(60, 4, 8),
+	}, []string{"result"})
+
+	backendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_requests_total",
+		Help:      "HTTP requests served by httpbackend, by method and status code.",
+	}, []string{"method", "code"})
+
+	backendRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Latency of HTTP requests served by httpbackend, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		runs,
+		runDuration,
+		approvalWait,
+		backendRequests,
+		backendRequestDuration,
+	)
+}
+
+// ObserveRun records finished terraform run of the kind, plan or apply
+func ObserveRun(kind, result string, duration time.Duration) {
+	runs.WithLabelValues(kind, result).Inc()
+	runDuration.WithLabelValues(kind, result).Observe(duration.Seconds())
+}
+
+// ObserveApprovalWait records time the plan waited for the review with the
+// result, approved or rejected
+func ObserveApprovalWait(result string, wait time.Duration) {
+	approvalWait.WithLabelValues(result).Observe(wait.Seconds())
+}
+
+// InstrumentHandler counts requests served by central httpbackend and observes
+// their latency
+func InstrumentHandler(handler http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerDuration(backendRequestDuration,
+		promhttp.InstrumentHandlerCounter(backendRequests, handler),
+	)
+}
+
+// Handler serves registered metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.HTTPErrorOnError,
+	})
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusLocked)
+	}))
+
+	counter := backendRequests.WithLabelValues("lock", "423")
+	before := testutil.ToFloat64(counter)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("LOCK", "/", nil))
+
+	if got := testutil.ToFloat64(counter); got != before+1 {
+		t.Errorf("requests = %v, want %v", got, before+1)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notifier posts notifications about outcomes of terraform runs to
+// webhooks configured with TerraformNotifications. Delivery is retried with
+// backoff in the background, the result is recorded in TerraformNotification
+// status.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/logging"
+)
+
+// DefaultBackoff retries delivery for about a minute
+var DefaultBackoff = wait.Backoff{
+	Duration: 2 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    6,
+}
+
+const requestTimeout = 10 * time.Second
+
+// Notification about outcome of the terraform run of TerraformConfiguration
+type Notification struct {
+	Event         terapi.TerraformNotificationEvent `json:"event"`
+	Namespace     string                            `json:"namespace"`
+	Configuration string                            `json:"configuration"`
+	Phase         terapi.TerraformPhase             `json:"phase"`
+	Run           int64                             `json:"run"`
+	Summary       string                            `json:"summary,omitempty"`
+	Message       string                            `json:"message,omitempty"`
+	Time          metav1.Time                       `json:"time"`
+
+	// Labels of TerraformConfiguration, matched by selectors of
+	// TerraformNotifications
+	Labels map[string]string `json:"-"`
+}
+
+// Notifier sends notifications to webhooks of TerraformNotifications
+// selecting them
+type Notifier struct {
+	client.Client
+	Log        logr.Logger
+	HTTPClient *http.Client
+	Backoff    wait.Backoff
+}
+
+// New returns Notifier with default backoff
+func New(c client.Client, log logr.Logger) *Notifier {
+	return &Notifier{
+		Client:     c,
+		Log:        log,
+		HTTPClient: &http.Client{Timeout: requestTimeout},
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// Notify sends notifications in the background
+func (n *Notifier) Notify(notifications ...Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	go func() {
+		for _, notification := range notifications {
+			n.notify(context.Background(), notification)
+		}
+	}()
+}
+
+// notify delivers the notification to every TerraformNotification selecting
+// it and waits until delivery is done or given up on
+func (n *Notifier) notify(ctx context.Context, notification Notification) {
+	log := n.Log.WithValues(logging.ConfigurationKey, client.ObjectKey{Namespace: notification.Namespace, Name: notification.Configuration}, "event", notification.Event)
+
+	list := terapi.TerraformNotificationList{}
+	if err := n.List(ctx, &list, client.InNamespace(notification.Namespace)); err != nil {
+		log.Error(err, "unable to list TerraformNotifications")
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range list.Items {
+		tfnotification := &list.Items[i]
+		ok, err := selects(tfnotification, notification)
+		if err != nil {
+			log.Info("invalid TerraformNotification", "notification", tfnotification.Name, "error", err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.deliver(ctx, log.WithValues("notification", tfnotification.Name), tfnotification, notification)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver posts the notification retrying with backoff and records the result
+func (n *Notifier) deliver(ctx context.Context, log logr.Logger, tfnotification *terapi.TerraformNotification, notification Notification) {
+	attempts := int32(0)
+	err := n.post(ctx, tfnotification, notification, &attempts)
+	if err != nil {
+		log.Info("notification is not delivered", "attempts", attempts, "error", err.Error())
+	} else {
+		log.V(1).Info("notification delivered", "attempts", attempts)
+	}
+
+	delivery := &terapi.TerraformNotificationDelivery{
+		Event:         notification.Event,
+		Configuration: notification.Configuration,
+		Time:          metav1.Now(),
+		Attempts:      attempts,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	key := client.ObjectKey{Namespace: tfnotification.Namespace, Name: tfnotification.Name}
+	statusErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := n.Get(ctx, key, tfnotification); err != nil {
+			return err
+		}
+		tfnotification.Status.LastNotification = delivery
+		return n.Status().Update(ctx, tfnotification)
+	})
+	if client.IgnoreNotFound(statusErr) != nil {
+		log.Error(statusErr, "unable to update TerraformNotification.Status")
+	}
+}
+
+// post sends the notification to the webhook, errors which are not worth
+// retrying stop the backoff early
+func (n *Notifier) post(ctx context.Context, tfnotification *terapi.TerraformNotification, notification Notification, attempts *int32) error {
+	url, err := n.webhookURL(ctx, tfnotification)
+	if err != nil {
+		return err
+	}
+
+	body, contentType, err := payload(tfnotification.Spec.Webhook.Format, notification)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	err = wait.ExponentialBackoff(n.Backoff, func() (bool, error) {
+		*attempts++
+		lastErr = n.send(ctx, url, contentType, body)
+		var permanent *permanentError
+		if errors.As(lastErr, &permanent) {
+			return false, lastErr
+		}
+		return lastErr == nil, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+func (n *Notifier) send(ctx context.Context, url, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := n.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	default:
+		return &permanentError{fmt.Errorf("webhook responded with %s", resp.Status)}
+	}
+}
+
+// webhookURL returns URL of the webhook, taken from the Secret if it's
+// referenced
+func (n *Notifier) webhookURL(ctx context.Context, tfnotification *terapi.TerraformNotification) (string, error) {
+	webhook := tfnotification.Spec.Webhook
+	if webhook.URLFrom == nil {
+		if webhook.URL == "" {
+			return "", &permanentError{errors.New("webhook URL is not set")}
+		}
+		return webhook.URL, nil
+	}
+
+	secret := corev1.Secret{}
+	key := client.ObjectKey{Namespace: tfnotification.Namespace, Name: webhook.URLFrom.Name}
+	if err := n.Get(ctx, key, &secret); err != nil {
+		return "", &permanentError{fmt.Errorf("unable to get webhook URL: %w", err)}
+	}
+
+	url, ok := secret.Data[webhook.URLFrom.Key]
+	if !ok {
+		return "", &permanentError{fmt.Errorf("secret %s has no %s key", webhook.URLFrom.Name, webhook.URLFrom.Key)}
+	}
+	return string(bytes.TrimSpace(url)), nil
+}
+
+// selects checks whether TerraformNotification wants the notification
+func selects(tfnotification *terapi.TerraformNotification, notification Notification) (bool, error) {
+	spec := tfnotification.Spec
+
+	if len(spec.Events) > 0 {
+		found := false
+		for _, event := range spec.Events {
+			found = found || event == notification.Event
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if spec.Selector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(notification.Labels)), nil
+}
+
+// permanentError is delivery error retrying won't help with
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+type webhook struct {
+	mu          sync.Mutex
+	statuses    []int
+	bodies      []string
+	contentType string
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.bodies = append(w.bodies, string(body))
+	w.contentType = req.Header.Get("Content-Type")
+
+	status := http.StatusOK
+	if len(w.statuses) > 0 {
+		status, w.statuses = w.statuses[0], w.statuses[1:]
+	}
+	rw.WriteHeader(status)
+}
+
+func newTestNotifier(objs ...runtime.Object) *Notifier {
+	scheme := runtime.NewScheme()
+	_ = terapi.AddToScheme(scheme)
+	_ = corev1.AddToScheme(scheme)
+
+	n := New(fake.NewFakeClientWithScheme(scheme, objs...), zap.Logger(true))
+	n.Backoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+	return n
+}
+
+func testNotification(event terapi.TerraformNotificationEvent) Notification {
+	return Notification{
+		Event:         event,
+		Namespace:     "default",
+		Configuration: "test",
+		Phase:         terapi.TerraformPhaseWaitingApproval,
+		Run:           3,
+		Summary:       "Plan: 1 to add, 0 to change, 0 to destroy.",
+		Time:          metav1.NewTime(time.Date(2019, 11, 1, 12, 0, 0, 0, time.UTC)),
+		Labels:        map[string]string{"team": "infra"},
+	}
+}
+
+func testTerraformNotification(name, url string) *terapi.TerraformNotification {
+	return &terapi.TerraformNotification{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: terapi.TerraformNotificationSpec{
+			Webhook: terapi.TerraformNotificationWebhook{URL: url},
+		},
+	}
+}
+
+func lastNotification(t *testing.T, n *Notifier, name string) *terapi.TerraformNotificationDelivery {
+	t.Helper()
+	tfnotification := terapi.TerraformNotification{}
+	if err := n.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &tfnotification); err != nil {
+		t.Fatal(err)
+	}
+	return tfnotification.Status.LastNotification
+}
+
+func TestNotifyRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int32
+		wantError    string
+	}{
+		{
+			name:         "delivered",
+			wantAttempts: 1,
+		},
+		{
+			name:         "retried server error",
+			statuses:     []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			wantAttempts: 3,
+		},
+		{
+			name:         "client error is not retried",
+			statuses:     []int{http.StatusBadRequest},
+			wantAttempts: 1,
+			wantError:    "webhook responded with 400 Bad Request",
+		},
+		{
+			name:         "given up",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantAttempts: 3,
+			wantError:    "webhook responded with 502 Bad Gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &webhook{statuses: tt.statuses}
+			server := httptest.NewServer(hook)
+			defer server.Close()
+
+			n := newTestNotifier(testTerraformNotification("hook", server.URL))
+			n.notify(context.Background(), testNotification(terapi.TerraformNotificationWaitingApproval))
+
+			if len(hook.bodies) != int(tt.wantAttempts) {
+				t.Errorf("webhook got %d requests, want %d", len(hook.bodies), tt.wantAttempts)
+			}
+
+			delivery := lastNotification(t, n, "hook")
+			if delivery == nil {
+				t.Fatal("last notification is not recorded")
+			}
+			if delivery.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", delivery.Attempts, tt.wantAttempts)
+			}
+			if delivery.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", delivery.Error, tt.wantError)
+			}
+			if delivery.Event != terapi.TerraformNotificationWaitingApproval || delivery.Configuration != "test" {
+				t.Errorf("unexpected delivery %+v", delivery)
+			}
+		})
+	}
+}
+
+func TestNotifySelects(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	failures := testTerraformNotification("failures", server.URL)
+	failures.Spec.Events = []terapi.TerraformNotificationEvent{terapi.TerraformNotificationFailed}
+
+	otherTeam := testTerraformNotification("other-team", server.URL)
+	otherTeam.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "apps"}}
+
+	infraTeam := testTerraformNotification("infra-team", server.URL)
+	infraTeam.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "infra"}}
+
+	n := newTestNotifier(failures, otherTeam, infraTeam)
+	n.notify(context.Background(), testNotification(terapi.TerraformNotificationApplied))
+
+	if len(hook.bodies) != 1 {
+		t.Errorf("webhook got %d requests, want 1", len(hook.bodies))
+	}
+	for name, want := range map[string]bool{"failures": false, "other-team": false, "infra-team": true} {
+		if got := lastNotification(t, n, name) != nil; got != want {
+			t.Errorf("%s notified = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNotifyURLFromSecret(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "slack", Namespace: "default"},
+		Data:       map[string][]byte{"url": []byte(server.URL + "\n")},
+	}
+	tfnotification := testTerraformNotification("slack", "")
+	tfnotification.Spec.Webhook.URLFrom = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "slack"},
+		Key:                  "url",
+	}
+	tfnotification.Spec.Webhook.Format = terapi.TerraformNotificationSlack
+
+	n := newTestNotifier(secret, tfnotification)
+	n.notify(context.Background(), testNotification(terapi.TerraformNotificationWaitingApproval))
+
+	if len(hook.bodies) != 1 {
+		t.Fatalf("webhook got %d requests, want 1", len(hook.bodies))
+	}
+	if !strings.Contains(hook.bodies[0], "kubeterra plan approve test -n default") {
+		t.Errorf("slack message %s has no approve hint", hook.bodies[0])
+	}
+	if delivery := lastNotification(t, n, "slack"); delivery == nil || delivery.Error != "" {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	notification := testNotification(terapi.TerraformNotificationDrifted)
+
+	t.Run("JSON", func(t *testing.T) {
+		body, contentType, err := payload("", notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != contentTypeJSON {
+			t.Errorf("content type = %q", contentType)
+		}
+		want := `{"event":"Drifted","namespace":"default","configuration":"test","phase":"WaitingApproval","run":3,"summary":"Plan: 1 to add, 0 to change, 0 to destroy.","time":"2019-11-01T12:00:00Z"}`
+		if string(body) != want {
+			t.Errorf("payload = %s, want %s", body, want)
+		}
+	})
+
+	t.Run("CloudEvents", func(t *testing.T) {
+		body, contentType, err := payload(terapi.TerraformNotificationCloudEvents, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != contentTypeCloudEvents {
+			t.Errorf("content type = %q", contentType)
+		}
+		event := cloudEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.SpecVersion != "1.0" || event.ID == "" || event.Time != "2019-11-01T12:00:00Z" {
+			t.Errorf("invalid event attributes %+v", event)
+		}
+		if want := "io.kubeterra.terraform.drifted"; event.Type != want {
+			t.Errorf("type = %q, want %q", event.Type, want)
+		}
+		if want := "/apis/terraform.kubeterra.io/v1beta1/namespaces/default/terraformconfigurations/test"; event.Source != want {
+			t.Errorf("source = %q, want %q", event.Source, want)
+		}
+		if event.Data.Summary != notification.Summary {
+			t.Errorf("data summary = %q, want %q", event.Data.Summary, notification.Summary)
+		}
+	})
+
+	t.Run("Slack", func(t *testing.T) {
+		body, _, err := payload(terapi.TerraformNotificationSlack, notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"text":"*default/test*: infrastructure drifted from the applied configuration\nPlan: 1 to add, 0 to change, 0 to destroy."}`
+		if string(body) != want {
+			t.Errorf("payload = %s, want %s", body, want)
+		}
+	})
+}
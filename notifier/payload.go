@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	contentTypeJSON        = "application/json"
+	contentTypeCloudEvents = "application/cloudevents+json"
+)
+
+// cloudEvent is CloudEvents v1.0 event in structured content mode
+type cloudEvent struct {
+	SpecVersion     string       `json:"specversion"`
+	Type            string       `json:"type"`
+	Source          string       `json:"source"`
+	ID              string       `json:"id"`
+	Time            string       `json:"time"`
+	DataContentType string       `json:"datacontenttype"`
+	Data            Notification `json:"data"`
+}
+
+// slackMessage is accepted by Slack incoming webhooks and compatible services
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// payload returns body of the webhook request in the format and its content
+// type. It's built once per delivery, so retries carry the same CloudEvent ID.
+func payload(format terapi.TerraformNotificationFormat, n Notification) ([]byte, string, error) {
+	switch format {
+	case terapi.TerraformNotificationCloudEvents:
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := json.Marshal(cloudEvent{
+			SpecVersion:     "1.0",
+			Type:            "io.kubeterra.terraform." + strings.ToLower(string(n.Event)),
+			Source:          fmt.Sprintf("/apis/%s/namespaces/%s/terraformconfigurations/%s", terapi.GroupVersion, n.Namespace, n.Configuration),
+			ID:              id,
+			Time:            n.Time.UTC().Format(time.RFC3339),
+			DataContentType: contentTypeJSON,
+			Data:            n,
+		})
+		return body, contentTypeCloudEvents, err
+	case terapi.TerraformNotificationSlack:
+		body, err := json.Marshal(slackMessage{Text: slackText(n)})
+		return body, contentTypeJSON, err
+	default:
+		body, err := json.Marshal(n)
+		return body, contentTypeJSON, err
+	}
+}
+
+func slackText(n Notification) string {
+	var title string
+	switch n.Event {
+	case terapi.TerraformNotificationWaitingApproval:
+		title = "plan waits for approval"
+	case terapi.TerraformNotificationFailed:
+		title = "terraform run failed"
+	case terapi.TerraformNotificationDrifted:
+		title = "infrastructure drifted from the applied configuration"
+	case terapi.TerraformNotificationApplied:
+		title = "configuration applied"
+	default:
+		title = string(n.Event)
+	}
+
+	text := fmt.Sprintf("*%s/%s*: %s", n.Namespace, n.Configuration, title)
+	if n.Summary != "" {
+		text += "\n" + n.Summary
+	}
+	if n.Message != "" {
+		text += "\n" + n.Message
+	}
+	if n.Event == terapi.TerraformNotificationWaitingApproval {
+		text += fmt.Sprintf("\nApprove with `kubeterra plan approve %s -n %s`", n.Configuration, n.Namespace)
+	}
+	return text
+}
@@ -17,26 +17,113 @@ limitations under the License.
 package resources
 
 const (
-	TerraformApplyAutoApproveScript = `
-terraform init -no-color
-exec terraform apply -no-color -input=false -auto-approve
-	`
-
-	TerraformPlanScript = `
-terraform init -no-color
-exec terraform plan -no-color -input=false
+	// waitForBackend polls readiness of the httpbackend sidecar, which is
+	// started along with terraform, for up to a minute. The sidecar
+	// certificate isn't verified as nothing secret is sent, terraform init
+	// reports the backend error if it never gets ready.
+	waitForBackend = `
+for i in $(seq 60); do
+	wget -q -O /dev/null --no-check-certificate "${KUBETERRA_BACKEND_ADDRESS}readyz" && break
+	sleep 1
+done`
+
+	// terraformInit initializes terraform with httpbackend sidecar as a backend,
+	// tracing is disabled to not leak backend credentials into the logs. CA of
+	// the httpbackend is appended to system roots, as http backend of
+	// terraform has no option to configure one.
+	terraformInit = waitForBackend + `
+{ cat /etc/ssl/certs/ca-certificates.crt 2>/dev/null || true; cat "${KUBETERRA_BACKEND_CA_FILE}"; } > /tmp/ca-certificates.crt
+export SSL_CERT_FILE=/tmp/ca-certificates.crt
+set +x
+terraform init -no-color -input=false \
+	-backend-config="address=${KUBETERRA_BACKEND_ADDRESS}" \
+	-backend-config="lock_address=${KUBETERRA_BACKEND_ADDRESS}" \
+	-backend-config="unlock_address=${KUBETERRA_BACKEND_ADDRESS}" \
+	-backend-config="username=${KUBETERRA_BACKEND_USERNAME}" \
+	-backend-config="password=${KUBETERRA_BACKEND_PASSWORD}"
+set -x`
+
+	// terraformOutputTail saves the tail of terraform output as termination
+	// message of the container, so it's kept once the pod is gone
+	terraformOutputTail = `
+tail -n 100 /tmp/terraform.log | tail -c 4000 > /dev/termination-log
 `
 
+	TerraformApplyAutoApproveScript = terraformInit + `
+terraform apply -no-color -input=false -auto-approve | tee /tmp/terraform.log` + terraformOutputTail
+
+	TerraformPlanScript = terraformInit + `
+terraform plan -no-color -input=false | tee /tmp/terraform.log` + terraformOutputTail
+
+	// TerraformHTTPBackendConfig is a partial backend configuration, the rest
+	// is passed with -backend-config during terraform init
 	TerraformHTTPBackendConfig = `
 terraform {
 	required_version = ">= 0.12"
-	backend "http" {
-		address        = "http://localhost:8081/"
-		lock_address   = "http://localhost:8081/"
-		unlock_address = "http://localhost:8081/"
-	}
+	backend "http" {}
 }
 `
 
+	// TerraformHTTPBackendFile is a name of the file with TerraformHTTPBackendConfig
+	TerraformHTTPBackendFile = "httpbackend.tf"
+
+	// HTTPBackendListen is an address httpbackend sidecar listens on
+	HTTPBackendListen = "localhost:8081"
+
+	// HTTPBackendAddress is an URL of the httpbackend sidecar
+	HTTPBackendAddress = "https://" + HTTPBackendListen + "/"
+
+	// HTTPBackendReadyzAddress is an URL of readiness probe of the httpbackend
+	// sidecar
+	HTTPBackendReadyzAddress = HTTPBackendAddress + "readyz"
+
+	// HTTPBackendTLSHosts are names httpbackend sidecar certificate is valid for
+	HTTPBackendTLSHosts = "localhost,127.0.0.1"
+
+	// BackendTLSDir is a directory where httpbackend sidecar certificate is mounted
+	BackendTLSDir = "/var/run/kubeterra/tls"
+
+	// BackendCADir is a directory where httpbackend CA is mounted into terraform container
+	BackendCADir = "/var/run/kubeterra/ca"
+
+	// BackendStateDir is a directory where volume of the filesystem state
+	// storage is mounted into httpbackend sidecar
+	BackendStateDir = "/var/lib/kubeterra/state"
+
+	// BackendCAFileEnv is an environment variable holding path to httpbackend CA
+	BackendCAFileEnv = "KUBETERRA_BACKEND_CA_FILE"
+
+	// BackendCAKey is a key of the per-run secret holding httpbackend CA
+	BackendCAKey = "ca.crt"
+
+	// BackendUsernameEnv is an environment variable holding httpbackend username
+	BackendUsernameEnv = "KUBETERRA_BACKEND_USERNAME"
+
+	// BackendPasswordEnv is an environment variable holding httpbackend password
+	BackendPasswordEnv = "KUBETERRA_BACKEND_PASSWORD"
+
+	// BackendUsernameKey is a key of the per-run secret holding httpbackend username
+	BackendUsernameKey = "username"
+
+	// BackendPasswordKey is a key of the per-run secret holding httpbackend password
+	BackendPasswordKey = "password"
+
 	LinkedTerraformConfigMapAnnotation = "linked-terraform-config-map"
+
+	LinkedBackendSecretAnnotation = "linked-backend-secret"
+
+	// TerraformCommandAnnotation on terraform pod tells if it runs "plan" or
+	// "apply"
+	TerraformCommandAnnotation = "terraform-command"
+
+	// PlanLabel on terraform pods and ConfigMaps with their logs names the
+	// TerraformPlan
+	PlanLabel = "terraform.kubeterra.io/plan"
+
+	// RunLabel on terraform pods and ConfigMaps with their logs holds
+	// sequence number of the terraform run
+	RunLabel = "terraform.kubeterra.io/run"
+
+	// TerraformLogKey is a key of ConfigMap holding terraform container logs
+	TerraformLogKey = "logs"
 )
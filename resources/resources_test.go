@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTerraformInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeterra-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	argsFile := filepath.Join(dir, "args")
+	stubs := map[string]string{
+		"wget":      "#!/bin/sh\nexit 0\n",
+		"terraform": "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done > " + argsFile + "\n",
+	}
+	for name, content := range stubs {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, []byte("ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// same as the terraform container runs it
+	cmd := exec.Command("/bin/sh", "-c", "set -xeu\n"+terraformInit)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + dir + ":" + os.Getenv("PATH"),
+		"KUBETERRA_BACKEND_ADDRESS=" + HTTPBackendAddress,
+		BackendCAFileEnv + "=" + caFile,
+		BackendUsernameEnv + "=user",
+		BackendPasswordEnv + "=s3cr3t",
+	}
+	var trace bytes.Buffer
+	cmd.Stdout = &trace
+	cmd.Stderr = &trace
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("script failed: %v\n%s", err, trace.String())
+	}
+
+	if strings.Contains(trace.String(), "s3cr3t") {
+		t.Errorf("password is traced:\n%s", trace.String())
+	}
+
+	args, err := ioutil.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"init",
+		"-backend-config=address=" + HTTPBackendAddress,
+		"-backend-config=username=user",
+		"-backend-config=password=s3cr3t",
+	} {
+		if !strings.Contains("\n"+string(args), "\n"+want+"\n") {
+			t.Errorf("terraform args miss %q:\n%s", want, args)
+		}
+	}
+}
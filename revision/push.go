@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package revision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+// ErrInvalidState is returned when pushed state isn't terraform state JSON
+var ErrInvalidState = errors.New("invalid state")
+
+// PushOptions configure Push
+type PushOptions struct {
+	// RunID of the terraform run pushing the state, recorded in its revision
+	RunID string
+
+	// Force pushes the state despite failed integrity check, corrupted
+	// existing state is replaced as well
+	Force bool
+}
+
+// PushResult describes the pushed state
+type PushResult struct {
+	// Serial of the pushed state
+	Serial int64
+
+	// Forced is the integrity check failure the state is pushed despite
+	Forced error
+
+	// HistoryErr is why revisions aren't recorded or pruned, the state is
+	// pushed regardless
+	HistoryErr error
+}
+
+// Push replaces terraform state in the store of the TerraformState locked with
+// lockID, recording both replaced and pushed states as revisions. State that
+// belongs to other lineage or is older than the stored one is rejected with
+// error matching statestore.ErrIntegrity, unless forced.
+func Push(ctx context.Context, c client.Client, state *terapi.TerraformState, store statestore.StateStore, lockID string, raw []byte, opts PushOptions) (*PushResult, error) {
+	incoming, err := statestore.ParseStateInfo(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	result := &PushResult{Serial: incoming.Serial}
+
+	existingRaw, err := store.Get(ctx)
+	switch {
+	case errors.Is(err, statestore.ErrEmptyState):
+		existingRaw = nil
+	case errors.Is(err, statestore.ErrCorruptedState) && opts.Force:
+		existingRaw = nil
+	case err != nil:
+		return nil, err
+	}
+
+	if existingRaw != nil {
+		existing, err := statestore.ParseStateInfo(existingRaw)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := statestore.CheckIntegrity(existing, incoming); err != nil {
+			if !opts.Force {
+				return nil, err
+			}
+			result.Forced = err
+		}
+
+		// existing state is recorded in case if it predates revision history
+		result.recordHistory(record(ctx, c, state, existingRaw, ""))
+	}
+
+	// Put re-reads the state and re-validates the lock on every attempt
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return store.Put(ctx, lockID, raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.recordHistory(record(ctx, c, state, raw, opts.RunID))
+	result.recordHistory(Prune(ctx, c, state))
+	return result, nil
+}
+
+// recordHistory keeps the first history error
+func (r *PushResult) recordHistory(err error) {
+	if r.HistoryErr == nil {
+		r.HistoryErr = err
+	}
+}
+
+// record records state revision, states too large to be recorded are skipped
+func record(ctx context.Context, c client.Client, state *terapi.TerraformState, raw []byte, runID string) error {
+	if err := Record(ctx, c, state, raw, runID); err != ErrStateTooLarge {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package revision
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name          string
+		pushed        []byte
+		force         bool
+		wantErr       error
+		wantForced    bool
+		wantStored    []byte
+		wantRevisions int
+	}{
+		{
+			name:          "newer serial",
+			pushed:        testState(3),
+			wantStored:    testState(3),
+			wantRevisions: 2,
+		},
+		{
+			name:       "older serial",
+			pushed:     testState(1),
+			wantErr:    statestore.ErrIntegrity,
+			wantStored: testState(2),
+		},
+		{
+			name:          "forced older serial",
+			pushed:        testState(1),
+			force:         true,
+			wantForced:    true,
+			wantStored:    testState(1),
+			wantRevisions: 2,
+		},
+		{
+			name:       "invalid state",
+			pushed:     []byte("not a state"),
+			wantErr:    ErrInvalidState,
+			wantStored: testState(2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			compressed, err := statestore.Compress(testState(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := &terapi.TerraformState{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec:       terapi.TerraformStateSpec{CompressedState: compressed},
+			}
+			c := newTestClient(state)
+
+			store, err := statestore.New(ctx, c, state, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Lock(ctx, &statestore.LockInfo{ID: "lock"}); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := Push(ctx, c, state, store, "lock", tt.pushed, PushOptions{Force: tt.force})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Push() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got := result.Forced != nil; got != tt.wantForced {
+					t.Errorf("forced = %v, want %v", result.Forced, tt.wantForced)
+				}
+				if result.HistoryErr != nil {
+					t.Errorf("history error = %v", result.HistoryErr)
+				}
+			}
+
+			stored, err := store.Get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(stored) != string(tt.wantStored) {
+				t.Errorf("stored state = %s, want %s", stored, tt.wantStored)
+			}
+			if revs := listRevisions(t, c); len(revs) != tt.wantRevisions {
+				t.Errorf("expected %d revisions, got %d", tt.wantRevisions, len(revs))
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package revision keeps history of terraform states as TerraformStateRevision
+// objects, recording it as states are pushed, and restores states from it
+package revision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+const (
+	// StateLabel holds name of the TerraformState revision belongs to
+	StateLabel = statestore.StateLabel
+
+	// SerialLabel holds serial of the revision state
+	SerialLabel = "terraform.kubeterra.io/serial"
+
+	// LineageLabel holds lineage of the revision state
+	LineageLabel = "terraform.kubeterra.io/lineage"
+
+	// RunIDLabel holds ID of the terraform run that pushed revision state
+	RunIDLabel = "terraform.kubeterra.io/run-id"
+
+	// DefaultHistoryLimit is how many revisions are kept by default
+	DefaultHistoryLimit = 10
+)
+
+// ErrStateTooLarge is returned when compressed state doesn't fit into revision
+var ErrStateTooLarge = errors.New("state is too large to record revision")
+
+type stateInfo struct {
+	Lineage string `json:"lineage"`
+	Serial  int64  `json:"serial"`
+}
+
+// HistoryLimit returns how many revisions of the state to keep
+func HistoryLimit(state *terapi.TerraformState) int {
+	limit, err := strconv.Atoi(state.Annotations[terapi.RevisionHistoryLimitAnnotation])
+	if err != nil || limit < 0 {
+		return DefaultHistoryLimit
+	}
+	return limit
+}
+
+// Record saves raw terraform state of the TerraformState as a revision. Same
+// content is saved only once.
+func Record(ctx context.Context, c client.Client, state *terapi.TerraformState, raw []byte, runID string) error {
+	if HistoryLimit(state) == 0 {
+		return nil
+	}
+
+	info := stateInfo{}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return err
+	}
+
+	data, err := statestore.Encode(ctx, c, state, raw)
+	if err != nil {
+		return err
+	}
+	if len(data) > statestore.MaxInlineSize {
+		return ErrStateTooLarge
+	}
+
+	sum := sha256.Sum256(raw)
+	labels := map[string]string{
+		StateLabel:  state.Name,
+		SerialLabel: strconv.FormatInt(info.Serial, 10),
+	}
+	setLabelIfValid(labels, LineageLabel, info.Lineage)
+	setLabelIfValid(labels, RunIDLabel, runID)
+
+	rev := &terapi.TerraformStateRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%s-%s", state.Name, hex.EncodeToString(sum[:5])),
+			Namespace:       state.Namespace,
+			Labels:          labels,
+			OwnerReferences: statestore.OwnerReferences(state),
+		},
+		Spec: terapi.TerraformStateRevisionSpec{
+			StateName: state.Name,
+			Serial:    info.Serial,
+			Lineage:   info.Lineage,
+			RunID:     runID,
+			Data:      data,
+		},
+	}
+
+	err = c.Create(ctx, rev)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// Prune deletes the oldest revisions of the TerraformState above its limit
+func Prune(ctx context.Context, c client.Client, state *terapi.TerraformState) error {
+	revs := &terapi.TerraformStateRevisionList{}
+	err := c.List(ctx, revs,
+		client.InNamespace(state.Namespace),
+		client.MatchingLabels{StateLabel: state.Name},
+	)
+	if err != nil {
+		return err
+	}
+
+	limit := HistoryLimit(state)
+	if len(revs.Items) <= limit {
+		return nil
+	}
+
+	items := revs.Items
+	sort.Slice(items, func(i, j int) bool {
+		ti, tj := items[i].CreationTimestamp, items[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return items[i].Spec.Serial < items[j].Spec.Serial
+	})
+
+	for i := range items[:len(items)-limit] {
+		if err := c.Delete(ctx, &items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load returns terraform state JSON of the revision
+func Load(ctx context.Context, c client.Reader, rev *terapi.TerraformStateRevision) ([]byte, error) {
+	stateKey := client.ObjectKey{Namespace: rev.Namespace, Name: rev.Spec.StateName}
+	return statestore.Decode(ctx, c, stateKey, rev.Spec.Data)
+}
+
+// Rollback returns restored state with the serial bumped above current state
+// serial, so terraform accepts it as the newest one. Current state may be
+// empty if it's lost.
+func Rollback(current, restored []byte) ([]byte, error) {
+	currentInfo := stateInfo{}
+	if len(current) > 0 {
+		if err := json.Unmarshal(current, &currentInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	restoredInfo := stateInfo{}
+	if err := json.Unmarshal(restored, &restoredInfo); err != nil {
+		return nil, err
+	}
+
+	// decode only the top level, to keep the rest of the state intact
+	state := map[string]json.RawMessage{}
+	if err := json.Unmarshal(restored, &state); err != nil {
+		return nil, err
+	}
+
+	serial := currentInfo.Serial
+	if restoredInfo.Serial > serial {
+		serial = restoredInfo.Serial
+	}
+	state["serial"] = json.RawMessage(strconv.FormatInt(serial+1, 10))
+
+	return json.Marshal(state)
+}
+
+func setLabelIfValid(labels map[string]string, key, value string) {
+	if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+		labels[key] = value
+	}
+}
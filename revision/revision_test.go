@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package revision
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+	"github.com/loodse/kubeterra/statestore"
+)
+
+const testLineage = "0dd5e7ed-5bb3-4bc8-a3b0-0f1c8a4f0d8a"
+
+func testState(serial int) []byte {
+	return []byte(fmt.Sprintf(`{"version":4,"serial":%d,"lineage":"%s","resources":[]}`, serial, testLineage))
+}
+
+func newTestClient(objs ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terapi.AddToScheme(scheme)
+	return fake.NewFakeClientWithScheme(scheme, objs...)
+}
+
+func listRevisions(t *testing.T, c client.Client) []terapi.TerraformStateRevision {
+	t.Helper()
+
+	revs := &terapi.TerraformStateRevisionList{}
+	if err := c.List(context.Background(), revs, client.MatchingLabels{StateLabel: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	return revs.Items
+}
+
+func TestRecord(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := &terapi.TerraformState{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	for _, serial := range []int{1, 2, 2} {
+		if err := Record(ctx, c, state, testState(serial), "run"); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	revs := listRevisions(t, c)
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revs))
+	}
+
+	for _, rev := range revs {
+		if rev.Labels[LineageLabel] != testLineage || rev.Labels[RunIDLabel] != "run" {
+			t.Errorf("unexpected labels %v", rev.Labels)
+		}
+		if rev.Labels[SerialLabel] != fmt.Sprint(rev.Spec.Serial) {
+			t.Errorf("serial label %q doesn't match serial %d", rev.Labels[SerialLabel], rev.Spec.Serial)
+		}
+	}
+
+	state.Annotations = map[string]string{terapi.RevisionHistoryLimitAnnotation: "0"}
+	if err := Record(ctx, c, state, testState(3), "run"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if revs := listRevisions(t, c); len(revs) != 2 {
+		t.Errorf("revision is not expected to be recorded with disabled history")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	var objs []runtime.Object
+	start := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		objs = append(objs, &terapi.TerraformStateRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("test-%d", i),
+				Namespace:         "default",
+				Labels:            map[string]string{StateLabel: "test"},
+				CreationTimestamp: metav1.NewTime(start.Add(time.Duration(i) * time.Minute)),
+			},
+			Spec: terapi.TerraformStateRevisionSpec{StateName: "test", Serial: int64(i)},
+		})
+	}
+
+	c := newTestClient(objs...)
+	state := &terapi.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Namespace:   "default",
+			Annotations: map[string]string{terapi.RevisionHistoryLimitAnnotation: "2"},
+		},
+	}
+
+	if err := Prune(context.Background(), c, state); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	revs := listRevisions(t, c)
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revs))
+	}
+	for _, rev := range revs {
+		if rev.Spec.Serial < 4 {
+			t.Errorf("revision %s is expected to be pruned", rev.Name)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    []byte
+		wantSerial int64
+	}{
+		{name: "newer current state", current: testState(7), wantSerial: 8},
+		{name: "older current state", current: testState(1), wantSerial: 4},
+		{name: "lost current state", current: nil, wantSerial: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Rollback(tt.current, testState(3))
+			if err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+
+			restored := struct {
+				stateInfo
+				Resources []interface{} `json:"resources"`
+			}{}
+			if err := json.Unmarshal(got, &restored); err != nil {
+				t.Fatal(err)
+			}
+
+			if restored.Serial != tt.wantSerial {
+				t.Errorf("serial = %d, want %d", restored.Serial, tt.wantSerial)
+			}
+			if restored.Lineage != testLineage || restored.Resources == nil {
+				t.Errorf("restored state is not intact: %s", got)
+			}
+		})
+	}
+}
+
+func TestRecordEncrypted(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "default"},
+		Data: map[string][]byte{
+			statestore.PrimaryKey: []byte("key1"),
+			"key1":                bytes.Repeat([]byte{1}, 32),
+		},
+	})
+	state := &terapi.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: terapi.TerraformStateSpec{
+			Encryption: &terapi.TerraformStateEncryption{KeySecretName: "keys"},
+		},
+	}
+
+	if err := Record(ctx, c, state, testState(1), "run"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	revs := listRevisions(t, c)
+	if len(revs) != 1 {
+		t.Fatalf("expected single revision, got %d", len(revs))
+	}
+
+	if bytes.Contains(revs[0].Spec.Data, []byte(testLineage)) {
+		t.Errorf("revision data is expected to be encrypted")
+	}
+
+	got, err := Load(ctx, c, &revs[0])
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !bytes.Equal(got, testState(1)) {
+		t.Errorf("Load() = %s, want %s", got, testState(1))
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+// crdStore keeps terraform state in TerraformState itself, locked with Lease
+// of the state. The lock is reflected in TerraformState status for users to
+// see, locks taken by earlier versions are only recorded in the status, and
+// are respected until the Lease is created.
+type crdStore struct {
+	client.Client
+	key  client.ObjectKey
+	lock *leaseLock
+}
+
+func (s *crdStore) Get(ctx context.Context) ([]byte, error) {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Load(ctx, s.Client, state)
+}
+
+func (s *crdStore) Put(ctx context.Context, lockID string, raw []byte) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	holder, _, err := s.holder(ctx, state)
+	if err != nil {
+		return err
+	}
+	if err := checkHolder(holder, lockID); err != nil {
+		return err
+	}
+
+	if err := Store(ctx, s.Client, state, raw); err != nil {
+		return err
+	}
+	if err := s.Update(ctx, state); err != nil {
+		return err
+	}
+
+	// leftovers are removed on the next write anyway
+	_ = Cleanup(ctx, s.Client, state)
+	return nil
+}
+
+func (s *crdStore) Lock(ctx context.Context, info *LockInfo) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	lease, err := s.lock.get(ctx)
+	if err != nil {
+		return err
+	}
+	if holder := lockFromStatus(&state.Status); lease == nil && holder != nil {
+		return lockedBy(holder)
+	}
+
+	lock, err := s.lock.acquire(ctx, lease, info)
+	if err != nil {
+		return err
+	}
+
+	// status only informs about the lock held by the Lease
+	_ = s.recordLock(ctx, lock)
+	return nil
+}
+
+func (s *crdStore) Unlock(ctx context.Context, lockID string) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	holder, lease, err := s.holder(ctx, state)
+	if err != nil {
+		return err
+	}
+	if err := checkHolder(holder, lockID); err != nil {
+		return err
+	}
+
+	if lease == nil {
+		return s.recordLock(ctx, nil)
+	}
+	if err := s.lock.release(ctx, lease); err != nil {
+		return err
+	}
+	_ = s.recordLock(ctx, nil)
+	return nil
+}
+
+func (s *crdStore) Holder(ctx context.Context) (*LockInfo, error) {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// expired lease is free to be acquired, so it's not held by anyone, but
+	// its holder can still use it until then
+	holder, lease, err := s.holder(ctx, state)
+	if err != nil || (lease != nil && leaseExpired(lease, time.Now())) {
+		return nil, err
+	}
+	return holder, nil
+}
+
+func (s *crdStore) RenewLock(ctx context.Context, lockID string) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	holder, lease, err := s.holder(ctx, state)
+	if err != nil {
+		return err
+	}
+	if err := checkHolder(holder, lockID); err != nil {
+		return err
+	}
+
+	// locks recorded in the status never expire
+	if lease == nil {
+		return nil
+	}
+	return s.lock.renew(ctx, lease)
+}
+
+func (s *crdStore) RenewInterval() time.Duration {
+	return s.lock.duration / 3
+}
+
+func (s *crdStore) Delete(ctx context.Context) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	state.Spec.State = nil
+	state.Spec.CompressedState = nil
+	state.Spec.Chunks = nil
+	if err := s.Update(ctx, state); err != nil {
+		return err
+	}
+
+	return Cleanup(ctx, s.Client, state)
+}
+
+// holder returns holder of the lock along with the Lease, which is nil for
+// locks recorded only in the status
+func (s *crdStore) holder(ctx context.Context, state *terapi.TerraformState) (*LockInfo, *coordinationv1.Lease, error) {
+	lease, err := s.lock.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lease == nil {
+		return lockFromStatus(&state.Status), nil, nil
+	}
+	return leaseHolder(lease), lease, nil
+}
+
+// recordLock reflects the lock in TerraformState status, nil lock clears it
+func (s *crdStore) recordLock(ctx context.Context, lock *LockInfo) error {
+	state, err := s.getState(ctx)
+	if err != nil {
+		return err
+	}
+
+	state.Status.LockID = ""
+	state.Status.LockedSince = nil
+	state.Status.Lock = nil
+	if lock != nil {
+		state.Status.LockID = lock.ID
+		state.Status.Lock = LockToStatus(lock)
+		state.Status.LockedSince = state.Status.Lock.Created
+	}
+	return s.Status().Update(ctx, state)
+}
+
+func (s *crdStore) getState(ctx context.Context) (*terapi.TerraformState, error) {
+	state := &terapi.TerraformState{}
+	if err := s.Client.Get(ctx, s.key, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// PrimaryKey is an entry of the encryption key Secret naming the key used
+	// to encrypt new writes
+	PrimaryKey = "primary"
+
+	// dataKeySize is a size of random AES-256 key encrypting a single write
+	dataKeySize = 32
+)
+
+// envelopeMagic prefixes encrypted data, gzip data never starts with it
+var envelopeMagic = []byte("kubeterra-aes-gcm-v1:")
+
+// envelope is encrypted state along with its data key encrypted with the key
+// from the Secret
+type envelope struct {
+	KeySecretName string `json:"keySecretName"`
+	KeyID         string `json:"keyID"`
+	EncryptedKey  []byte `json:"encryptedKey"`
+	Data          []byte `json:"data"`
+}
+
+// Encode compresses terraform state JSON and encrypts it with the primary key
+// if encryption of the TerraformState is configured. Every write is encrypted
+// with the current primary key, so rotated keys are phased out on the next
+// write.
+func Encode(ctx context.Context, c client.Reader, state *terapi.TerraformState, raw []byte) ([]byte, error) {
+	compressed, err := Compress(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Spec.Encryption == nil {
+		return compressed, nil
+	}
+
+	keySecretName := state.Spec.Encryption.KeySecretName
+	keys, err := loadKeys(ctx, c, state.Namespace, keySecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := string(keys[PrimaryKey])
+	key, ok := keys[keyID]
+	if keyID == "" || !ok {
+		return nil, fmt.Errorf("primary key %q is not found in Secret %s", keyID, keySecretName)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	additionalData := []byte(client.ObjectKey{Namespace: state.Namespace, Name: state.Name}.String())
+	env := envelope{
+		KeySecretName: keySecretName,
+		KeyID:         keyID,
+	}
+
+	if env.EncryptedKey, err = seal(key, dataKey, additionalData); err != nil {
+		return nil, err
+	}
+	if env.Data, err = seal(dataKey, compressed, additionalData); err != nil {
+		return nil, err
+	}
+
+	buf, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, envelopeMagic...), buf...), nil
+}
+
+// Decode decrypts, if it's encrypted, and decompresses terraform state of
+// TerraformState with the given key
+func Decode(ctx context.Context, c client.Reader, stateKey client.ObjectKey, data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, envelopeMagic) {
+		env := envelope{}
+		if err := json.Unmarshal(data[len(envelopeMagic):], &env); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedState, err)
+		}
+
+		keys, err := loadKeys(ctx, c, stateKey.Namespace, env.KeySecretName)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := keys[env.KeyID]
+		if !ok || env.KeyID == PrimaryKey {
+			return nil, fmt.Errorf("key %q is not found in Secret %s", env.KeyID, env.KeySecretName)
+		}
+
+		additionalData := []byte(stateKey.String())
+		dataKey, err := open(key, env.EncryptedKey, additionalData)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedState, err)
+		}
+
+		if data, err = open(dataKey, env.Data, additionalData); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedState, err)
+		}
+	}
+
+	raw, err := Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedState, err)
+	}
+	return raw, nil
+}
+
+func loadKeys(ctx context.Context, c client.Reader, namespace, name string) (map[string][]byte, error) {
+	secret := corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Errorf("unable to get encryption key Secret: %w", err)
+	}
+	return secret.Data, nil
+}
+
+// seal encrypts plaintext with AES-GCM, random nonce is prepended to the result
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
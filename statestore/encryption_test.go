@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+var testRaw = []byte(`{"version":4,"serial":1,"lineage":"test","resources":[{"password":"secret"}]}`)
+
+func newEncryptedState(t *testing.T, c client.Client, primary string) *terapi.TerraformState {
+	t.Helper()
+
+	keys := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "default"},
+		Data: map[string][]byte{
+			PrimaryKey: []byte(primary),
+			"key1":     bytes.Repeat([]byte{1}, 32),
+			"key2":     bytes.Repeat([]byte{2}, 32),
+		},
+	}
+	if err := c.Create(context.Background(), keys); err != nil {
+		t.Fatal(err)
+	}
+
+	return &terapi.TerraformState{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: terapi.TerraformStateSpec{
+			Encryption: &terapi.TerraformStateEncryption{KeySecretName: "keys"},
+		},
+	}
+}
+
+func envelopeKeyID(t *testing.T, data []byte) string {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		t.Fatal("data is expected to be encrypted")
+	}
+	env := envelope{}
+	if err := json.Unmarshal(data[len(envelopeMagic):], &env); err != nil {
+		t.Fatal(err)
+	}
+	return env.KeyID
+}
+
+func TestStoreLoadEncrypted(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := newEncryptedState(t, c, "key1")
+
+	if err := Store(ctx, c, state, testRaw); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	if len(state.Spec.CompressedState) != 0 || state.Spec.Chunks == nil {
+		t.Fatalf("encrypted state is expected to be stored in chunks")
+	}
+
+	for _, secret := range chunkSecrets(t, c) {
+		if bytes.Contains(secret.Data[ChunkKey], []byte("secret")) {
+			t.Errorf("chunk %s holds state in clear text", secret.Name)
+		}
+	}
+
+	got, err := Load(ctx, c, state)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !bytes.Equal(got, testRaw) {
+		t.Errorf("Load() = %s, want %s", got, testRaw)
+	}
+}
+
+func TestEncodeKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := newEncryptedState(t, c, "key1")
+	stateKey := client.ObjectKey{Namespace: state.Namespace, Name: state.Name}
+
+	old, err := Encode(ctx, c, state, testRaw)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if keyID := envelopeKeyID(t, old); keyID != "key1" {
+		t.Errorf("encrypted with %q, want key1", keyID)
+	}
+
+	keys := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "keys"}, keys); err != nil {
+		t.Fatal(err)
+	}
+	keys.Data[PrimaryKey] = []byte("key2")
+	if err := c.Update(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	// state written before rotation is still readable
+	got, err := Decode(ctx, c, stateKey, old)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !bytes.Equal(got, testRaw) {
+		t.Errorf("Decode() = %s, want %s", got, testRaw)
+	}
+
+	// next write is re-encrypted with the new primary key
+	rotated, err := Encode(ctx, c, state, got)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if keyID := envelopeKeyID(t, rotated); keyID != "key2" {
+		t.Errorf("encrypted with %q, want key2", keyID)
+	}
+
+	// retired key can be removed once everything is rewritten
+	delete(keys.Data, "key1")
+	if err := c.Update(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(ctx, c, stateKey, rotated); err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+	if _, err := Decode(ctx, c, stateKey, old); err == nil {
+		t.Errorf("Decode() is expected to fail without the key")
+	}
+}
+
+func TestDecodeOtherState(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := newEncryptedState(t, c, "key1")
+
+	data, err := Encode(ctx, c, state, testRaw)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	// encrypted state is bound to its TerraformState
+	_, err = Decode(ctx, c, client.ObjectKey{Namespace: "default", Name: "other"}, data)
+	if !errors.Is(err, ErrCorruptedState) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrCorruptedState)
+	}
+}
+
+func TestEncodeMissingPrimaryKey(t *testing.T) {
+	c := newTestClient()
+	state := newEncryptedState(t, c, "key3")
+
+	if _, err := Encode(context.Background(), c, state, testRaw); err == nil {
+		t.Errorf("Encode() is expected to fail without primary key")
+	}
+}
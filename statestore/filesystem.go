@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+// filesystemStore keeps terraform state in <name>.tfstate file of the
+// directory, locked with exclusively created <name>.tflock file
+type filesystemStore struct {
+	client.Reader
+	state *terapi.TerraformState
+	dir   string
+}
+
+func (s *filesystemStore) Get(ctx context.Context) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.statePath())
+	switch {
+	case os.IsNotExist(err):
+		return nil, ErrEmptyState
+	case err != nil:
+		return nil, err
+	case len(data) == 0:
+		return nil, ErrEmptyState
+	}
+
+	return Decode(ctx, s.Reader, client.ObjectKey{Namespace: s.state.Namespace, Name: s.state.Name}, data)
+}
+
+func (s *filesystemStore) Put(ctx context.Context, lockID string, raw []byte) error {
+	if err := s.checkLock(ctx, lockID); err != nil {
+		return err
+	}
+
+	data, err := Encode(ctx, s.Reader, s.state, raw)
+	if err != nil {
+		return err
+	}
+
+	// write into temporary file first, rename is atomic within a filesystem
+	tmp, err := ioutil.TempFile(s.dir, s.state.Name+".tfstate.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.statePath())
+}
+
+func (s *filesystemStore) Lock(ctx context.Context, info *LockInfo) error {
+	lock, err := marshalLock(newLock(info))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.lockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	switch {
+	case os.IsExist(err):
+		holder, err := s.Holder(ctx)
+		if err != nil {
+			return err
+		}
+		return lockedBy(holder)
+	case err != nil:
+		return err
+	}
+
+	if _, err := f.Write(lock); err != nil {
+		_ = f.Close()
+		_ = os.Remove(s.lockPath())
+		return err
+	}
+	return f.Close()
+}
+
+func (s *filesystemStore) Unlock(ctx context.Context, lockID string) error {
+	if err := s.checkLock(ctx, lockID); err != nil {
+		return err
+	}
+	return os.Remove(s.lockPath())
+}
+
+func (s *filesystemStore) Holder(_ context.Context) (*LockInfo, error) {
+	data, err := ioutil.ReadFile(s.lockPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLock(data)
+}
+
+func (s *filesystemStore) Delete(_ context.Context) error {
+	if err := os.Remove(s.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *filesystemStore) checkLock(ctx context.Context, lockID string) error {
+	holder, err := s.Holder(ctx)
+	if err != nil {
+		return err
+	}
+	return checkHolder(holder, lockID)
+}
+
+func (s *filesystemStore) statePath() string {
+	return filepath.Join(s.dir, s.state.Name+".tfstate")
+}
+
+func (s *filesystemStore) lockPath() string {
+	return filepath.Join(s.dir, s.state.Name+".tflock")
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrIntegrity is returned when pushed state belongs to other lineage or is
+// older than the stored one
+var ErrIntegrity = errors.New("state integrity check failed")
+
+// StateInfo identifies version of terraform state
+type StateInfo struct {
+	Version int    `json:"version"`
+	Lineage string `json:"lineage"`
+	Serial  int64  `json:"serial"`
+}
+
+// ParseStateInfo returns version information of terraform state JSON
+func ParseStateInfo(raw []byte) (*StateInfo, error) {
+	info := &StateInfo{}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// CheckIntegrity rejects incoming state that belongs to other lineage or is
+// older than existing one, the error matches ErrIntegrity
+func CheckIntegrity(existing, incoming *StateInfo) error {
+	switch {
+	case existing.Lineage != "" && incoming.Lineage != existing.Lineage:
+		return &integrityError{fmt.Sprintf("state lineage %q doesn't match stored lineage %q", incoming.Lineage, existing.Lineage)}
+	case incoming.Serial < existing.Serial:
+		return &integrityError{fmt.Sprintf("state serial %d is older than stored serial %d", incoming.Serial, existing.Serial)}
+	}
+	return nil
+}
+
+type integrityError struct {
+	msg string
+}
+
+func (e *integrityError) Error() string {
+	return e.msg
+}
+
+func (e *integrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	existing := &StateInfo{Version: 4, Lineage: "test", Serial: 2}
+
+	tests := []struct {
+		name     string
+		incoming *StateInfo
+		wantErr  bool
+	}{
+		{name: "newer serial", incoming: &StateInfo{Lineage: "test", Serial: 3}},
+		{name: "same serial", incoming: &StateInfo{Lineage: "test", Serial: 2}},
+		{name: "older serial", incoming: &StateInfo{Lineage: "test", Serial: 1}, wantErr: true},
+		{name: "other lineage", incoming: &StateInfo{Lineage: "other", Serial: 3}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckIntegrity(existing, tt.incoming)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckIntegrity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIntegrity) {
+				t.Errorf("CheckIntegrity() error = %v, want it to match %v", err, ErrIntegrity)
+			}
+		})
+	}
+}
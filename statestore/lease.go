@@ -0,0 +1,219 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// LeaseLockAnnotation on the Lease backing the lock holds JSON of the
+	// lock information
+	LeaseLockAnnotation = "terraform.kubeterra.io/lock"
+
+	// DefaultLockLeaseDuration is how long the lock Lease is valid without
+	// being renewed
+	DefaultLockLeaseDuration = time.Minute
+
+	leaseNamePrefix = "tfstate-"
+)
+
+// LockRenewer is implemented by stores whose locks expire unless renewed by
+// the holder
+type LockRenewer interface {
+	// RenewLock extends the lock held with lockID, ErrLocked is returned if
+	// it's not held with lockID anymore
+	RenewLock(ctx context.Context, lockID string) error
+
+	// RenewInterval is how often the lock should be renewed
+	RenewInterval() time.Duration
+}
+
+// AsLockRenewer returns LockRenewer of the store, if its locks expire
+func AsLockRenewer(store StateStore) (LockRenewer, bool) {
+	if expiring, ok := store.(*expiringStore); ok {
+		store = expiring.StateStore
+	}
+	renewer, ok := store.(LockRenewer)
+	return renewer, ok
+}
+
+// leaseLock is a lock backed by coordination Lease named after the
+// TerraformState. The Lease is always updated at the version it was read, so
+// only one of concurrent lock attempts wins. The Lease is kept once unlocked,
+// and is garbage collected along with the TerraformState.
+type leaseLock struct {
+	client.Client
+	state    *terapi.TerraformState
+	duration time.Duration
+}
+
+// get returns the Lease, nil if it doesn't exist yet
+func (l *leaseLock) get(ctx context.Context) (*coordinationv1.Lease, error) {
+	lease := &coordinationv1.Lease{}
+	key := client.ObjectKey{Namespace: l.state.Namespace, Name: leaseNamePrefix + l.state.Name}
+
+	err := l.Get(ctx, key, lease)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return lease, nil
+}
+
+// acquire takes the lease, unless it's held and not expired yet. lease is nil
+// if it doesn't exist yet.
+func (l *leaseLock) acquire(ctx context.Context, lease *coordinationv1.Lease, info *LockInfo) (*LockInfo, error) {
+	if holder := leaseHolder(lease); holder != nil && !leaseExpired(lease, time.Now()) {
+		return nil, lockedBy(holder)
+	}
+
+	lock := newLock(info)
+	data, err := marshalLock(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := lease != nil
+	if !exists {
+		lease = l.newLease()
+	}
+
+	if leaseHolder(lease) != nil {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.LeaseTransitions = &transitions
+	}
+
+	seconds := int32(l.duration / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	acquired := metav1.NewMicroTime(lock.Created)
+	lease.Spec.HolderIdentity = &lock.ID
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.AcquireTime = &acquired
+	lease.Spec.RenewTime = &acquired
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[LeaseLockAnnotation] = string(data)
+
+	if exists {
+		err = l.Update(ctx, lease)
+	} else {
+		err = l.Create(ctx, lease)
+	}
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		return nil, l.lostRace(ctx, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// release frees the lease, the holder is expected to be checked already
+func (l *leaseLock) release(ctx context.Context, lease *coordinationv1.Lease) error {
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	delete(lease.Annotations, LeaseLockAnnotation)
+	return l.Update(ctx, lease)
+}
+
+// renew extends the lease, the holder is expected to be checked already
+func (l *leaseLock) renew(ctx context.Context, lease *coordinationv1.Lease) error {
+	renewed := metav1.NewMicroTime(time.Now().UTC())
+	lease.Spec.RenewTime = &renewed
+	return l.Update(ctx, lease)
+}
+
+// lostRace returns the holder which won concurrent lock attempt, or the
+// original error if the lease was updated but is still not held
+func (l *leaseLock) lostRace(ctx context.Context, err error) error {
+	lease, getErr := l.get(ctx)
+	if getErr != nil {
+		return getErr
+	}
+	if holder := leaseHolder(lease); holder != nil {
+		return lockedBy(holder)
+	}
+	return err
+}
+
+func (l *leaseLock) newLease() *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       l.state.Namespace,
+			Name:            leaseNamePrefix + l.state.Name,
+			Labels:          map[string]string{StateLabel: l.state.Name},
+			OwnerReferences: OwnerReferences(l.state),
+		},
+	}
+}
+
+// leaseHolder returns holder of the lease, nil if the lease doesn't exist or
+// is not held. Expiry isn't considered, see leaseExpired.
+func leaseHolder(lease *coordinationv1.Lease) *LockInfo {
+	if lease == nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return nil
+	}
+
+	id := *lease.Spec.HolderIdentity
+	if info, err := unmarshalLock([]byte(lease.Annotations[LeaseLockAnnotation])); err == nil && info != nil && info.ID == id {
+		return info
+	}
+
+	// lock information is lost, only ID is known
+	info := &LockInfo{ID: id}
+	if lease.Spec.AcquireTime != nil {
+		info.Created = lease.Spec.AcquireTime.Time
+	}
+	return info
+}
+
+// leaseExpired tells if the lease hasn't been renewed for its duration
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+
+	renewed := lease.Spec.RenewTime
+	if renewed == nil {
+		renewed = lease.Spec.AcquireTime
+	}
+	if renewed == nil {
+		return true
+	}
+
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return renewed.Add(duration).Before(now)
+}
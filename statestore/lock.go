@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+// LockInfo is lock information terraform sends along with lock requests,
+// and expects back when the state is already locked
+type LockInfo struct {
+	ID        string
+	Operation string
+	Info      string
+	Who       string
+	Version   string
+	Created   time.Time
+	Path      string
+}
+
+// LockedError is returned when state is locked by other holder, it matches
+// ErrLocked
+type LockedError struct {
+	// Holder of the lock, if known
+	Holder *LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return ErrLocked.Error()
+	}
+	return fmt.Sprintf("%s by %q (lock ID %s)", ErrLocked, e.Holder.Who, e.Holder.ID)
+}
+
+// Is makes LockedError match ErrLocked
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// lockedBy returns error for the state locked by holder, or locked with other
+// ID than expected when holder is nil
+func lockedBy(holder *LockInfo) error {
+	if holder == nil {
+		return ErrLocked
+	}
+	return &LockedError{Holder: holder}
+}
+
+// checkHolder returns error unless the lock is held with lockID
+func checkHolder(holder *LockInfo, lockID string) error {
+	switch {
+	case holder == nil:
+		return ErrLocked
+	case holder.ID != lockID:
+		return lockedBy(holder)
+	}
+	return nil
+}
+
+// newLock returns copy of info to be stored, with creation time set by
+// kubeterra, so expiration doesn't depend on the clock of the holder
+func newLock(info *LockInfo) *LockInfo {
+	lock := *info
+	lock.Created = time.Now().UTC()
+	return &lock
+}
+
+func marshalLock(info *LockInfo) ([]byte, error) {
+	return json.Marshal(info)
+}
+
+// unmarshalLock parses stored lock, data holding no lock means no holder
+func unmarshalLock(data []byte) (*LockInfo, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	info := &LockInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("invalid lock: %w", err)
+	}
+	return info, nil
+}
+
+// LockToStatus converts lock information to TerraformState representation
+func LockToStatus(info *LockInfo) *terapi.TerraformStateLock {
+	created := metav1.NewTime(info.Created)
+	return &terapi.TerraformStateLock{
+		ID:        info.ID,
+		Operation: info.Operation,
+		Info:      info.Info,
+		Who:       info.Who,
+		Version:   info.Version,
+		Created:   &created,
+		Path:      info.Path,
+	}
+}
+
+// lockFromStatus returns holder of the TerraformState lock, only ID is known
+// for locks taken by earlier versions
+func lockFromStatus(status *terapi.TerraformStateStatus) *LockInfo {
+	if status.LockID == "" {
+		return nil
+	}
+
+	info := &LockInfo{ID: status.LockID}
+	if status.LockedSince != nil {
+		info.Created = status.LockedSince.Time
+	}
+
+	if lock := status.Lock; lock != nil && lock.ID == status.LockID {
+		info.Operation = lock.Operation
+		info.Info = lock.Info
+		info.Who = lock.Who
+		info.Version = lock.Version
+		info.Path = lock.Path
+	}
+
+	return info
+}
+
+// expiringStore breaks locks held for longer than ttl on the next lock attempt
+type expiringStore struct {
+	StateStore
+	ttl time.Duration
+}
+
+func (s *expiringStore) Lock(ctx context.Context, info *LockInfo) error {
+	err := s.StateStore.Lock(ctx, info)
+
+	lockedErr := &LockedError{}
+	if !errors.As(err, &lockedErr) || lockedErr.Holder == nil || time.Since(lockedErr.Holder.Created) < s.ttl {
+		return err
+	}
+
+	// only the expired lock is released, in case it's been broken already
+	if err := s.StateStore.Unlock(ctx, lockedErr.Holder.ID); err != nil {
+		return err
+	}
+	return s.StateStore.Lock(ctx, info)
+}
+
+// Holder reports no holder for the expired lock, as it's broken by the next
+// lock attempt anyway
+func (s *expiringStore) Holder(ctx context.Context) (*LockInfo, error) {
+	holder, err := s.StateStore.Holder(ctx)
+	if err != nil || holder == nil || time.Since(holder.Created) < s.ttl {
+		return holder, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// S3AccessKeyIDKey is a key of the credentials Secret holding access key ID
+	S3AccessKeyIDKey = "accessKeyID"
+
+	// S3SecretAccessKeyKey is a key of the credentials Secret holding secret
+	// access key
+	S3SecretAccessKeyKey = "secretAccessKey"
+
+	s3DefaultRegion = "us-east-1"
+	s3TimeFormat    = "20060102T150405Z"
+)
+
+// s3Store keeps terraform state as an object of S3 compatible API, locked
+// with a lock object created only if it doesn't exist yet
+type s3Store struct {
+	client.Reader
+	httpClient *http.Client
+	state      *terapi.TerraformState
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	key        string
+	creds      s3Credentials
+}
+
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+}
+
+func newS3Store(ctx context.Context, c client.Reader, state *terapi.TerraformState, storage *terapi.S3StateStorage) (StateStore, error) {
+	endpoint, err := url.Parse(storage.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	secret := corev1.Secret{}
+	secretKey := client.ObjectKey{Namespace: state.Namespace, Name: storage.CredentialsSecretName}
+	if err := c.Get(ctx, secretKey, &secret); err != nil {
+		return nil, fmt.Errorf("unable to get S3 credentials Secret: %w", err)
+	}
+
+	s := &s3Store{
+		Reader:     c,
+		httpClient: http.DefaultClient,
+		state:      state,
+		endpoint:   endpoint,
+		region:     storage.Region,
+		bucket:     storage.Bucket,
+		key:        storage.Key,
+		creds: s3Credentials{
+			accessKeyID:     string(secret.Data[S3AccessKeyIDKey]),
+			secretAccessKey: string(secret.Data[S3SecretAccessKeyKey]),
+		},
+	}
+
+	if s.region == "" {
+		s.region = s3DefaultRegion
+	}
+	if s.key == "" {
+		s.key = fmt.Sprintf("%s/%s.tfstate", state.Namespace, state.Name)
+	}
+
+	return s, nil
+}
+
+func (s *s3Store) Get(ctx context.Context) ([]byte, error) {
+	data, found, err := s.getObject(ctx, s.key)
+	switch {
+	case err != nil:
+		return nil, err
+	case !found || len(data) == 0:
+		return nil, ErrEmptyState
+	}
+
+	return Decode(ctx, s.Reader, client.ObjectKey{Namespace: s.state.Namespace, Name: s.state.Name}, data)
+}
+
+func (s *s3Store) Put(ctx context.Context, lockID string, raw []byte) error {
+	if err := s.checkLock(ctx, lockID); err != nil {
+		return err
+	}
+
+	data, err := Encode(ctx, s.Reader, s.state, raw)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, s.key, data, nil)
+	if err != nil {
+		return err
+	}
+	return s3Error(resp, http.StatusOK)
+}
+
+func (s *s3Store) Lock(ctx context.Context, info *LockInfo) error {
+	lock, err := marshalLock(newLock(info))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, s.lockKey(), lock, http.Header{"If-None-Match": {"*"}})
+	if err != nil {
+		return err
+	}
+
+	// conflict is returned to the loser of concurrent conditional writes
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		holder, err := s.Holder(ctx)
+		if err != nil {
+			return err
+		}
+		return lockedBy(holder)
+	}
+	return s3Error(resp, http.StatusOK)
+}
+
+func (s *s3Store) Unlock(ctx context.Context, lockID string) error {
+	if err := s.checkLock(ctx, lockID); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.lockKey(), nil, nil)
+	if err != nil {
+		return err
+	}
+	return s3Error(resp, http.StatusOK, http.StatusNoContent)
+}
+
+func (s *s3Store) Delete(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return s3Error(resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+func (s *s3Store) Holder(ctx context.Context) (*LockInfo, error) {
+	data, _, err := s.getObject(ctx, s.lockKey())
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLock(data)
+}
+
+func (s *s3Store) checkLock(ctx context.Context, lockID string) error {
+	holder, err := s.Holder(ctx)
+	if err != nil {
+		return err
+	}
+	return checkHolder(holder, lockID)
+}
+
+func (s *s3Store) lockKey() string {
+	return s.key + ".lock"
+}
+
+func (s *s3Store) getObject(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := ioutil.ReadAll(resp.Body)
+		return data, true, err
+	case http.StatusNotFound:
+		return nil, false, nil
+	}
+	return nil, false, s3Error(resp, http.StatusOK)
+}
+
+// do sends signed request for the object of the bucket, caller closes the
+// response body
+func (s *s3Store) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	signS3Request(req, body, s.creds, s.region, time.Now())
+	return s.httpClient.Do(req)
+}
+
+// s3Error closes the response and returns an error, unless its status is one
+// of the expected ones
+func s3Error(resp *http.Response, expected ...int) error {
+	defer resp.Body.Close()
+
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("S3 %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, msg)
+}
+
+// signS3Request signs the request with AWS Signature Version 4, all headers
+// set so far are signed
+func signS3Request(req *http.Request, body []byte, creds s3Credentials, region string, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.UTC().Format(s3TimeFormat)
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath URI encodes every byte of the path except unreserved
+// characters and slashes
+func s3EscapePath(path string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
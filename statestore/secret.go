@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// SecretStateKey is a key of the Secret storage holding encoded state
+	SecretStateKey = "tfstate"
+
+	// SecretLockAnnotation on the Secret storage holds JSON of the lock
+	// information
+	SecretLockAnnotation = "terraform.kubeterra.io/lock-id"
+)
+
+// secretStore keeps terraform state in a single Secret, locked with its
+// annotation. Concurrent updates are rejected by the API server, since the
+// Secret is always updated at the version it was read.
+type secretStore struct {
+	client.Client
+	state *terapi.TerraformState
+	name  string
+}
+
+func (s *secretStore) Get(ctx context.Context) ([]byte, error) {
+	secret, _, err := s.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := secret.Data[SecretStateKey]
+	if len(data) == 0 {
+		return nil, ErrEmptyState
+	}
+	return Decode(ctx, s.Client, s.stateKey(), data)
+}
+
+func (s *secretStore) Put(ctx context.Context, lockID string, raw []byte) error {
+	secret, _, err := s.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkHolder(secret, lockID); err != nil {
+		return err
+	}
+
+	data, err := Encode(ctx, s.Client, s.state, raw)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxInlineSize {
+		return fmt.Errorf("encoded state of %d bytes doesn't fit into Secret", len(data))
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[SecretStateKey] = data
+	return s.Update(ctx, secret)
+}
+
+func (s *secretStore) Lock(ctx context.Context, info *LockInfo) error {
+	secret, exists, err := s.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	holder, err := unmarshalLock([]byte(secret.Annotations[SecretLockAnnotation]))
+	if err != nil {
+		return err
+	}
+	if holder != nil {
+		return lockedBy(holder)
+	}
+
+	lock, err := marshalLock(newLock(info))
+	if err != nil {
+		return err
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[SecretLockAnnotation] = string(lock)
+
+	if !exists {
+		return s.Create(ctx, secret)
+	}
+	return s.Update(ctx, secret)
+}
+
+func (s *secretStore) Unlock(ctx context.Context, lockID string) error {
+	secret, _, err := s.getSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkHolder(secret, lockID); err != nil {
+		return err
+	}
+
+	delete(secret.Annotations, SecretLockAnnotation)
+	return s.Update(ctx, secret)
+}
+
+func (s *secretStore) Holder(ctx context.Context) (*LockInfo, error) {
+	secret, _, err := s.getSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalLock([]byte(secret.Annotations[SecretLockAnnotation]))
+}
+
+func (s *secretStore) checkHolder(secret *corev1.Secret, lockID string) error {
+	holder, err := unmarshalLock([]byte(secret.Annotations[SecretLockAnnotation]))
+	if err != nil {
+		return err
+	}
+	return checkHolder(holder, lockID)
+}
+
+func (s *secretStore) Delete(ctx context.Context) error {
+	secret, exists, err := s.getSecret(ctx)
+	if err != nil || !exists {
+		return err
+	}
+
+	delete(secret.Data, SecretStateKey)
+	return s.Update(ctx, secret)
+}
+
+// getSecret returns the Secret storage and whether it already exists
+func (s *secretStore) getSecret(ctx context.Context) (*corev1.Secret, bool, error) {
+	secret := &corev1.Secret{}
+	err := s.Client.Get(ctx, client.ObjectKey{Namespace: s.state.Namespace, Name: s.name}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.name,
+				Namespace: s.state.Namespace,
+				Labels:    map[string]string{StateLabel: s.state.Name},
+			},
+			Type: corev1.SecretTypeOpaque,
+		}, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+	return secret, true, nil
+}
+
+func (s *secretStore) stateKey() client.ObjectKey {
+	return client.ObjectKey{Namespace: s.state.Namespace, Name: s.state.Name}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package statestore reads and writes terraform state of TerraformState
+// objects. State is stored gzip compressed and optionally encrypted, either in
+// TerraformState itself, split across chunk Secrets, or in the external storage
+// TerraformState points to. StateStore implementations hide the difference.
+package statestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+const (
+	// StateLabel holds name of the TerraformState chunk Secret belongs to
+	StateLabel = "terraform.kubeterra.io/state"
+
+	// ChunkSecretType is a type of Secrets holding state chunks
+	ChunkSecretType corev1.SecretType = "terraform.kubeterra.io/state-chunk"
+
+	// ChunkKey is a key of the chunk Secret holding the part of the state
+	ChunkKey = "chunk"
+
+	// MaxInlineSize is the largest compressed state stored in the object
+	// itself, leaving enough room under etcd ~1.5MiB limit for base64 encoding
+	// and metadata
+	MaxInlineSize = 1024 * 1024
+
+	// chunkSize fits into 1MiB limit of Secret data
+	chunkSize = 768 * 1024
+)
+
+var (
+	// ErrEmptyState is returned when TerraformState holds no state
+	ErrEmptyState = errors.New("state is empty")
+
+	// ErrCorruptedState is returned when stored state can't be reassembled
+	ErrCorruptedState = errors.New("state is corrupted")
+)
+
+// Load returns terraform state JSON of the TerraformState
+func Load(ctx context.Context, c client.Reader, state *terapi.TerraformState) ([]byte, error) {
+	spec := state.Spec
+	stateKey := client.ObjectKey{Namespace: state.Namespace, Name: state.Name}
+
+	switch {
+	case spec.Chunks != nil:
+		var compressed []byte
+		for _, name := range spec.Chunks.Secrets {
+			secret := corev1.Secret{}
+			err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: state.Namespace}, &secret)
+			switch {
+			case apierrors.IsNotFound(err):
+				return nil, fmt.Errorf("%w: chunk %s not found", ErrCorruptedState, name)
+			case err != nil:
+				return nil, err
+			}
+			compressed = append(compressed, secret.Data[ChunkKey]...)
+		}
+
+		if digest(compressed) != spec.Chunks.SHA256 {
+			return nil, fmt.Errorf("%w: chunks checksum mismatch", ErrCorruptedState)
+		}
+		return Decode(ctx, c, stateKey, compressed)
+	case len(spec.CompressedState) > 0:
+		return Decode(ctx, c, stateKey, spec.CompressedState)
+	case spec.State != nil && len(spec.State.Raw) > 0:
+		return spec.State.Raw, nil
+	}
+
+	return nil, ErrEmptyState
+}
+
+// Store encodes terraform state JSON into the TerraformState, creating chunk
+// Secrets if it's too large or encrypted. It's the caller's responsibility to
+// update TerraformState afterwards and then Cleanup chunks no longer in use, so
+// the state is replaced atomically.
+func Store(ctx context.Context, c client.Client, state *terapi.TerraformState, raw []byte) error {
+	compressed, err := Encode(ctx, c, state, raw)
+	if err != nil {
+		return err
+	}
+
+	state.Spec.State = nil
+	state.Spec.CompressedState = nil
+	state.Spec.Chunks = nil
+
+	// encrypted state is kept in Secrets, so RBAC separates readers of the
+	// TerraformState from readers of the state itself
+	if state.Spec.Encryption == nil && len(compressed) <= MaxInlineSize {
+		state.Spec.CompressedState = compressed
+		return nil
+	}
+
+	sum := digest(compressed)
+	chunks := &terapi.TerraformStateChunks{SHA256: sum, Size: int64(len(compressed))}
+
+	for i := 0; len(compressed) > 0; i++ {
+		size := chunkSize
+		if len(compressed) < size {
+			size = len(compressed)
+		}
+
+		// chunks are named by the content, so same state reuses them
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            fmt.Sprintf("%s-%s-%d", state.Name, sum[:10], i),
+				Namespace:       state.Namespace,
+				Labels:          map[string]string{StateLabel: state.Name},
+				OwnerReferences: OwnerReferences(state),
+			},
+			Type: ChunkSecretType,
+			Data: map[string][]byte{ChunkKey: compressed[:size]},
+		}
+
+		if err := c.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		chunks.Secrets = append(chunks.Secrets, secret.Name)
+		compressed = compressed[size:]
+	}
+
+	state.Spec.Chunks = chunks
+	return nil
+}
+
+// Cleanup deletes chunk Secrets of the TerraformState no longer referenced by it
+func Cleanup(ctx context.Context, c client.Client, state *terapi.TerraformState) error {
+	secrets := corev1.SecretList{}
+	err := c.List(ctx, &secrets,
+		client.InNamespace(state.Namespace),
+		client.MatchingLabels{StateLabel: state.Name},
+	)
+	if err != nil {
+		return err
+	}
+
+	inUse := map[string]bool{}
+	if state.Spec.Chunks != nil {
+		for _, name := range state.Spec.Chunks.Secrets {
+			inUse[name] = true
+		}
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if secret.Type != ChunkSecretType || inUse[secret.Name] {
+			continue
+		}
+		if err := c.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Compress gzip compresses data. Output is deterministic, so the same state
+// always compresses into the same bytes.
+func Compress(data []byte) ([]byte, error) {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress gzip compressed data
+func Decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// OwnerReferences makes TerraformState an owner, without BlockOwnerDeletion
+// that would require update permission on TerraformState finalizers
+func OwnerReferences(state *terapi.TerraformState) []metav1.OwnerReference {
+	return []metav1.OwnerReference{
+		{
+			APIVersion: terapi.GroupVersion.String(),
+			Kind:       "TerraformState",
+			Name:       state.Name,
+			UID:        state.UID,
+		},
+	}
+}
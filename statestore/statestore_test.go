@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+func newTestClient() client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = terapi.AddToScheme(scheme)
+	return fake.NewFakeClientWithScheme(scheme)
+}
+
+// largeState returns state JSON which doesn't compress well
+func largeState(t *testing.T, size int) []byte {
+	t.Helper()
+
+	buf := make([]byte, size/2)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return []byte(fmt.Sprintf(`{"version":4,"serial":1,"lineage":"test","garbage":"%s"}`, hex.EncodeToString(buf)))
+}
+
+func chunkSecrets(t *testing.T, c client.Client) []corev1.Secret {
+	t.Helper()
+
+	secrets := corev1.SecretList{}
+	if err := c.List(context.Background(), &secrets, client.MatchingLabels{StateLabel: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	return secrets.Items
+}
+
+func TestStoreLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         []byte
+		wantChunked bool
+	}{
+		{
+			name: "inline",
+			raw:  []byte(`{"version":4,"serial":1,"lineage":"test"}`),
+		},
+		{
+			name:        "chunked",
+			raw:         largeState(t, 4*MaxInlineSize),
+			wantChunked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestClient()
+			state := &terapi.TerraformState{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+			if err := Store(ctx, c, state, tt.raw); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			if !tt.wantChunked && (state.Spec.Chunks != nil || len(state.Spec.CompressedState) == 0) {
+				t.Errorf("state is expected to be stored inline")
+			}
+			if tt.wantChunked && (state.Spec.Chunks == nil || len(state.Spec.Chunks.Secrets) < 2) {
+				t.Errorf("state is expected to be chunked")
+			}
+			if state.Spec.Chunks != nil && len(chunkSecrets(t, c)) != len(state.Spec.Chunks.Secrets) {
+				t.Errorf("chunk secrets don't match chunks of the state")
+			}
+
+			got, err := Load(ctx, c, state)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.raw) {
+				t.Errorf("loaded state doesn't match stored one")
+			}
+		})
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	raw := []byte(`{"version":4,"serial":1,"lineage":"test"}`)
+	state := &terapi.TerraformState{
+		Spec: terapi.TerraformStateSpec{State: &runtime.RawExtension{Raw: raw}},
+	}
+
+	got, err := Load(context.Background(), newTestClient(), state)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Errorf("Load() = %s, want %s", got, raw)
+	}
+
+	if _, err := Load(context.Background(), newTestClient(), &terapi.TerraformState{}); err != ErrEmptyState {
+		t.Errorf("Load() error = %v, want %v", err, ErrEmptyState)
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := &terapi.TerraformState{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	if err := Store(ctx, c, state, largeState(t, 4*MaxInlineSize)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+
+	secret := chunkSecrets(t, c)[0]
+	secret.Data[ChunkKey] = []byte("garbage")
+	if err := c.Update(ctx, &secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(ctx, c, state); !errors.Is(err, ErrCorruptedState) {
+		t.Errorf("Load() error = %v, want %v", err, ErrCorruptedState)
+	}
+
+	if err := c.Delete(ctx, &secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(ctx, c, state); !errors.Is(err, ErrCorruptedState) {
+		t.Errorf("Load() error = %v, want %v", err, ErrCorruptedState)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+	state := &terapi.TerraformState{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+
+	if err := Store(ctx, c, state, largeState(t, 4*MaxInlineSize)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	previousChunks := len(state.Spec.Chunks.Secrets)
+
+	if err := Store(ctx, c, state, largeState(t, 4*MaxInlineSize)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	latestChunks := len(state.Spec.Chunks.Secrets)
+
+	if got := len(chunkSecrets(t, c)); got != previousChunks+latestChunks {
+		t.Fatalf("expected chunks of both states, got %d", got)
+	}
+
+	if err := Cleanup(ctx, c, state); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	secrets := chunkSecrets(t, c)
+	if len(secrets) != latestChunks {
+		t.Fatalf("expected %d chunks of the latest state, got %d", latestChunks, len(secrets))
+	}
+	for _, secret := range secrets {
+		found := false
+		for _, name := range state.Spec.Chunks.Secrets {
+			found = found || name == secret.Name
+		}
+		if !found {
+			t.Errorf("chunk %s is expected to be deleted", secret.Name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The KubeTerra Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	terapi "github.com/loodse/kubeterra/api/v1beta1"
+)
+
+var (
+	// ErrLocked is returned when state is locked with other lock ID, or is
+	// not locked at all when the lock is expected
+	ErrLocked = errors.New("state is locked")
+
+	// ErrStorageUnavailable is returned when the storage of the state can't
+	// be reached from this process, e.g. volume of the filesystem storage is
+	// not mounted
+	ErrStorageUnavailable = errors.New("state storage is unavailable")
+)
+
+// StateStore holds terraform state of a single TerraformState
+type StateStore interface {
+	// Get returns terraform state JSON, ErrEmptyState if there is none yet
+	Get(ctx context.Context) ([]byte, error)
+
+	// Put replaces terraform state JSON, the state has to be locked with
+	// lockID
+	Put(ctx context.Context, lockID string, raw []byte) error
+
+	// Lock locks the state, LockedError with the current holder is returned
+	// if it's already locked
+	Lock(ctx context.Context, info *LockInfo) error
+
+	// Unlock releases the lock, ErrLocked is returned if the state is not
+	// locked with lockID
+	Unlock(ctx context.Context, lockID string) error
+
+	// Holder returns information about the current lock, nil if the state is
+	// not locked
+	Holder(ctx context.Context) (*LockInfo, error)
+
+	// Delete removes terraform state, the lock is left intact
+	Delete(ctx context.Context) error
+}
+
+// New returns StateStore selected by the storage of the TerraformState. dir is
+// where volume of the filesystem storage is mounted, the storage is
+// unavailable if it's empty.
+func New(ctx context.Context, c client.Client, state *terapi.TerraformState, dir string) (StateStore, error) {
+	store, err := newStore(ctx, c, state, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := durationAnnotation(state, terapi.LockTTLAnnotation, 0)
+	if err != nil || ttl == 0 {
+		return store, err
+	}
+	return &expiringStore{StateStore: store, ttl: ttl}, nil
+}
+
+func newStore(ctx context.Context, c client.Client, state *terapi.TerraformState, dir string) (StateStore, error) {
+	storage := state.Spec.Storage
+	stateKey := client.ObjectKey{Namespace: state.Namespace, Name: state.Name}
+
+	if storage == nil {
+		duration, err := durationAnnotation(state, terapi.LockLeaseDurationAnnotation, DefaultLockLeaseDuration)
+		if err != nil {
+			return nil, err
+		}
+		lock := &leaseLock{Client: c, state: state, duration: duration}
+		return &crdStore{Client: c, key: stateKey, lock: lock}, nil
+	}
+
+	selected := 0
+	for _, set := range []bool{storage.Secret != nil, storage.Filesystem != nil, storage.S3 != nil} {
+		if set {
+			selected++
+		}
+	}
+	if selected != 1 {
+		return nil, fmt.Errorf("exactly one state storage is expected, got %d", selected)
+	}
+
+	switch {
+	case storage.Secret != nil:
+		return &secretStore{Client: c, state: state, name: storage.Secret.Name}, nil
+	case storage.Filesystem != nil:
+		if dir == "" {
+			return nil, fmt.Errorf("%w: filesystem storage is only mounted into httpbackend", ErrStorageUnavailable)
+		}
+		return &filesystemStore{Reader: c, state: state, dir: dir}, nil
+	default:
+		return newS3Store(ctx, c, state, storage.S3)
+	}
+}
+
+// durationAnnotation parses positive duration from the annotation of the
+// TerraformState, def is returned if it's not set
+func durationAnnotation(state *terapi.TerraformState, annotation string, def time.Duration) (time.Duration, error) {
+	value, ok := state.Annotations[annotation]
+	if !ok {
+		return def, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q", annotation, value)
+	}
+	return duration, nil
+}